@@ -1,82 +1,95 @@
 package main
 
 import (
-	"crypto/sha256"
+	"flag"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/cache"
+	"github.com/kx0101/httpfromtcp/internal/proxy"
 	"github.com/kx0101/httpfromtcp/internal/request"
 	"github.com/kx0101/httpfromtcp/internal/response"
 	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/kx0101/httpfromtcp/internal/sse"
+	"github.com/kx0101/httpfromtcp/internal/websocket"
 )
 
-const port = 42069
+const defaultAddr = ":42069"
 
 func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) *server.HandlerError {
-		var status response.StatusCode
-		var body string
-
-		if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-			targetPath := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-			resp, err := http.Get("https://httpbin.org/" + targetPath)
-
-			if err != nil {
-				w.WriteStatusLine(response.InternalServerError)
-				w.Write([]byte(fmt.Sprintf("Failed to proxy request: %v", err)))
+	addr := flag.String("addr", defaultAddr, "TCP address to listen on")
+	unixSocket := flag.String("unix", "", "also listen on a Unix domain socket at this path")
+	certFiles := flag.String("tls-cert", "", "comma separated certificate files, serves HTTPS when set")
+	keyFiles := flag.String("tls-key", "", "comma separated key files, one per -tls-cert file")
+	clientCAs := flag.String("tls-client-ca", "", "comma separated CA files, requires client certificates signed by them")
+	h2c := flag.Bool("h2c", false, "also serve HTTP/2 without TLS, with prior knowledge or Upgrade: h2c")
+	connectHosts := flag.String("connect-hosts", "", "comma separated hosts CONNECT may tunnel to, tunnels are refused when empty")
+	flag.Parse()
+
+	var options []server.Option
+
+	if *h2c {
+		options = append(options, server.WithH2C())
+	}
 
-				return nil
-			}
+	if *certFiles != "" {
+		certs, err := loadCertificates(*certFiles, *keyFiles)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
 
-			defer resp.Body.Close()
+		defer certs.ReloadOnSIGHUP()()
+		defer certs.WatchFiles(time.Minute)()
 
-			w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+		var tlsOptions []server.TLSOption
 
-			for k, v := range resp.Header {
-				w.SetHeader(k, strings.Join(v, ", "))
+		if *clientCAs != "" {
+			pool, err := server.LoadCertPool(strings.Split(*clientCAs, ",")...)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
 			}
 
-			w.SetHeader("Transfer-Encoding", "chunked")
-			w.SetHeader("Trailer", "X-Content-SHA256, X-Content-Length")
-			w.Headers.Delete("Content-Length")
-
-			var fullBody = make([]byte, 0)
+			tlsOptions = append(tlsOptions, server.WithRequiredClientCerts(pool))
+		}
 
-			buf := make([]byte, 1024)
-			for {
-				n, err := resp.Body.Read(buf)
-				if n > 0 {
-					fmt.Printf("Read %d bytes from httpbin\n", n)
+		options = append(options, server.WithTLS(certs, tlsOptions...))
+	}
 
-					fullBody = append(fullBody, buf[:n]...)
-					w.WriteChunkedBody(buf[:n])
-				}
+	assets := server.FileServer("./assets", server.WithPrefix("/assets"), server.WithDirectoryListing())
 
-				if err == io.EOF {
-					break
-				}
+	var tunnels server.Handler
+	if *connectHosts != "" {
+		tunnels = server.ConnectProxy(server.WithAllowedHosts(strings.Split(*connectHosts, ",")...))
+	}
 
-				if err != nil {
-					break
-				}
-			}
+	httpbin := proxy.ReverseProxy(
+		&url.URL{Scheme: "https", Host: "httpbin.org"},
+		proxy.WithStripPrefix("/httpbin"),
+		proxy.WithCache(cache.New()),
+	)
 
-			w.WriteChunkedBodyDone()
+	server, err := server.ListenAndServe(*addr, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		var status response.StatusCode
+		var body string
 
-			trailers := headers.Headers{
-				"X-Content-SHA256": fmt.Sprintf("%x", sha256.Sum256(fullBody)),
-				"X-Content-Length": strconv.Itoa(len(fullBody)),
+		if req.RequestLine.Method == "CONNECT" {
+			if tunnels == nil {
+				return &server.HandlerError{Message: "Tunnels are not enabled", Status: response.Forbidden}
 			}
-			w.WriteTrailers(trailers)
 
-			return nil
+			return tunnels(w, req)
+		}
+
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
+			return httpbin(w, req)
 		}
 
 		if req.RequestLine.RequestTarget == "/video" {
@@ -90,38 +103,71 @@ func main() {
 				return nil
 			}
 
-			w.WriteStatusLine(response.OK)
+			defer file.Close()
 
-			w.SetHeader("Content-Type", "video/mp4")
-			w.Headers.Delete("Content-Length")
-			w.SetHeader("Transfer-Encoding", "chunked")
+			stat, err := file.Stat()
+			if err != nil {
+				fmt.Println("Error reading video file info:", err)
 
-			buf := make([]byte, 1024)
-			for {
-				n, err := file.Read(buf)
-				if n > 0 {
-					_, writeErr := w.WriteChunkedBody(buf[:n])
-					if writeErr != nil {
-						fmt.Println("Error writing chunked body:", writeErr)
-						break
-					}
-				}
+				w.WriteStatusLine(response.InternalServerError)
+				w.Write([]byte(fmt.Sprintf("Failed to read video: %v", err)))
 
-				if err == io.EOF {
-					break
-				}
+				return nil
+			}
+
+			err = response.ServeContent(w, req, "video/mp4", stat.ModTime(), file)
+			if err != nil {
+				fmt.Println("Error serving video:", err)
+			}
+
+			return nil
+		}
+
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/assets/") {
+			return assets(w, req)
+		}
 
+		if req.RequestLine.RequestTarget == "/ws" {
+			conn, herr := websocket.Upgrade(w, req)
+			if herr != nil {
+				return herr
+			}
+
+			for {
+				typ, message, err := conn.ReadMessage()
 				if err != nil {
-					break
+					return nil
+				}
+
+				if err := conn.WriteMessage(typ, message); err != nil {
+					return nil
 				}
 			}
+		}
 
-			_, err = w.WriteChunkedBodyDone()
+		if req.RequestLine.RequestTarget == "/events" {
+			stream, err := sse.NewStream(w)
 			if err != nil {
-				fmt.Println("Error writing chunked body done:", err)
+				fmt.Println("Error starting event stream:", err)
+				return nil
 			}
 
-			return nil
+			defer stream.Close()
+
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+
+			for i := 1; ; i++ {
+				select {
+				case <-stream.Done():
+					return nil
+				case now := <-ticker.C:
+					event := sse.Event{ID: strconv.Itoa(i), Event: "tick", Data: now.Format(time.RFC3339)}
+					if err := stream.Send(event); err != nil {
+						return nil
+					}
+				}
+			}
 		}
 
 		switch req.RequestLine.RequestTarget {
@@ -149,7 +195,7 @@ func main() {
 		w.Write([]byte(body))
 
 		return nil
-	})
+	}, options...)
 
 	if err != nil {
 		fmt.Println("Error:", err)
@@ -157,7 +203,17 @@ func main() {
 	}
 
 	defer server.Close()
-	fmt.Printf("server started on port %d\n", port)
+
+	if *unixSocket != "" {
+		if _, err := server.ListenUnix(*unixSocket, 0o660); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	for _, addr := range server.Addrs() {
+		fmt.Printf("server listening on %s %s\n", addr.Network(), addr)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -165,3 +221,19 @@ func main() {
 
 	fmt.Println("shutting down server")
 }
+
+func loadCertificates(certFiles, keyFiles string) (*server.Certificates, error) {
+	certs := strings.Split(certFiles, ",")
+	keys := strings.Split(keyFiles, ",")
+
+	if len(certs) != len(keys) {
+		return nil, fmt.Errorf("got %d certificate files but %d key files", len(certs), len(keys))
+	}
+
+	pairs := make([]server.CertKeyPair, len(certs))
+	for i := range certs {
+		pairs[i] = server.CertKeyPair{CertFile: certs[i], KeyFile: keys[i]}
+	}
+
+	return server.LoadCertificates(pairs...)
+}
@@ -0,0 +1,432 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultMaxObjectBytes = 1 << 20
+)
+
+// X-Cache values telling how a response was answered
+const (
+	Hit         = "HIT"
+	Miss        = "MISS"
+	Revalidated = "REVALIDATED"
+	Stale       = "STALE"
+)
+
+// unsafeMethods invalidate the stored response for their target, RFC 9111 section 4.4
+var unsafeMethods = []string{"POST", "PUT", "DELETE", "PATCH"}
+
+var connectionHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// Fetch sends a request to the origin, Cache.Do calls it on misses and to revalidate
+type Fetch func(req *client.Request) (*client.Response, error)
+
+// Cache is a shared HTTP cache following RFC 9111, it stores GET responses and answers
+// GET and HEAD requests from them
+type Cache struct {
+	store          Store
+	maxObjectBytes int64
+	now            func() time.Time
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+type Option func(*Cache)
+
+// WithStore replaces the default MemoryStore of DefaultMaxBytes
+func WithStore(store Store) Option {
+	return func(c *Cache) {
+		c.store = store
+	}
+}
+
+// WithMaxObjectBytes is the largest body that is stored, larger responses are passed on
+// without being cached
+func WithMaxObjectBytes(n int64) Option {
+	return func(c *Cache) {
+		c.maxObjectBytes = n
+	}
+}
+
+func New(options ...Option) *Cache {
+	c := &Cache{
+		store:          NewMemoryStore(DefaultMaxBytes),
+		maxObjectBytes: DefaultMaxObjectBytes,
+		now:            time.Now,
+		revalidating:   map[string]bool{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// Do answers req from the entry stored under key when it is fresh, or stale within its
+// stale-while-revalidate window, in which case it is refreshed in the background.
+// Otherwise the entry is revalidated, or req is fetched and the response stored when
+// RFC 9111 allows it. Every answer carries an X-Cache header, answers from the store
+// an Age header as well.
+func (c *Cache) Do(key string, req *client.Request, fetch Fetch) (*client.Response, error) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		resp, err := fetch(req)
+		if err == nil && slices.Contains(unsafeMethods, req.Method) && resp.StatusCode < 400 {
+			c.store.Delete(key)
+		}
+
+		return resp, err
+	}
+
+	// ranges are passed through, only complete responses are stored
+	if req.Headers.Exists("Range") {
+		return fetch(req)
+	}
+
+	cc := requestDirectives(req.Headers)
+
+	entry, ok := c.store.Get(key)
+	if ok && !entry.matches(req.Headers) {
+		ok = false
+	}
+
+	if !ok {
+		if cc.has("only-if-cached") {
+			return synthesized(response.GatewayTimeout), nil
+		}
+
+		return c.miss(key, req, fetch)
+	}
+
+	now := c.now()
+	age := entry.Age(now)
+	lifetime := entry.Lifetime()
+	stored := parseCacheControl(entry.Headers.Get("Cache-Control"))
+
+	mustValidate := stored.has("no-cache") || cc.has("no-cache")
+	if maxAge, ok := cc.seconds("max-age"); ok && age > maxAge {
+		mustValidate = true
+	}
+
+	if !mustValidate && age < lifetime {
+		return c.respond(entry, req, now, Hit), nil
+	}
+
+	if !mustValidate && c.mayServeStale(stored, age-lifetime) {
+		c.refresh(key, entry, req, fetch)
+		return c.respond(entry, req, now, Stale), nil
+	}
+
+	return c.revalidate(key, entry, req, fetch)
+}
+
+// mayServeStale checks the stale-while-revalidate window of RFC 5861 for an entry that
+// has been stale for staleness
+func (c *Cache) mayServeStale(stored directives, staleness time.Duration) bool {
+	if stored.has("must-revalidate") || stored.has("proxy-revalidate") || stored.has("s-maxage") {
+		return false
+	}
+
+	window, ok := stored.seconds("stale-while-revalidate")
+
+	return ok && staleness < window
+}
+
+// refresh revalidates entry in the background, once per key at a time
+func (c *Cache) refresh(key string, entry *Entry, req *client.Request, fetch Fetch) {
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+
+	c.revalidating[key] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, key)
+			c.mu.Unlock()
+		}()
+
+		resp, err := c.revalidate(key, entry, req, fetch)
+		if err != nil {
+			fmt.Println("Error revalidating cache entry:", err)
+			return
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// revalidate asks the origin whether entry is still valid, RFC 9111 section 4.3
+func (c *Cache) revalidate(key string, entry *Entry, req *client.Request, fetch Fetch) (*client.Response, error) {
+	out := withoutConditionals(req)
+
+	if etag := entry.Headers.Get("ETag"); etag != "" {
+		out.Headers.Set("If-None-Match", etag)
+	} else if lastModified := entry.Headers.Get("Last-Modified"); lastModified != "" {
+		out.Headers.Set("If-Modified-Since", lastModified)
+	}
+
+	requestTime := c.now()
+
+	resp, err := fetch(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != response.NotModified {
+		return c.keep(key, req, resp, requestTime)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	now := c.now()
+	updated := entry.freshened(resp.Headers, requestTime, now)
+	c.store.Set(key, updated)
+
+	return c.respond(updated, req, now, Revalidated), nil
+}
+
+// miss sends req without the client's own conditions, so the complete response can be
+// stored, the conditions are evaluated against it afterwards
+func (c *Cache) miss(key string, req *client.Request, fetch Fetch) (*client.Response, error) {
+	requestTime := c.now()
+
+	resp, err := fetch(withoutConditionals(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.keep(key, req, resp, requestTime)
+}
+
+// keep stores resp under key when it is storable and small enough, RFC 9111 section 3
+func (c *Cache) keep(key string, req *client.Request, resp *client.Response, requestTime time.Time) (*client.Response, error) {
+	if !c.storable(req, resp) {
+		// a fresh GET response that may not be stored replaces the stored one, errors
+		// leave it in place
+		if req.Method == "GET" && resp.StatusCode < 500 {
+			c.store.Delete(key)
+		}
+
+		resp.Headers.Set("X-Cache", Miss)
+
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxObjectBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	if int64(len(body)) > c.maxObjectBytes {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		resp.Headers.Set("X-Cache", Miss)
+
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	now := c.now()
+	entry := &Entry{
+		StatusCode:   resp.StatusCode,
+		Headers:      headers.Headers{},
+		Body:         body,
+		Vary:         map[string]string{},
+		RequestTime:  requestTime,
+		ResponseTime: now,
+	}
+
+	for k, v := range resp.Headers {
+		entry.Headers.Set(k, v)
+	}
+
+	// fields that only applied to the connection the response came in on are not
+	// stored, RFC 9111 section 3.1
+	for field := range strings.SplitSeq(resp.Headers.Get("Connection"), ",") {
+		entry.Headers.Delete(strings.TrimSpace(field))
+	}
+
+	for _, k := range connectionHeaders {
+		entry.Headers.Delete(k)
+	}
+
+	for name := range strings.SplitSeq(resp.Headers.Get("Vary"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			entry.Vary[name] = normalizeVaryValue(req.Headers.Get(name))
+		}
+	}
+
+	c.store.Set(key, entry)
+
+	return c.respond(entry, req, now, Miss), nil
+}
+
+func (c *Cache) storable(req *client.Request, resp *client.Response) bool {
+	if req.Method != "GET" {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case response.PartialContent, response.NotModified:
+		return false
+	}
+
+	if resp.StatusCode < 200 || len(resp.SetCookie) > 0 || resp.Headers.Exists("Trailer") {
+		return false
+	}
+
+	if resp.Headers.Get("Vary") == "*" {
+		return false
+	}
+
+	cc := parseCacheControl(resp.Headers.Get("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") || requestDirectives(req.Headers).has("no-store") {
+		return false
+	}
+
+	// responses to authenticated requests are private unless the origin says otherwise,
+	// RFC 9111 section 3.5
+	if req.Headers.Exists("Authorization") && !cc.has("public") && !cc.has("must-revalidate") && !cc.has("s-maxage") {
+		return false
+	}
+
+	if cc.has("public") || cc.has("max-age") || cc.has("s-maxage") || resp.Headers.Exists("Expires") {
+		return true
+	}
+
+	// without explicit freshness a response is only worth keeping when it can be
+	// revalidated or given a heuristic lifetime
+	return slices.Contains(heuristicStatuses, resp.StatusCode) &&
+		(resp.Headers.Exists("ETag") || resp.Headers.Exists("Last-Modified"))
+}
+
+// respond builds the answer to req from entry, a 304 when the client's own conditions
+// match the entry
+func (c *Cache) respond(entry *Entry, req *client.Request, now time.Time, state string) *client.Response {
+	h := headers.Headers{}
+	for k, v := range entry.Headers {
+		h.Set(k, v)
+	}
+
+	h.Set("Age", strconv.FormatInt(int64(entry.Age(now)/time.Second), 10))
+	h.Set("X-Cache", state)
+	h.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+
+	resp := &client.Response{
+		Proto:         "HTTP/1.1",
+		StatusCode:    entry.StatusCode,
+		Reason:        response.StatusText(entry.StatusCode),
+		Headers:       h,
+		Trailers:      headers.Headers{},
+		ContentLength: int64(len(entry.Body)),
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+	}
+
+	if entry.StatusCode == response.OK && notModified(req.Headers, entry.Headers) {
+		resp.StatusCode = response.NotModified
+		resp.Reason = response.StatusText(response.NotModified)
+	}
+
+	if req.Method == "HEAD" || resp.StatusCode == response.NotModified {
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+	}
+
+	return resp
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since without it, RFC 9110 section 13.2.2
+func notModified(req, stored headers.Headers) bool {
+	if ifNoneMatch := req.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(stored.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+
+		for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := headers.ParseTime(req.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := headers.ParseTime(stored.Get("Last-Modified"))
+
+	return err == nil && !lastModified.After(since)
+}
+
+// requestDirectives reads Cache-Control, falling back to Pragma: no-cache, RFC 9111 section 5.4
+func requestDirectives(h headers.Headers) directives {
+	if h.Exists("Cache-Control") {
+		return parseCacheControl(h.Get("Cache-Control"))
+	}
+
+	if headers.HasToken(h.Get("Pragma"), "no-cache") {
+		return directives{"no-cache": ""}
+	}
+
+	return directives{}
+}
+
+func withoutConditionals(req *client.Request) *client.Request {
+	out := *req
+	out.Headers = headers.Headers{}
+
+	for k, v := range req.Headers {
+		out.Headers.Set(k, v)
+	}
+
+	out.Headers.Delete("If-None-Match")
+	out.Headers.Delete("If-Modified-Since")
+
+	return &out
+}
+
+// synthesized is a response the cache makes up itself, such as the 504 for an
+// only-if-cached request that misses
+func synthesized(status response.StatusCode) *client.Response {
+	h := headers.Headers{}
+	h.Set("Content-Length", "0")
+
+	return &client.Response{
+		Proto:      "HTTP/1.1",
+		StatusCode: status,
+		Reason:     response.StatusText(status),
+		Headers:    h,
+		Trailers:   headers.Headers{},
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+	}
+}
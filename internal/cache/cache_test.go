@@ -0,0 +1,390 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// origin answers with respond and records the requests it received
+type origin struct {
+	mu       sync.Mutex
+	requests []*client.Request
+	respond  func(req *client.Request, n int) *client.Response
+}
+
+func (o *origin) fetch(req *client.Request) (*client.Response, error) {
+	o.mu.Lock()
+	o.requests = append(o.requests, req)
+	n := len(o.requests)
+	o.mu.Unlock()
+
+	return o.respond(req, n), nil
+}
+
+func (o *origin) calls() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.requests)
+}
+
+func (o *origin) last() *client.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.requests[len(o.requests)-1]
+}
+
+func newResponse(status response.StatusCode, fields map[string]string, body string) *client.Response {
+	h := headers.Headers{}
+	for k, v := range fields {
+		h.Set(k, v)
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &client.Response{
+		Proto:         "HTTP/1.1",
+		StatusCode:    status,
+		Headers:       h,
+		Trailers:      headers.Headers{},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(bytes.NewReader([]byte(body))),
+	}
+}
+
+func newRequest(t *testing.T, method string, fields map[string]string) *client.Request {
+	req, err := client.NewRequest(method, "http://origin.test/resource", nil)
+	require.NoError(t, err)
+
+	for k, v := range fields {
+		req.Headers.Set(k, v)
+	}
+
+	return req
+}
+
+func newTestCache(options ...Option) (*Cache, *fakeClock) {
+	clock := &fakeClock{now: epoch}
+
+	c := New(options...)
+	c.now = clock.Now
+
+	return c, clock
+}
+
+// get sends a GET through c and returns the X-Cache state and the body
+func get(t *testing.T, c *Cache, o *origin, fields map[string]string) (*client.Response, string) {
+	resp, err := c.Do("origin.test/resource", newRequest(t, "GET", fields), o.fetch)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp, string(body)
+}
+
+func TestFreshResponsesAreServedFromCache(t *testing.T) {
+	c, clock := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		if req.Headers.Get("If-None-Match") == `"v1"` {
+			return newResponse(response.NotModified, map[string]string{"Cache-Control": "max-age=60", "ETag": `"v1"`}, "")
+		}
+
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=60", "ETag": `"v1"`}, "hello")
+	}}
+
+	resp, body := get(t, c, o, nil)
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "hello", body)
+
+	clock.Advance(10 * time.Second)
+
+	resp, body = get(t, c, o, nil)
+	assert.Equal(t, Hit, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "10", resp.Headers.Get("Age"))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, 1, o.calls())
+
+	clock.Advance(time.Minute)
+
+	resp, body = get(t, c, o, nil)
+	assert.Equal(t, Revalidated, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "0", resp.Headers.Get("Age"))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, 2, o.calls())
+	assert.Equal(t, `"v1"`, o.last().Headers.Get("If-None-Match"))
+
+	resp, _ = get(t, c, o, nil)
+	assert.Equal(t, Hit, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, 2, o.calls())
+}
+
+func TestResponsesThatMustNotBeStored(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  map[string]string
+		response map[string]string
+		cookie   bool
+	}{
+		{name: "no-store", response: map[string]string{"Cache-Control": "no-store, max-age=60"}},
+		{name: "private", response: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{name: "no freshness and no validator", response: map[string]string{"Content-Type": "text/plain"}},
+		{name: "vary star", response: map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}},
+		{name: "set-cookie", response: map[string]string{"Cache-Control": "max-age=60"}, cookie: true},
+		{name: "request no-store", request: map[string]string{"Cache-Control": "no-store"}, response: map[string]string{"Cache-Control": "max-age=60"}},
+		{name: "authorization", request: map[string]string{"Authorization": "Bearer token"}, response: map[string]string{"Cache-Control": "max-age=60"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache()
+
+			o := &origin{respond: func(req *client.Request, n int) *client.Response {
+				resp := newResponse(response.OK, tt.response, "body")
+				if tt.cookie {
+					resp.SetCookie = []string{"session=1"}
+				}
+
+				return resp
+			}}
+
+			get(t, c, o, tt.request)
+			resp, _ := get(t, c, o, tt.request)
+
+			assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+			assert.Equal(t, 2, o.calls())
+		})
+	}
+}
+
+func TestAuthorizedResponsesMarkedPublicAreStored(t *testing.T) {
+	c, _ := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "public, max-age=60"}, "body")
+	}}
+
+	auth := map[string]string{"Authorization": "Bearer token"}
+
+	get(t, c, o, auth)
+	resp, _ := get(t, c, o, auth)
+
+	assert.Equal(t, Hit, resp.Headers.Get("X-Cache"))
+}
+
+func TestVarySelectsStoredResponse(t *testing.T) {
+	c, _ := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Encoding"}, req.Headers.Get("Accept-Encoding"))
+	}}
+
+	resp, body := get(t, c, o, map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "gzip", body)
+
+	resp, body = get(t, c, o, map[string]string{"Accept-Encoding": " gzip"})
+	assert.Equal(t, Hit, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "gzip", body)
+
+	resp, body = get(t, c, o, map[string]string{"Accept-Encoding": "br"})
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "br", body)
+}
+
+func TestRequestDirectives(t *testing.T) {
+	c, clock := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=60"}, "v"+strconv.Itoa(n))
+	}}
+
+	get(t, c, o, nil)
+	clock.Advance(30 * time.Second)
+
+	resp, body := get(t, c, o, map[string]string{"Cache-Control": "max-age=10"})
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "v2", body)
+
+	resp, body = get(t, c, o, map[string]string{"Pragma": "no-cache"})
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "v3", body)
+
+	resp, body = get(t, c, o, nil)
+	assert.Equal(t, Hit, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "v3", body)
+
+	other, err := c.Do("origin.test/other", newRequest(t, "GET", map[string]string{"Cache-Control": "only-if-cached"}), o.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, response.GatewayTimeout, other.StatusCode)
+	assert.Equal(t, 3, o.calls())
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	c, clock := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=1, stale-while-revalidate=30"}, "v"+strconv.Itoa(n))
+	}}
+
+	get(t, c, o, nil)
+	clock.Advance(5 * time.Second)
+
+	resp, body := get(t, c, o, nil)
+	assert.Equal(t, Stale, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "v1", body)
+
+	require.Eventually(t, func() bool {
+		return o.calls() == 2
+	}, time.Second, 5*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		_, body := get(t, c, o, nil)
+		return body == "v2"
+	}, time.Second, 5*time.Millisecond)
+
+	// past the window the client has to wait for the origin
+	clock.Advance(time.Minute)
+
+	resp, body = get(t, c, o, nil)
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "v3", body)
+}
+
+func TestConditionalRequestsAreAnsweredFromCache(t *testing.T) {
+	c, _ := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=60", "ETag": `"v1"`}, "hello")
+	}}
+
+	resp, body := get(t, c, o, map[string]string{"If-None-Match": `"v1"`})
+	assert.Equal(t, response.NotModified, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.False(t, o.last().Headers.Exists("If-None-Match"))
+
+	resp, body = get(t, c, o, map[string]string{"If-None-Match": `W/"v0", W/"v1"`})
+	assert.Equal(t, response.NotModified, resp.StatusCode)
+	assert.Empty(t, body)
+
+	resp, body = get(t, c, o, map[string]string{"If-None-Match": `"v0"`})
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, 1, o.calls())
+}
+
+func TestUnsafeMethodsInvalidate(t *testing.T) {
+	c, _ := newTestCache()
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=60"}, "v"+strconv.Itoa(n))
+	}}
+
+	get(t, c, o, nil)
+
+	_, err := c.Do("origin.test/resource", newRequest(t, "POST", nil), o.fetch)
+	require.NoError(t, err)
+
+	resp, body := get(t, c, o, nil)
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+	assert.Equal(t, "v3", body)
+}
+
+func TestLargeResponsesAreNotStored(t *testing.T) {
+	c, _ := newTestCache(WithMaxObjectBytes(4))
+
+	o := &origin{respond: func(req *client.Request, n int) *client.Response {
+		return newResponse(response.OK, map[string]string{"Cache-Control": "max-age=60"}, "too large")
+	}}
+
+	_, body := get(t, c, o, nil)
+	assert.Equal(t, "too large", body)
+
+	resp, _ := get(t, c, o, nil)
+	assert.Equal(t, Miss, resp.Headers.Get("X-Cache"))
+}
+
+func TestEntryLifetime(t *testing.T) {
+	date := headers.FormatTime(epoch)
+
+	tests := []struct {
+		name   string
+		status response.StatusCode
+		fields map[string]string
+		want   time.Duration
+	}{
+		{name: "s-maxage wins", fields: map[string]string{"Cache-Control": "max-age=10, s-maxage=20", "Expires": headers.FormatTime(epoch.Add(time.Hour))}, want: 20 * time.Second},
+		{name: "max-age wins over expires", fields: map[string]string{"Cache-Control": "max-age=10", "Expires": headers.FormatTime(epoch.Add(time.Hour))}, want: 10 * time.Second},
+		{name: "expires", fields: map[string]string{"Date": date, "Expires": headers.FormatTime(epoch.Add(time.Hour))}, want: time.Hour},
+		{name: "invalid expires", fields: map[string]string{"Date": date, "Expires": "0"}, want: 0},
+		{name: "heuristic", fields: map[string]string{"Date": date, "Last-Modified": headers.FormatTime(epoch.Add(-100 * time.Hour))}, want: 10 * time.Hour},
+		{name: "heuristic capped", fields: map[string]string{"Date": date, "Last-Modified": headers.FormatTime(epoch.Add(-1000 * time.Hour))}, want: MaxHeuristicLifetime},
+		{name: "no heuristic for 302", status: 302, fields: map[string]string{"Date": date, "Last-Modified": headers.FormatTime(epoch.Add(-100 * time.Hour))}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			if status == 0 {
+				status = response.OK
+			}
+
+			entry := &Entry{StatusCode: status, Headers: headers.Headers{}, ResponseTime: epoch}
+			for k, v := range tt.fields {
+				entry.Headers.Set(k, v)
+			}
+
+			assert.Equal(t, tt.want, entry.Lifetime())
+		})
+	}
+}
+
+func TestEntryAge(t *testing.T) {
+	entry := &Entry{
+		Headers:      headers.Headers{},
+		RequestTime:  epoch.Add(-2 * time.Second),
+		ResponseTime: epoch,
+	}
+
+	// corrected age: the Age header plus the time the response took
+	entry.Headers.Set("Age", "30")
+	assert.Equal(t, 42*time.Second, entry.Age(epoch.Add(10*time.Second)))
+
+	// apparent age: the response was generated long before it arrived
+	entry.Headers.Set("Date", headers.FormatTime(epoch.Add(-time.Minute)))
+	assert.Equal(t, 70*time.Second, entry.Age(epoch.Add(10*time.Second)))
+}
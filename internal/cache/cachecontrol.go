@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directives are the parsed Cache-Control directives, names lowercased and quoted
+// values unquoted, see RFC 9111 section 5.2
+type directives map[string]string
+
+func parseCacheControl(value string) directives {
+	d := directives{}
+
+	for part := range strings.SplitSeq(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+
+		if unquoted, err := strconv.Unquote(arg); err == nil {
+			arg = unquoted
+		}
+
+		d[strings.ToLower(name)] = arg
+	}
+
+	return d
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns a delta-seconds argument, invalid values count as absent
+func (d directives) seconds(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return time.Duration(n) * time.Second, true
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	// MaxHeuristicLifetime caps the freshness guessed from Last-Modified
+	MaxHeuristicLifetime = 24 * time.Hour
+)
+
+// heuristicStatuses may be cached without explicit freshness, RFC 9110 section 15.1
+var heuristicStatuses = []response.StatusCode{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// Entry is a stored response. Entries are never modified once stored, freshening one
+// after a 304 stores a copy.
+type Entry struct {
+	StatusCode response.StatusCode
+	Headers    headers.Headers
+	Body       []byte
+	// Vary holds the value of every request header named by the Vary response header
+	Vary map[string]string
+	// RequestTime and ResponseTime frame the exchange that produced the entry
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// Size is what the entry counts against a store's byte limit
+func (e *Entry) Size() int64 {
+	size := int64(len(e.Body))
+
+	for k, v := range e.Headers {
+		size += int64(len(k) + len(v))
+	}
+
+	for k, v := range e.Vary {
+		size += int64(len(k) + len(v))
+	}
+
+	return size
+}
+
+// Age is the current age of the entry, RFC 9111 section 4.2.3
+func (e *Entry) Age(now time.Time) time.Duration {
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Headers.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+
+	var apparentAge time.Duration
+	if date, err := headers.ParseTime(e.Headers.Get("Date")); err == nil {
+		apparentAge = max(0, e.ResponseTime.Sub(date))
+	}
+
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+
+	return max(apparentAge, correctedAge) + now.Sub(e.ResponseTime)
+}
+
+// Lifetime is the freshness lifetime of the entry in a shared cache, RFC 9111 section 4.2.1
+func (e *Entry) Lifetime() time.Duration {
+	cc := parseCacheControl(e.Headers.Get("Cache-Control"))
+
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime
+	}
+
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime
+	}
+
+	date, err := headers.ParseTime(e.Headers.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+
+	if e.Headers.Exists("Expires") {
+		// invalid dates such as "0" mean already expired
+		expires, err := headers.ParseTime(e.Headers.Get("Expires"))
+		if err != nil {
+			return 0
+		}
+
+		return max(0, expires.Sub(date))
+	}
+
+	if !slices.Contains(heuristicStatuses, e.StatusCode) {
+		return 0
+	}
+
+	lastModified, err := headers.ParseTime(e.Headers.Get("Last-Modified"))
+	if err != nil {
+		return 0
+	}
+
+	return min(max(0, date.Sub(lastModified)/10), MaxHeuristicLifetime)
+}
+
+// matches reports whether a request with h selects this entry, RFC 9111 section 4.1
+func (e *Entry) matches(h headers.Headers) bool {
+	for name, value := range e.Vary {
+		if normalizeVaryValue(h.Get(name)) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// freshened returns a copy of the entry updated with the headers of a 304 response,
+// RFC 9111 section 4.3.4
+func (e *Entry) freshened(h headers.Headers, requestTime, responseTime time.Time) *Entry {
+	updated := *e
+	updated.Headers = headers.Headers{}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = responseTime
+
+	for k, v := range e.Headers {
+		updated.Headers.Set(k, v)
+	}
+
+	for k, v := range h {
+		switch strings.ToLower(k) {
+		case "content-length", "transfer-encoding", "content-encoding", "content-range":
+			continue
+		}
+
+		updated.Headers.Set(k, v)
+	}
+
+	// a 304 without Age has to reset the one stored with the original response
+	if !h.Exists("Age") {
+		updated.Headers.Delete("Age")
+	}
+
+	return &updated
+}
+
+// normalizeVaryValue drops the whitespace differences that do not change a header's meaning
+func normalizeVaryValue(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		parts[i] = strings.Join(strings.Fields(part), " ")
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultMaxBytes = 64 << 20
+)
+
+// Store keeps entries by cache key, implementations must be safe for concurrent use
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+}
+
+// MemoryStore is a Store bounded by the total Size of its entries, evicting the least
+// recently used ones first
+type MemoryStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	s.order.MoveToFront(element)
+
+	return element.Value.(*memoryItem).entry, true
+}
+
+// Set stores entry unless it is larger than the whole store
+func (s *MemoryStore) Set(key string, entry *Entry) {
+	size := entry.Size()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(key)
+
+	if size > s.maxBytes {
+		return
+	}
+
+	s.items[key] = s.order.PushFront(&memoryItem{key: key, entry: entry, size: size})
+	s.bytes += size
+
+	for s.bytes > s.maxBytes {
+		s.remove(s.order.Back().Value.(*memoryItem).key)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(key)
+}
+
+// Len is the number of stored entries
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.items)
+}
+
+// Bytes is the total Size of the stored entries
+func (s *MemoryStore) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bytes
+}
+
+func (s *MemoryStore) remove(key string) {
+	element, ok := s.items[key]
+	if !ok {
+		return
+	}
+
+	s.order.Remove(element)
+	delete(s.items, key)
+	s.bytes -= element.Value.(*memoryItem).size
+}
+
+// DiskStore is a Store keeping every entry as a JSON file in a directory, so entries
+// survive restarts. It has no size limit of its own.
+type DiskStore struct {
+	dir string
+}
+
+type diskEntry struct {
+	Key   string `json:"key"`
+	Entry *Entry `json:"entry"`
+}
+
+// NewDiskStore creates dir when it does not exist yet
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &DiskStore{dir: dir}, nil
+}
+
+func (s *DiskStore) Get(key string) (*Entry, bool) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("Error reading cache entry:", err)
+		}
+
+		return nil, false
+	}
+
+	var stored diskEntry
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Key != key || stored.Entry == nil {
+		return nil, false
+	}
+
+	return stored.Entry, true
+}
+
+// Set writes to a temporary file first so readers never see half an entry
+func (s *DiskStore) Set(key string, entry *Entry) {
+	data, err := json.Marshal(diskEntry{Key: key, Entry: entry})
+	if err != nil {
+		fmt.Println("Error encoding cache entry:", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		fmt.Println("Error writing cache entry:", err)
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		fmt.Println("Error writing cache entry:", err)
+	}
+}
+
+func (s *DiskStore) Delete(key string) {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("Error removing cache entry:", err)
+	}
+}
+
+// path hashes key, which is a URL and not a valid file name
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entryOf(body string) *Entry {
+	return &Entry{StatusCode: 200, Headers: headers.Headers{}, Body: []byte(body)}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryStore(10)
+
+	store.Set("a", entryOf("aaaa"))
+	store.Set("b", entryOf("bbbb"))
+
+	// reading a makes b the least recently used
+	_, ok := store.Get("a")
+	require.True(t, ok)
+
+	store.Set("c", entryOf("cccc"))
+
+	_, ok = store.Get("b")
+	assert.False(t, ok)
+
+	_, ok = store.Get("a")
+	assert.True(t, ok)
+
+	assert.Equal(t, 2, store.Len())
+	assert.Equal(t, int64(8), store.Bytes())
+
+	store.Set("a", entryOf("a"))
+	assert.Equal(t, int64(5), store.Bytes())
+
+	store.Set("huge", entryOf("far too large"))
+	_, ok = store.Get("huge")
+	assert.False(t, ok)
+
+	store.Delete("a")
+	assert.Equal(t, 1, store.Len())
+	assert.Equal(t, int64(4), store.Bytes())
+}
+
+func TestDiskStoreRoundTrip(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	require.NoError(t, err)
+
+	entry := entryOf("hello")
+	entry.Headers.Set("ETag", `"v1"`)
+	entry.Vary = map[string]string{"accept-encoding": "gzip"}
+	entry.RequestTime = epoch
+	entry.ResponseTime = epoch.Add(time.Second)
+
+	store.Set("origin.test/resource", entry)
+
+	got, ok := store.Get("origin.test/resource")
+	require.True(t, ok)
+	assert.Equal(t, entry.StatusCode, got.StatusCode)
+	assert.Equal(t, entry.Headers, got.Headers)
+	assert.Equal(t, entry.Body, got.Body)
+	assert.Equal(t, entry.Vary, got.Vary)
+	assert.True(t, entry.ResponseTime.Equal(got.ResponseTime))
+
+	_, ok = store.Get("origin.test/other")
+	assert.False(t, ok)
+
+	store.Delete("origin.test/resource")
+
+	_, ok = store.Get("origin.test/resource")
+	assert.False(t, ok)
+}
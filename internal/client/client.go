@@ -0,0 +1,374 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDialTimeout    = 10 * time.Second
+	DefaultIdleTimeout    = 90 * time.Second
+	DefaultMaxIdlePerHost = 8
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("error: unsupported URL scheme")
+	ErrBodyClosed        = errors.New("error: read on closed response body")
+)
+
+// Client sends HTTP/1.1 requests and keeps their connections open for reuse
+type Client struct {
+	dialTimeout           time.Duration
+	responseHeaderTimeout time.Duration
+	idleTimeout           time.Duration
+	maxIdlePerHost        int
+	tlsConfig             *tls.Config
+	dialer                DialFunc
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+type Option func(*Client)
+
+// DialFunc opens the connection to addr, a host:port
+type DialFunc func(network, addr string) (net.Conn, error)
+
+// WithDialTimeout bounds connecting, including the TLS handshake
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout bounds the wait for the response headers once the request is
+// written, zero waits forever
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.responseHeaderTimeout = d
+	}
+}
+
+// WithIdleTimeout is how long an unused connection is kept for reuse
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.idleTimeout = d
+	}
+}
+
+// WithMaxIdlePerHost caps the connections kept for reuse per host, zero disables keep-alive
+func WithMaxIdlePerHost(n int) Option {
+	return func(c *Client) {
+		c.maxIdlePerHost = n
+	}
+}
+
+// WithTLSConfig is used for https URLs, ServerName defaults to the URL host
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDialer replaces the TCP dialer, e.g. to reach an in-memory server. TLS is still
+// negotiated on top of the returned connection for https URLs.
+func WithDialer(dial DialFunc) Option {
+	return func(c *Client) {
+		c.dialer = dial
+	}
+}
+
+func New(options ...Option) *Client {
+	c := &Client{
+		dialTimeout:    DefaultDialTimeout,
+		idleTimeout:    DefaultIdleTimeout,
+		maxIdlePerHost: DefaultMaxIdlePerHost,
+		idle:           map[string][]*persistConn{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// Get sends a GET request for rawURL
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+// Do sends req and reads the response headers. A request without a body that fails on a
+// reused connection is sent once more on a new one, since the server may have closed the
+// idle connection in the meantime.
+func (c *Client) Do(req *Request) (*Response, error) {
+	key, addr, err := hostKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		pc, reused := c.getIdle(key)
+		if pc == nil {
+			pc, err = c.dial(req, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			pc.key = key
+		}
+
+		resp, err := c.roundTrip(pc, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		pc.conn.Close()
+
+		if !reused || req.Body != nil || isTimeout(err) {
+			return nil, err
+		}
+	}
+}
+
+// CloseIdleConnections closes every connection kept for reuse
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+
+		delete(c.idle, key)
+	}
+}
+
+func (c *Client) roundTrip(pc *persistConn, req *Request) (*Response, error) {
+	if err := req.write(pc.bw); err != nil {
+		return nil, err
+	}
+
+	if c.responseHeaderTimeout > 0 {
+		pc.conn.SetReadDeadline(time.Now().Add(c.responseHeaderTimeout))
+	}
+
+	resp, err := ReadResponse(pc.br, req.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.conn.SetReadDeadline(time.Time{})
+
+	// after 101 the connection carries the new protocol, Body reads from it and closing
+	// Body closes it
+	if resp.StatusCode == 101 {
+		resp.Body = &upgradedConn{Reader: pc.br, Conn: pc.conn}
+		return resp, nil
+	}
+
+	reuse := !resp.Close && !req.wantsClose()
+
+	if resp.noBody {
+		c.release(pc, reuse)
+		return resp, nil
+	}
+
+	resp.Body = &body{r: resp.Body, release: func(ok bool) {
+		c.release(pc, ok && reuse)
+	}}
+
+	return resp, nil
+}
+
+func (c *Client) dial(req *Request, addr string) (*persistConn, error) {
+	dial := c.dialer
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: c.dialTimeout, KeepAlive: 30 * time.Second}).Dial
+	}
+
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Scheme == "https" {
+		config := &tls.Config{}
+		if c.tlsConfig != nil {
+			config = c.tlsConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = req.URL.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, config)
+
+		if c.dialTimeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(c.dialTimeout))
+		}
+
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	return &persistConn{
+		conn: conn,
+		br:   bufio.NewReader(conn),
+		bw:   bufio.NewWriter(conn),
+	}, nil
+}
+
+// getIdle returns the most recently used idle connection for key, dropping expired ones
+func (c *Client) getIdle(key string) (*persistConn, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := c.idle[key]
+
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if time.Since(pc.idleSince) < c.idleTimeout {
+			c.idle[key] = conns
+			return pc, true
+		}
+
+		pc.conn.Close()
+	}
+
+	delete(c.idle, key)
+
+	return nil, false
+}
+
+func (c *Client) release(pc *persistConn, reuse bool) {
+	if !reuse {
+		pc.conn.Close()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[pc.key]) >= c.maxIdlePerHost {
+		pc.conn.Close()
+		return
+	}
+
+	pc.idleSince = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+type persistConn struct {
+	key       string
+	conn      net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	idleSince time.Time
+}
+
+type upgradedConn struct {
+	io.Reader
+	net.Conn
+}
+
+func (u *upgradedConn) Read(p []byte) (int, error) {
+	return u.Reader.Read(p)
+}
+
+// body hands the connection back once the response body has been read to the end, closing
+// it early closes the connection
+type body struct {
+	r       io.Reader
+	release func(reuse bool)
+	done    bool
+	closed  bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+
+	if b.done {
+		return 0, io.EOF
+	}
+
+	n, err := b.r.Read(p)
+
+	switch {
+	case err == io.EOF:
+		b.finish(true)
+	case err != nil:
+		b.finish(false)
+	}
+
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.finish(false)
+	b.closed = true
+
+	return nil
+}
+
+func (b *body) finish(reuse bool) {
+	if b.done {
+		return
+	}
+
+	b.done = true
+	b.release(reuse)
+}
+
+// hostKey is the pool key and dial address of the request URL
+func hostKey(req *Request) (key, addr string, err error) {
+	if req.URL == nil {
+		return "", "", fmt.Errorf("error: request has no URL")
+	}
+
+	port := req.URL.Port()
+
+	switch req.URL.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return "", "", fmt.Errorf("%w: %q", ErrUnsupportedScheme, req.URL.Scheme)
+	}
+
+	addr = net.JoinHostPort(req.URL.Hostname(), port)
+
+	return req.URL.Scheme + "://" + strings.ToLower(addr), addr, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
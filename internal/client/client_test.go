@@ -0,0 +1,271 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRawServer runs serve for every accepted connection and counts the connections
+func startRawServer(t *testing.T, serve func(conn net.Conn, br *bufio.Reader)) (string, *atomic.Int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	var accepted atomic.Int64
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), &accepted
+}
+
+// readRequestHead reads a request head off the wire and returns it without the final CRLF
+func readRequestHead(br *bufio.Reader) (string, error) {
+	var b strings.Builder
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		if line == "\r\n" {
+			return b.String(), nil
+		}
+
+		b.WriteString(line)
+	}
+}
+
+func readBody(t *testing.T, resp *Response) string {
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return string(data)
+}
+
+func TestResponseFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		raw     string
+		status  response.StatusCode
+		body    string
+		interim int
+		err     bool
+	}{
+		{
+			name:    "interim responses are collected",
+			raw:     "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			status:  response.OK,
+			body:    "ok",
+			interim: 2,
+		},
+		{
+			name:   "close delimited body",
+			raw:    "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end",
+			status: response.OK,
+			body:   "until the end",
+		},
+		{
+			name:   "chunk extensions are ignored",
+			raw:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3;name=value\r\nabc\r\n0\r\n\r\n",
+			status: response.OK,
+			body:   "abc",
+		},
+		{
+			name:   "head has no body",
+			method: "HEAD",
+			raw:    "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n",
+			status: response.OK,
+		},
+		{
+			name:   "not modified has no body",
+			raw:    "HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n",
+			status: response.NotModified,
+		},
+		{
+			name:   "short body",
+			raw:    "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+			status: response.OK,
+			err:    true,
+		},
+		{
+			name: "malformed status line",
+			raw:  "HTTP/2 200 OK\r\n\r\n",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := startRawServer(t, func(conn net.Conn, br *bufio.Reader) {
+				readRequestHead(br)
+				conn.Write([]byte(tt.raw))
+			})
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+
+			req, err := NewRequest(method, url, nil)
+			require.NoError(t, err)
+
+			resp, err := New().Do(req)
+			if tt.status == 0 {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Len(t, resp.Interim, tt.interim)
+
+			data, err := io.ReadAll(resp.Body)
+			if tt.err {
+				assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(data))
+		})
+	}
+}
+
+func TestKeepAliveReusesConnection(t *testing.T) {
+	url, accepted := startRawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		for {
+			if _, err := readRequestHead(br); err != nil {
+				return
+			}
+
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+		}
+	})
+
+	c := New()
+
+	for range 3 {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", readBody(t, resp))
+	}
+
+	assert.Equal(t, int64(1), accepted.Load())
+
+	// a body closed before its end leaves the connection in an unknown state
+	resp, err := c.Get(url)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	resp, err = c.Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, resp))
+	assert.Equal(t, int64(2), accepted.Load())
+}
+
+func TestStaleIdleConnectionIsRetried(t *testing.T) {
+	// the server closes every connection without saying so
+	url, accepted := startRawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		readRequestHead(br)
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	})
+
+	c := New()
+
+	for range 2 {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", readBody(t, resp))
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.Equal(t, int64(2), accepted.Load())
+}
+
+func TestRequestBodies(t *testing.T) {
+	received := make(chan string, 1)
+
+	url, _ := startRawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		head, _ := readRequestHead(br)
+
+		// the body is whatever arrives before the client goes quiet
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		body, _ := io.ReadAll(br)
+
+		received <- head + "\r\n" + string(body)
+		conn.Write([]byte("HTTP/1.1 204 No Content\r\n\r\n"))
+	})
+
+	tests := []struct {
+		name string
+		body io.Reader
+		want string
+	}{
+		{
+			name: "known length",
+			body: strings.NewReader("hello"),
+			want: "Content-Length: 5\r\n\r\nhello",
+		},
+		{
+			name: "streamed",
+			body: io.MultiReader(strings.NewReader("hello")),
+			want: "Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest("POST", url+"/upload", tt.body)
+			require.NoError(t, err)
+
+			resp, err := New().Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, response.StatusCode(204), resp.StatusCode)
+
+			wire := <-received
+			assert.True(t, strings.HasPrefix(wire, "POST /upload HTTP/1.1\r\nHost: 127.0.0.1:"), wire)
+			assert.True(t, strings.HasSuffix(wire, tt.want), wire)
+		})
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	url, _ := startRawServer(t, func(conn net.Conn, br *bufio.Reader) {
+		readRequestHead(br)
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	_, err := New(WithResponseHeaderTimeout(20 * time.Millisecond)).Get(url)
+	require.Error(t, err)
+	assert.True(t, isTimeout(err), err)
+}
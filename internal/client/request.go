@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+)
+
+// Request is an outgoing HTTP/1.1 request
+type Request struct {
+	Method string
+	URL    *url.URL
+	// Host replaces URL.Host in the Host header when set
+	Host    string
+	Headers headers.Headers
+	Body    io.Reader
+	// ContentLength is the size of Body, -1 sends Body with chunked transfer coding
+	ContentLength int64
+}
+
+// NewRequest builds a request for rawURL, the length of bytes.Reader, bytes.Buffer and
+// strings.Reader bodies is known up front, any other body is sent chunked
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.Headers{},
+		Body:    body,
+	}
+
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	default:
+		req.ContentLength = -1
+	}
+
+	return req, nil
+}
+
+func (r *Request) host() string {
+	if r.Host != "" {
+		return r.Host
+	}
+
+	return r.URL.Host
+}
+
+// wantsClose reports whether the caller asked for the connection to be closed
+func (r *Request) wantsClose() bool {
+	return headers.HasToken(r.Headers.Get("Connection"), "close")
+}
+
+func (r *Request) write(w *bufio.Writer) error {
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", r.Method, r.URL.RequestURI())
+	fmt.Fprintf(w, "Host: %s\r\n", r.host())
+
+	for k, v := range r.Headers {
+		switch strings.ToLower(k) {
+		case "host", "content-length", "transfer-encoding":
+			continue
+		}
+
+		fmt.Fprintf(w, "%s: %s\r\n", k, v)
+	}
+
+	switch {
+	case r.Body != nil && r.ContentLength < 0:
+		w.WriteString("Transfer-Encoding: chunked\r\n")
+	case r.Body != nil || r.Method == "POST" || r.Method == "PUT":
+		w.WriteString("Content-Length: " + strconv.FormatInt(r.ContentLength, 10) + "\r\n")
+	}
+
+	w.WriteString("\r\n")
+
+	if r.Body != nil {
+		if err := r.writeBody(w); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+func (r *Request) writeBody(w *bufio.Writer) error {
+	if r.ContentLength >= 0 {
+		n, err := io.CopyN(w, r.Body, r.ContentLength)
+		if err == io.EOF {
+			return fmt.Errorf("error: request body is %d bytes, expected %d", n, r.ContentLength)
+		}
+
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+
+		if err == io.EOF {
+			_, err := w.WriteString("0\r\n\r\n")
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	maxHeaderBytes = 1 << 20
+	maxLineBytes   = 8 << 10
+)
+
+var (
+	ErrMalformedStatusLine = errors.New("error: malformed status line")
+	ErrMalformedChunk      = errors.New("error: malformed chunk")
+	ErrHeaderTooLarge      = errors.New("error: response header too large")
+)
+
+// Response is a parsed HTTP/1.1 response, Body has to be read to the end or closed so the
+// connection can be reused or released
+type Response struct {
+	Proto      string
+	StatusCode response.StatusCode
+	Reason     string
+	// Headers holds one value per field, repeated fields are joined with ", " except
+	// Set-Cookie, whose lines are kept in SetCookie
+	Headers   headers.Headers
+	SetCookie []string
+	// ContentLength is -1 when the body is chunked or delimited by the connection closing
+	ContentLength int64
+	Body          io.ReadCloser
+	// Trailers are filled in once a chunked Body has been read to the end
+	Trailers headers.Headers
+	// Interim holds the 1xx responses received before this one
+	Interim []*Response
+	// Close reports whether the server closes the connection after this response
+	Close bool
+
+	noBody bool
+}
+
+// ReadResponse reads a response to a request with method from br, collecting interim
+// responses until the final one, whose Body then reads from br
+func ReadResponse(br *bufio.Reader, method string) (*Response, error) {
+	var interim []*Response
+
+	for {
+		resp, err := readHead(br)
+		if err != nil {
+			return nil, err
+		}
+
+		// 101 ends HTTP/1.1 on the connection, so it is final
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != response.SwitchingProtocols {
+			interim = append(interim, resp)
+			continue
+		}
+
+		resp.Interim = interim
+
+		return resp, resp.setBody(br, method)
+	}
+}
+
+func readHead(br *bufio.Reader) (*Response, error) {
+	line, err := readLine(br, maxLineBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{Headers: headers.Headers{}, Trailers: headers.Headers{}}
+	if err := resp.parseStatusLine(line); err != nil {
+		return nil, err
+	}
+
+	if err := readFields(br, resp.Headers, &resp.SetCookie); err != nil {
+		return nil, err
+	}
+
+	resp.Close = resp.Proto == "HTTP/1.0" || headers.HasToken(resp.Headers.Get("Connection"), "close")
+
+	return resp, nil
+}
+
+// parseStatusLine parses e.g. "HTTP/1.1 404 Not Found", the reason phrase may be empty
+func (r *Response) parseStatusLine(line string) error {
+	proto, rest, found := strings.Cut(line, " ")
+	if !found || (proto != "HTTP/1.1" && proto != "HTTP/1.0") {
+		return fmt.Errorf("%w: %q", ErrMalformedStatusLine, line)
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 {
+		return fmt.Errorf("%w: %q", ErrMalformedStatusLine, line)
+	}
+
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 100 {
+		return fmt.Errorf("%w: %q", ErrMalformedStatusLine, line)
+	}
+
+	r.Proto = proto
+	r.StatusCode = response.StatusCode(status)
+	r.Reason = reason
+
+	return nil
+}
+
+// readFields reads field lines up to the empty line, every line goes through the headers
+// parser on its own so repeated fields can be combined instead of replaced
+func readFields(br *bufio.Reader, h headers.Headers, setCookie *[]string) error {
+	total := 0
+
+	for {
+		line, err := readLine(br, maxLineBytes)
+		if err != nil {
+			return err
+		}
+
+		if line == "" {
+			return nil
+		}
+
+		total += len(line)
+		if total > maxHeaderBytes {
+			return ErrHeaderTooLarge
+		}
+
+		field := headers.Headers{}
+		if _, _, err := field.Parse([]byte(line + "\r\n")); err != nil {
+			return err
+		}
+
+		for k, v := range field {
+			switch {
+			case k == "set-cookie" && setCookie != nil:
+				*setCookie = append(*setCookie, v)
+			case h.Exists(k):
+				h.Set(k, h.Get(k)+", "+v)
+			default:
+				h.Set(k, v)
+			}
+		}
+	}
+}
+
+// setBody picks the message body length, RFC 9112 section 6.3
+func (r *Response) setBody(br *bufio.Reader, method string) error {
+	switch {
+	case method == "HEAD",
+		r.StatusCode < 200,
+		r.StatusCode == 204,
+		r.StatusCode == response.NotModified:
+		r.ContentLength = 0
+		if method == "HEAD" {
+			r.ContentLength, _ = strconv.ParseInt(r.Headers.Get("Content-Length"), 10, 64)
+		}
+
+		r.Body = io.NopCloser(strings.NewReader(""))
+		r.noBody = true
+
+		return nil
+	}
+
+	if te := r.Headers.Get("Transfer-Encoding"); te != "" {
+		r.ContentLength = -1
+
+		codings := strings.Split(te, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.Body = io.NopCloser(&chunkedReader{br: br, trailers: r.Trailers})
+			return nil
+		}
+
+		r.Close = true
+		r.Body = io.NopCloser(br)
+
+		return nil
+	}
+
+	if value := r.Headers.Get("Content-Length"); value != "" {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("error: invalid Content-Length %q", value)
+		}
+
+		r.ContentLength = length
+		r.Body = io.NopCloser(&lengthReader{r: br, remaining: length})
+
+		return nil
+	}
+
+	r.ContentLength = -1
+	r.Close = true
+	r.Body = io.NopCloser(br)
+
+	return nil
+}
+
+// lengthReader is io.LimitReader failing with io.ErrUnexpectedEOF on a short body
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+
+	if err == io.EOF && l.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// chunkedReader decodes the chunked transfer coding, RFC 9112 section 7.1
+type chunkedReader struct {
+	br        *bufio.Reader
+	remaining int64
+	trailers  headers.Headers
+	done      bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.remaining == 0 {
+		size, err := c.readSize()
+		if err != nil {
+			return 0, err
+		}
+
+		if size == 0 {
+			if err := readFields(c.br, c.trailers, nil); err != nil {
+				return 0, err
+			}
+
+			c.done = true
+
+			return 0, io.EOF
+		}
+
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	if err == nil && c.remaining == 0 {
+		if line, err := readLine(c.br, 2); err != nil || line != "" {
+			return n, ErrMalformedChunk
+		}
+	}
+
+	return n, err
+}
+
+func (c *chunkedReader) readSize() (int64, error) {
+	line, err := readLine(c.br, maxLineBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	// chunk extensions are ignored
+	line, _, _ = strings.Cut(line, ";")
+
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: size %q", ErrMalformedChunk, line)
+	}
+
+	return size, nil
+}
+
+// readLine reads a CRLF terminated line of at most limit bytes and strips the CRLF
+func readLine(br *bufio.Reader, limit int) (string, error) {
+	var b strings.Builder
+
+	for {
+		chunk, err := br.ReadSlice('\n')
+		b.Write(chunk)
+
+		if b.Len() > limit+2 {
+			return "", ErrHeaderTooLarge
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err == io.EOF && b.Len() > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		line, found := strings.CutSuffix(b.String(), "\r\n")
+		if !found {
+			return "", fmt.Errorf("error: line not terminated by CRLF: %q", b.String())
+		}
+
+		return line, nil
+	}
+}
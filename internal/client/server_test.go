@@ -0,0 +1,80 @@
+// Tests against the server live in an external package, server imports client through
+// the HTTP/2 support
+package client_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, handler server.Handler) string {
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return fmt.Sprintf("http://127.0.0.1:%d", srv.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+func readBody(t *testing.T, resp *client.Response) string {
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return string(data)
+}
+
+func TestGetWithContentLength(t *testing.T) {
+	url := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.SetCookie(&headers.Cookie{Name: "a", Value: "1"})
+		w.SetCookie(&headers.Cookie{Name: "b", Value: "2"})
+		w.SetHeader("X-Target", req.RequestLine.RequestTarget)
+		w.SetHeader("Content-Length", "5")
+		w.Write([]byte("hello"))
+
+		return nil
+	})
+
+	resp, err := client.New().Get(url + "/path?q=1")
+	require.NoError(t, err)
+
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Reason)
+	assert.Equal(t, "/path?q=1", resp.Headers.Get("X-Target"))
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.SetCookie)
+	assert.Equal(t, "hello", readBody(t, resp))
+}
+
+func TestChunkedBodyAndTrailers(t *testing.T) {
+	url := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.SetHeader("Trailer", "X-Checksum")
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteTrailers(headers.Headers{"X-Checksum": "abc123"})
+
+		return nil
+	})
+
+	resp, err := client.New().Get(url)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Empty(t, resp.Trailers)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	assert.Equal(t, "abc123", resp.Trailers.Get("X-Checksum"))
+}
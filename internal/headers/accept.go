@@ -0,0 +1,183 @@
+package headers
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Preference is one element of an Accept, Accept-Language, Accept-Charset or
+// Accept-Encoding header, Params holds media type parameters other than q
+type Preference struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// ParseAccept parses a comma separated list of values with optional q weights into
+// preferences ordered from most to least preferred, equal weights keep header order.
+// Elements with an invalid weight are dropped.
+func ParseAccept(value string) []Preference {
+	var prefs []Preference
+
+	for element := range strings.SplitSeq(value, ",") {
+		parts := strings.Split(element, ";")
+
+		pref := Preference{
+			Value: strings.ToLower(strings.TrimSpace(parts[0])),
+			Q:     1,
+		}
+
+		if pref.Value == "" {
+			continue
+		}
+
+		valid := true
+
+		for _, param := range parts[1:] {
+			key, val, _ := strings.Cut(param, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			val = strings.Trim(strings.TrimSpace(val), "\"")
+
+			if key == "q" {
+				q, err := strconv.ParseFloat(val, 64)
+				if err != nil || q < 0 || q > 1 {
+					valid = false
+				}
+
+				pref.Q = q
+
+				// anything after the weight is an accept-ext, not a media type parameter
+				break
+			}
+
+			if key == "" {
+				continue
+			}
+
+			if pref.Params == nil {
+				pref.Params = map[string]string{}
+			}
+
+			pref.Params[key] = val
+		}
+
+		if valid {
+			prefs = append(prefs, pref)
+		}
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool {
+		return prefs[i].Q > prefs[j].Q
+	})
+
+	return prefs
+}
+
+// BestMediaType returns the offer the Accept header value prefers most, an empty header
+// accepts the first offer. The most specific matching media range decides the weight of
+// an offer (RFC 9110 section 12.5.1) and ties go to the earlier offer.
+func BestMediaType(accept string, offers []string) (string, bool) {
+	return best(accept, offers, matchMediaRange)
+}
+
+// BestLanguage matches Accept-Language ranges against language tags with the basic
+// filtering of RFC 4647, so "en" accepts "en-US"
+func BestLanguage(acceptLanguage string, offers []string) (string, bool) {
+	return best(acceptLanguage, offers, matchLanguageRange)
+}
+
+// BestCharset matches Accept-Charset values case-insensitively
+func BestCharset(acceptCharset string, offers []string) (string, bool) {
+	return best(acceptCharset, offers, matchToken)
+}
+
+// matcher reports whether pref applies to offer and how specific the match is
+type matcher func(pref Preference, offer string) (int, bool)
+
+func best(header string, offers []string, match matcher) (string, bool) {
+	if strings.TrimSpace(header) == "" {
+		if len(offers) == 0 {
+			return "", false
+		}
+
+		return offers[0], true
+	}
+
+	return bestOf(ParseAccept(header), offers, match)
+}
+
+func bestOf(prefs []Preference, offers []string, match matcher) (string, bool) {
+	bestOffer, bestQ := "", 0.0
+
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+
+		for _, pref := range prefs {
+			if s, ok := match(pref, offer); ok && s > specificity {
+				q, specificity = pref.Q, s
+			}
+		}
+
+		if q > bestQ {
+			bestOffer, bestQ = offer, q
+		}
+	}
+
+	return bestOffer, bestQ > 0
+}
+
+func matchMediaRange(pref Preference, offer string) (int, bool) {
+	offerType, offerParams, err := mime.ParseMediaType(offer)
+	if err != nil {
+		return 0, false
+	}
+
+	rangeType, rangeSubtype, _ := strings.Cut(pref.Value, "/")
+	typ, subtype, _ := strings.Cut(offerType, "/")
+
+	switch {
+	case rangeType == "*" && rangeSubtype == "*":
+		return 0, true
+	case rangeType != typ:
+		return 0, false
+	case rangeSubtype == "*":
+		return 1, true
+	case rangeSubtype != subtype:
+		return 0, false
+	}
+
+	for k, v := range pref.Params {
+		if !strings.EqualFold(offerParams[k], v) {
+			return 0, false
+		}
+	}
+
+	return 2 + len(pref.Params), true
+}
+
+func matchLanguageRange(pref Preference, offer string) (int, bool) {
+	if pref.Value == "*" {
+		return 0, true
+	}
+
+	tag := strings.ToLower(offer)
+	if tag == pref.Value || strings.HasPrefix(tag, pref.Value+"-") {
+		return len(pref.Value), true
+	}
+
+	return 0, false
+}
+
+func matchToken(pref Preference, offer string) (int, bool) {
+	if pref.Value == "*" {
+		return 0, true
+	}
+
+	if strings.EqualFold(pref.Value, offer) {
+		return 1, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,69 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	prefs := ParseAccept("text/*;q=0.3, text/html;q=0.7, text/html;level=1, */*;q=0.5, bad;q=2")
+
+	require.Len(t, prefs, 4)
+	assert.Equal(t, Preference{Value: "text/html", Q: 1, Params: map[string]string{"level": "1"}}, prefs[0])
+	assert.Equal(t, "text/html", prefs[1].Value)
+	assert.Equal(t, 0.7, prefs[1].Q)
+	assert.Equal(t, "*/*", prefs[2].Value)
+	assert.Equal(t, "text/*", prefs[3].Value)
+}
+
+func TestBestMediaType(t *testing.T) {
+	offers := []string{"application/json", "text/html"}
+
+	best, ok := BestMediaType("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers)
+	require.True(t, ok)
+	assert.Equal(t, "text/html", best)
+
+	best, ok = BestMediaType("application/*;q=0.9, text/html;q=0.5", offers)
+	require.True(t, ok)
+	assert.Equal(t, "application/json", best)
+
+	best, ok = BestMediaType("*/*", offers)
+	require.True(t, ok)
+	assert.Equal(t, "application/json", best)
+
+	best, ok = BestMediaType("", offers)
+	require.True(t, ok)
+	assert.Equal(t, "application/json", best)
+
+	_, ok = BestMediaType("image/png", offers)
+	assert.False(t, ok)
+
+	_, ok = BestMediaType("*/*, application/json;q=0, text/html;q=0", offers)
+	assert.False(t, ok)
+}
+
+func TestBestLanguage(t *testing.T) {
+	offers := []string{"en-US", "fr-CA", "el"}
+
+	best, ok := BestLanguage("fr;q=0.9, en;q=0.8", offers)
+	require.True(t, ok)
+	assert.Equal(t, "fr-CA", best)
+
+	best, ok = BestLanguage("de, *;q=0.1", offers)
+	require.True(t, ok)
+	assert.Equal(t, "en-US", best)
+
+	_, ok = BestLanguage("de", offers)
+	assert.False(t, ok)
+}
+
+func TestBestCharset(t *testing.T) {
+	best, ok := BestCharset("iso-8859-5, UTF-8;q=0.8", []string{"utf-8"})
+	require.True(t, ok)
+	assert.Equal(t, "utf-8", best)
+
+	_, ok = BestCharset("iso-8859-5", []string{"utf-8"})
+	assert.False(t, ok)
+}
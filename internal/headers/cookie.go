@@ -0,0 +1,180 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCookieName   = errors.New("error: invalid cookie name")
+	ErrInvalidCookieValue  = errors.New("error: invalid cookie value")
+	ErrInvalidCookiePath   = errors.New("error: invalid cookie path")
+	ErrInvalidCookieDomain = errors.New("error: invalid cookie domain")
+	ErrPartitionedInsecure = errors.New("error: partitioned cookie must be secure")
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is a single cookie, either received in a Cookie header, in which case only
+// Name and Value are set, or sent to the client in a Set-Cookie header (RFC 6265)
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge = 0 omits the attribute, MaxAge < 0 deletes the cookie with Max-Age=0
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// ParseCookies parses a Cookie header value, pairs that are not valid are skipped
+func ParseCookies(value string) []*Cookie {
+	var cookies []*Cookie
+
+	for pair := range strings.SplitSeq(value, ";") {
+		name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !IsToken(name) {
+			continue
+		}
+
+		val, ok := parseCookieValue(val)
+		if !ok {
+			continue
+		}
+
+		cookies = append(cookies, &Cookie{
+			Name:  name,
+			Value: val,
+		})
+	}
+
+	return cookies
+}
+
+func (c *Cookie) Valid() error {
+	if !IsToken(c.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidCookieName, c.Name)
+	}
+
+	if _, ok := parseCookieValue(c.Value); !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidCookieValue, c.Value)
+	}
+
+	if strings.ContainsAny(c.Path, ";\r\n") || hasControlChar(c.Path) {
+		return fmt.Errorf("%w: %q", ErrInvalidCookiePath, c.Path)
+	}
+
+	if c.Domain != "" && !isCookieDomain(c.Domain) {
+		return fmt.Errorf("%w: %q", ErrInvalidCookieDomain, c.Domain)
+	}
+
+	if c.Partitioned && !c.Secure {
+		return ErrPartitionedInsecure
+	}
+
+	return nil
+}
+
+// String serializes the cookie as a Set-Cookie header value
+func (c *Cookie) String() string {
+	var b strings.Builder
+
+	b.WriteString(c.Name + "=" + c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + FormatTime(c.Expires))
+	}
+
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+
+	return b.String()
+}
+
+// parseCookieValue strips optional quotes and checks for cookie-octets only
+func parseCookieValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return "", false
+		}
+	}
+
+	return value, true
+}
+
+func isCookieDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+
+	for _, c := range domain {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasControlChar(s string) bool {
+	for _, c := range s {
+		if c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCookies(t *testing.T) {
+	cookies := ParseCookies(`session=abc123; theme="dark"; bad cookie=1; =empty; ok=`)
+
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "ok", cookies[2].Name)
+	assert.Equal(t, "", cookies[2].Value)
+}
+
+func TestCookieString(t *testing.T) {
+	cookie := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2025, 4, 6, 12, 0, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteLax,
+		Partitioned: true,
+	}
+
+	require.NoError(t, cookie.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Sun, 06 Apr 2025 12:00:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Lax; Partitioned", cookie.String())
+}
+
+func TestCookieDelete(t *testing.T) {
+	cookie := &Cookie{Name: "session", MaxAge: -1}
+
+	assert.Equal(t, "session=; Max-Age=0", cookie.String())
+}
+
+func TestInvalidCookies(t *testing.T) {
+	assert.ErrorIs(t, (&Cookie{Name: "bad name", Value: "v"}).Valid(), ErrInvalidCookieName)
+	assert.ErrorIs(t, (&Cookie{Name: "n", Value: "a;b"}).Valid(), ErrInvalidCookieValue)
+	assert.ErrorIs(t, (&Cookie{Name: "n", Value: "v", Path: "/\r\nX: y"}).Valid(), ErrInvalidCookiePath)
+	assert.ErrorIs(t, (&Cookie{Name: "n", Value: "v", Domain: "exa mple.com"}).Valid(), ErrInvalidCookieDomain)
+	assert.ErrorIs(t, (&Cookie{Name: "n", Value: "v", Partitioned: true}).Valid(), ErrPartitionedInsecure)
+}
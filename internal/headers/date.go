@@ -0,0 +1,36 @@
+package headers
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+)
+
+var (
+	ErrInvalidDate = errors.New("error: invalid HTTP date")
+)
+
+// obsolete date formats recipients must still accept, see RFC 9110 section 5.6.7
+var dateFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+func ParseTime(value string) (time.Time, error) {
+	for _, format := range dateFormats {
+		t, err := time.Parse(format, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, ErrInvalidDate
+}
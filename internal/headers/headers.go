@@ -51,6 +51,10 @@ func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 			return 0, false, ErrMalformedHeaderNotFound
 		}
 
+		if colonIndex == 0 {
+			return 0, false, ErrInvalidHeaderKey
+		}
+
 		if headerLine[colonIndex-1] == ' ' {
 			return 0, false, ErrMalformedHeaderWhitespace
 		}
@@ -100,6 +104,34 @@ func NewHeaders() *Headers {
 	return &headers
 }
 
+// IsToken reports whether s is a token as methods, cookie names and most header values
+// use them, RFC 9110 section 5.6.2
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if !validCharsMap[c] || c == ':' || c == ';' || c == '/' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasToken reports whether the comma separated header value contains token, compared
+// case-insensitively as Connection, Upgrade and the like require
+func HasToken(value, token string) bool {
+	for element := range strings.SplitSeq(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(element), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isValidHeaderKey(key string) bool {
 	for _, c := range key {
 		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_') {
@@ -120,12 +152,13 @@ func isValidHeaderValue(value string) bool {
 	return true
 }
 
+// field values are visible characters, spaces, tabs and obs-text, see RFC 9110 section 5.5
 func isAllowedHeaderValueChar(c rune) bool {
-	switch c {
-	case '!', '#', '$', '%', '&', '\'', '/', '*', '+', '-', '.', '^', '_', '`', '|', '~', ':', ';', ' ':
+	if c == ' ' || c == '\t' {
 		return true
 	}
-	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+
+	return (c >= 0x21 && c <= 0x7e) || c >= 0x80
 }
 
 func normalizeHeaderValue(value string) string {
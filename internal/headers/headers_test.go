@@ -96,3 +96,41 @@ func TestMissingEndOfHeaders(t *testing.T) {
 	assert.Equal(t, len(data), n)
 	assert.False(t, done)
 }
+
+func TestValidHeaderWithRangeValue(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("Range: bytes=0-499, -500\r\nIf-Range: \"abc\"\r\n\r\n")
+
+	n, done, err := headers.Parse(data)
+
+	require.NoError(t, err)
+	assert.Equal(t, "bytes=0-499, -500", headers.Get("Range"))
+	assert.Equal(t, "\"abc\"", headers.Get("If-Range"))
+	assert.Equal(t, len(data), n)
+	assert.True(t, done)
+}
+
+func TestInvalidHeaderValueControlCharacter(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("Host: local\x00host\r\n\r\n")
+
+	_, _, err := headers.Parse(data)
+
+	require.ErrorIs(t, err, ErrInvalidHeaderValue)
+}
+
+func TestInvalidHeaderEmptyKey(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte(": localhost\r\n\r\n")
+
+	_, _, err := headers.Parse(data)
+
+	require.ErrorIs(t, err, ErrInvalidHeaderKey)
+}
+
+func TestHasToken(t *testing.T) {
+	assert.True(t, HasToken("keep-alive, Upgrade", "upgrade"))
+	assert.True(t, HasToken("close", "close"))
+	assert.False(t, HasToken("keep-alive, upgraded", "upgrade"))
+	assert.False(t, HasToken("", "close"))
+}
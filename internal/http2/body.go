@@ -0,0 +1,88 @@
+package http2
+
+import (
+	"bytes"
+	"sync"
+)
+
+// bodyPipe carries the DATA of a stream to its handler. The serve loop never blocks on
+// it, flow control bounds what is buffered to the stream window, and onRead gives the
+// window back as the handler consumes the body.
+type bodyPipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	err    error
+	onRead func(n int64)
+}
+
+func newBodyPipe(onRead func(n int64)) *bodyPipe {
+	p := &bodyPipe{onRead: onRead}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
+}
+
+func (p *bodyPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+
+	if p.buf.Len() == 0 {
+		err := p.err
+		p.mu.Unlock()
+
+		return 0, err
+	}
+
+	n, _ := p.buf.Read(b)
+	p.mu.Unlock()
+
+	p.onRead(int64(n))
+
+	return n, nil
+}
+
+// write buffers data for the handler, it reports false once the body was closed and the
+// data is dropped
+func (p *bodyPipe) write(data []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return false
+	}
+
+	p.buf.Write(data)
+	p.cond.Broadcast()
+
+	return true
+}
+
+// closeWithError ends the body once what is buffered has been read, with io.EOF when the
+// request is complete
+func (p *bodyPipe) closeWithError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+		p.cond.Broadcast()
+	}
+}
+
+// abort ends the body right away and returns how many buffered bytes were dropped, which
+// the connection window has to get back
+func (p *bodyPipe) abort(err error) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dropped := int64(p.buf.Len())
+	p.buf.Reset()
+
+	p.err = err
+	p.cond.Broadcast()
+
+	return dropped
+}
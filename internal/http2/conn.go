@@ -0,0 +1,1247 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultMaxConcurrentStreams = 250
+	// DefaultWindowSize is the receive window advertised for the connection and each stream
+	DefaultWindowSize = 1 << 20
+	// DefaultMaxHeaderListSize bounds the decoded request headers of a stream
+	DefaultMaxHeaderListSize = 1 << 20
+	// DefaultMaxBodySize bounds the request body buffered for a stream, larger bodies are
+	// answered with 413
+	DefaultMaxBodySize = 10 << 20
+	// DefaultMaxBufferedBodies bounds the request bodies buffered across the streams of a
+	// connection, streams that would go over it are refused
+	DefaultMaxBufferedBodies = 64 << 20
+
+	headerTableSize = 4096
+)
+
+var (
+	ErrBadPreface = errors.New("error: invalid HTTP/2 connection preface")
+
+	errStreamClosed = errors.New("error: stream closed")
+	// connectionHeaders are forbidden in HTTP/2, RFC 9113 section 8.2.2
+	connectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+)
+
+// Handler answers req by writing an HTTP/1.1 response to w, which is turned into frames
+// as it arrives. closed is closed once the client resets the stream or the connection
+// goes away.
+type Handler func(w io.Writer, req *request.Request, closed <-chan struct{})
+
+type Option func(*config)
+
+type config struct {
+	maxConcurrentStreams uint32
+	windowSize           uint32
+	maxHeaderListSize    uint32
+	maxBodySize          int64
+	maxBufferedBodies    int64
+	streamBodies         bool
+}
+
+// WithMaxConcurrentStreams caps the streams a client may have open, more are refused
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(c *config) {
+		c.maxConcurrentStreams = n
+	}
+}
+
+// WithMaxHeaderListSize bounds the decoded header block of a request, larger blocks end
+// the connection
+func WithMaxHeaderListSize(n uint32) Option {
+	return func(c *config) {
+		c.maxHeaderListSize = n
+	}
+}
+
+// WithMaxBodySize bounds the request body of a stream. Bodies are buffered until the
+// request is complete, so at most this many bytes are held per open stream. Streamed
+// bodies are not limited, the handler reads as much as it wants.
+func WithMaxBodySize(n int64) Option {
+	return func(c *config) {
+		c.maxBodySize = n
+	}
+}
+
+// WithMaxBufferedBodies bounds the request bodies buffered at once across all streams of
+// a connection, a stream whose body would go over it is refused
+func WithMaxBufferedBodies(n int64) Option {
+	return func(c *config) {
+		c.maxBufferedBodies = n
+	}
+}
+
+// WithStreamingBodies hands each stream to the handler as soon as its headers arrive,
+// the body is then read through Request.BodyReader. The flow control windows are given
+// back as the handler reads, so a connection holds at most one window of unread data.
+func WithStreamingBodies() Option {
+	return func(c *config) {
+		c.streamBodies = true
+	}
+}
+
+// WithWindowSize is the flow control window for request bodies, per stream and for the
+// connection as a whole
+func WithWindowSize(n uint32) Option {
+	return func(c *config) {
+		c.windowSize = min(max(n, defaultWindowSize), maxWindowSize)
+	}
+}
+
+// conn is the server side of an HTTP/2 connection. Frames are read by the serve loop
+// only, each stream's handler runs in its own goroutine and writes through writeFrame.
+type conn struct {
+	nc      net.Conn
+	br      *bufio.Reader
+	handler Handler
+	config  config
+	decoder *Decoder
+
+	// wmu keeps frames, and header blocks split into CONTINUATION, from interleaving
+	wmu sync.Mutex
+	bw  *bufio.Writer
+
+	// mu guards the fields below, cond is signalled whenever a send window grows or a
+	// stream goes away
+	mu           sync.Mutex
+	cond         *sync.Cond
+	streams      map[uint32]*stream
+	sendWindow   int64
+	peerWindow   int64
+	peerMaxFrame uint32
+	closed       bool
+	recvWindow   int64
+	buffered     int64
+
+	// only touched by the serve loop
+	lastStreamID uint32
+}
+
+type stream struct {
+	id  uint32
+	req *request.Request
+	// pipe carries the body to the handler with WithStreamingBodies
+	pipe *bodyPipe
+
+	// only touched by the serve loop
+	body          bytes.Buffer
+	received      int64
+	contentLength int64
+
+	// guarded by conn.mu, remoteClosed is only written by the serve loop
+	recvWindow   int64
+	remoteClosed bool
+	buffered     int64
+	dispatched   bool
+	sendWindow   int64
+	reset        bool
+	done         chan struct{}
+}
+
+// ServeConn serves HTTP/2 on nc, whose client has yet to send the connection preface,
+// until the client goes away. It closes nc.
+func ServeConn(nc net.Conn, handler Handler, options ...Option) error {
+	return newConn(nc, handler, options).serve(nil)
+}
+
+// ServeUpgrade switches nc to HTTP/2 after req asked for it with "Upgrade: h2c" and the
+// HTTP2-Settings that were parsed with ParseUpgradeSettings. It writes the 101 response,
+// answers req on stream 1 and serves the connection like ServeConn.
+func ServeUpgrade(nc net.Conn, req *request.Request, settings []Setting, handler Handler, options ...Option) error {
+	c := newConn(nc, handler, options)
+
+	// the request is answered over HTTP/2 like any other stream
+	req.RequestLine.HttpVersion = "HTTP/2.0"
+	for _, k := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+		req.Headers.Delete(k)
+	}
+
+	c.bw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+
+	// the 101 acknowledges the settings of the upgrade request, RFC 7540 section 3.2.1
+	if err := c.applySettings(settings); err != nil {
+		nc.Close()
+		return err
+	}
+
+	return c.serve(req)
+}
+
+func newConn(nc net.Conn, handler Handler, options []Option) *conn {
+	cfg := config{
+		maxConcurrentStreams: DefaultMaxConcurrentStreams,
+		windowSize:           DefaultWindowSize,
+		maxHeaderListSize:    DefaultMaxHeaderListSize,
+		maxBodySize:          DefaultMaxBodySize,
+		maxBufferedBodies:    DefaultMaxBufferedBodies,
+	}
+
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	c := &conn{
+		nc:           nc,
+		br:           bufio.NewReader(nc),
+		bw:           bufio.NewWriter(nc),
+		handler:      handler,
+		config:       cfg,
+		decoder:      NewDecoder(headerTableSize, cfg.maxHeaderListSize),
+		streams:      map[uint32]*stream{},
+		sendWindow:   defaultWindowSize,
+		peerWindow:   defaultWindowSize,
+		peerMaxFrame: minMaxFrameSize,
+		recvWindow:   defaultWindowSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+func (c *conn) serve(upgraded *request.Request) error {
+	defer c.close()
+
+	settings := AppendSettings(nil,
+		Setting{SettingMaxConcurrentStreams, c.config.maxConcurrentStreams},
+		Setting{SettingInitialWindowSize, c.config.windowSize},
+		Setting{SettingMaxHeaderListSize, c.config.maxHeaderListSize},
+	)
+	if err := c.writeFrame(FrameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+
+	if grow := int64(c.config.windowSize) - c.recvWindow; grow > 0 {
+		if err := c.writeWindowUpdate(0, uint32(grow)); err != nil {
+			return err
+		}
+
+		c.recvWindow += grow
+	}
+
+	if upgraded != nil {
+		st := c.newStream(1)
+		c.lastStreamID = 1
+
+		st.req = upgraded
+		st.remoteClosed = true
+		c.dispatch(st)
+	}
+
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(c.br, preface); err != nil {
+		return err
+	}
+
+	if string(preface) != Preface {
+		c.goAway(ErrCodeProtocol, "invalid connection preface")
+		return ErrBadPreface
+	}
+
+	for first := true; ; first = false {
+		f, err := ReadFrame(c.br, minMaxFrameSize)
+		if err != nil {
+			if errors.Is(err, ErrFrameTooLarge) {
+				c.goAway(ErrCodeFrameSize, err.Error())
+				return err
+			}
+
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		if first && (f.Type != FrameSettings || f.Flags.Has(FlagAck)) {
+			err = ConnError{ErrCodeProtocol, "first frame is not SETTINGS"}
+		} else {
+			err = c.handleFrame(f)
+		}
+
+		var streamErr StreamError
+		var connErr ConnError
+
+		switch {
+		case err == nil:
+		case errors.As(err, &streamErr):
+			c.resetStream(streamErr.StreamID, streamErr.Code)
+		case errors.As(err, &connErr):
+			c.goAway(connErr.Code, connErr.Reason)
+			return err
+		default:
+			return err
+		}
+	}
+}
+
+// close ends every stream, handlers still running see their closed channel fire and
+// their writes fail
+func (c *conn) close() {
+	c.mu.Lock()
+	c.closed = true
+
+	for id, st := range c.streams {
+		st.cancel()
+		delete(c.streams, id)
+	}
+
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	c.nc.Close()
+}
+
+func (c *conn) handleFrame(f *Frame) error {
+	switch f.Type {
+	case FrameData:
+		return c.onData(f)
+	case FrameHeaders:
+		return c.onHeaders(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+
+		if f.Length != 5 {
+			return StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY length"}
+		}
+
+		// priorities are deprecated by RFC 9113 and ignored
+		return nil
+	case FrameRSTStream:
+		if f.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+		}
+
+		if f.Length != 4 {
+			return ConnError{ErrCodeFrameSize, "RST_STREAM length"}
+		}
+
+		if f.StreamID > c.lastStreamID {
+			return ConnError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+		}
+
+		c.mu.Lock()
+		dropped := c.removeStream(f.StreamID)
+		c.mu.Unlock()
+
+		return c.returnConnWindow(dropped)
+	case FrameSettings:
+		return c.onSettings(f)
+	case FramePushPromise:
+		return ConnError{ErrCodeProtocol, "clients cannot push"}
+	case FramePing:
+		if f.Length != 8 {
+			return ConnError{ErrCodeFrameSize, "PING length"}
+		}
+
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "PING on a stream"}
+		}
+
+		if f.Flags.Has(FlagAck) {
+			return nil
+		}
+
+		return c.writeFrame(FramePing, FlagAck, 0, f.Payload)
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+
+		// the client stops opening streams and closes the connection once it is done
+		return nil
+	case FrameWindowUpdate:
+		return c.onWindowUpdate(f)
+	case FrameContinuation:
+		return ConnError{ErrCodeProtocol, "CONTINUATION without HEADERS"}
+	default:
+		// unknown frame types are ignored, RFC 9113 section 4.1
+		return nil
+	}
+}
+
+func (c *conn) onHeaders(f *Frame) error {
+	if f.StreamID == 0 || f.StreamID%2 == 0 {
+		return ConnError{ErrCodeProtocol, "HEADERS on an invalid stream id"}
+	}
+
+	block, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	if f.Flags.Has(FlagPriority) {
+		if len(block) < 5 {
+			return ConnError{ErrCodeFrameSize, "HEADERS too short for priority"}
+		}
+
+		block = block[5:]
+	}
+
+	block = bytes.Clone(block)
+
+	for flags := f.Flags; !flags.Has(FlagEndHeaders); {
+		next, err := ReadFrame(c.br, minMaxFrameSize)
+		if err != nil {
+			return err
+		}
+
+		if next.Type != FrameContinuation || next.StreamID != f.StreamID {
+			return ConnError{ErrCodeProtocol, "header block interrupted"}
+		}
+
+		block = append(block, next.Payload...)
+		if uint32(len(block)) > c.config.maxHeaderListSize {
+			return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+		}
+
+		flags = next.Flags
+	}
+
+	// the block is decoded even for streams that are refused, the table has to stay in sync
+	fields, err := c.decoder.Decode(block)
+	if err != nil {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	endStream := f.Flags.Has(FlagEndStream)
+
+	c.mu.Lock()
+	st := c.streams[f.StreamID]
+	c.mu.Unlock()
+
+	if st != nil {
+		// trailers, which the request has no room for
+		if st.remoteClosed {
+			return StreamError{f.StreamID, ErrCodeStreamClosed, "HEADERS after END_STREAM"}
+		}
+
+		if !endStream {
+			return StreamError{f.StreamID, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+
+		for _, field := range fields {
+			if strings.HasPrefix(field.Name, ":") {
+				return StreamError{f.StreamID, ErrCodeProtocol, "pseudo-header in trailers"}
+			}
+		}
+
+		return c.endRequest(st)
+	}
+
+	if f.StreamID <= c.lastStreamID {
+		return ConnError{ErrCodeStreamClosed, "HEADERS on a closed stream"}
+	}
+
+	c.lastStreamID = f.StreamID
+
+	c.mu.Lock()
+	active := uint32(len(c.streams))
+	c.mu.Unlock()
+
+	if active >= c.config.maxConcurrentStreams {
+		return StreamError{f.StreamID, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	req, contentLength, err := c.newRequest(fields)
+	if err != nil {
+		return StreamError{f.StreamID, ErrCodeProtocol, err.Error()}
+	}
+
+	st = c.newStream(f.StreamID)
+	st.req = req
+	st.contentLength = contentLength
+
+	if c.config.streamBodies {
+		// the handler starts right away and reads the body as it arrives
+		st.pipe = newBodyPipe(func(n int64) { c.bodyRead(st, n) })
+		req.SetBodyReader(st.pipe)
+		c.dispatch(st)
+
+		if endStream {
+			return c.endRequest(st)
+		}
+
+		return nil
+	}
+
+	if contentLength > c.config.maxBodySize {
+		return c.refuseBody(st)
+	}
+
+	if endStream {
+		return c.endRequest(st)
+	}
+
+	return nil
+}
+
+func (c *conn) onData(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	// flow control counts the whole payload, padding included
+	size := int64(len(f.Payload))
+
+	c.mu.Lock()
+	c.recvWindow -= size
+	exceeded := c.recvWindow < 0
+	st := c.streams[f.StreamID]
+	c.mu.Unlock()
+
+	if exceeded {
+		return ConnError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+
+	if st == nil {
+		if err := c.returnConnWindow(size); err != nil {
+			return err
+		}
+
+		if f.StreamID > c.lastStreamID {
+			return ConnError{ErrCodeProtocol, "DATA on idle stream"}
+		}
+
+		return StreamError{f.StreamID, ErrCodeStreamClosed, "DATA on closed stream"}
+	}
+
+	c.mu.Lock()
+	remoteClosed := st.remoteClosed
+	st.recvWindow -= size
+	overflow := st.recvWindow < 0
+	c.mu.Unlock()
+
+	switch {
+	case remoteClosed:
+		err = StreamError{f.StreamID, ErrCodeStreamClosed, "DATA after END_STREAM"}
+	case overflow:
+		err = StreamError{f.StreamID, ErrCodeFlowControl, "stream window exceeded"}
+	case st.contentLength >= 0 && st.received+int64(len(data)) > st.contentLength:
+		err = StreamError{f.StreamID, ErrCodeProtocol, "body longer than content-length"}
+	}
+
+	if err != nil {
+		if windowErr := c.returnConnWindow(size); windowErr != nil {
+			return windowErr
+		}
+
+		return err
+	}
+
+	st.received += int64(len(data))
+
+	if st.pipe != nil {
+		return c.streamData(st, f, data)
+	}
+
+	return c.bufferData(st, f, data)
+}
+
+// streamData hands data to the handler of a streamed body. Its windows are given back as
+// the handler reads, padding and data the handler no longer wants right away.
+func (c *conn) streamData(st *stream, f *Frame, data []byte) error {
+	unread := int64(len(f.Payload) - len(data))
+	if !st.pipe.write(data) {
+		unread = int64(len(f.Payload))
+	}
+
+	if f.Flags.Has(FlagEndStream) {
+		if err := c.endRequest(st); err != nil {
+			return err
+		}
+	}
+
+	c.bodyRead(st, unread)
+
+	return nil
+}
+
+// bufferData adds data to the body of a stream that is handed over once complete
+func (c *conn) bufferData(st *stream, f *Frame, data []byte) error {
+	size := int64(len(f.Payload))
+
+	// the data is either buffered within maxBodySize and maxBufferedBodies or dropped, so
+	// the connection window is given back right away, the stream window keeps each body
+	// in check
+	if err := c.returnConnWindow(size); err != nil {
+		return err
+	}
+
+	if int64(st.body.Len()+len(data)) > c.config.maxBodySize {
+		return c.refuseBody(st)
+	}
+
+	c.mu.Lock()
+	full := c.buffered+int64(len(data)) > c.config.maxBufferedBodies
+	if !full {
+		c.buffered += int64(len(data))
+		st.buffered += int64(len(data))
+	}
+	c.mu.Unlock()
+
+	if full {
+		return StreamError{st.id, ErrCodeRefusedStream, "too many request bodies buffered"}
+	}
+
+	st.body.Write(data)
+
+	if f.Flags.Has(FlagEndStream) {
+		return c.endRequest(st)
+	}
+
+	// the window is never opened further than the body may grow
+	c.mu.Lock()
+	grant := min(size, c.config.maxBodySize-int64(st.body.Len())-st.recvWindow)
+	if grant > 0 {
+		st.recvWindow += grant
+	}
+	c.mu.Unlock()
+
+	if grant > 0 {
+		return c.writeWindowUpdate(st.id, uint32(grant))
+	}
+
+	return nil
+}
+
+// bodyRead gives back the windows for n bytes of a streamed body that were consumed, the
+// stream window only while the client may still send
+func (c *conn) bodyRead(st *stream, n int64) {
+	if n <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.recvWindow += n
+	open := !st.remoteClosed && !st.reset
+	if open {
+		st.recvWindow += n
+	}
+	c.mu.Unlock()
+
+	c.writeWindowUpdate(0, uint32(n))
+
+	if open {
+		c.writeWindowUpdate(st.id, uint32(n))
+	}
+}
+
+// returnConnWindow gives back the connection window for n bytes that were dropped or
+// taken out of flow control
+func (c *conn) returnConnWindow(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.recvWindow += n
+	c.mu.Unlock()
+
+	return c.writeWindowUpdate(0, uint32(n))
+}
+
+// refuseBody answers a request whose body is over maxBodySize with 413 and resets the
+// stream so the client stops sending, RFC 9113 section 8.1
+func (c *conn) refuseBody(st *stream) error {
+	fields := []HeaderField{{":status", "413"}, {"content-length", "0"}}
+	if err := c.writeHeaders(st, fields, true); err != nil {
+		return err
+	}
+
+	c.resetStream(st.id, ErrCodeNo)
+
+	return nil
+}
+
+func (c *conn) onSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+
+	if f.Flags.Has(FlagAck) {
+		if f.Length != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+
+		return nil
+	}
+
+	settings, err := ParseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applySettings(settings); err != nil {
+		return err
+	}
+
+	return c.writeFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+// applySettings takes over the client's settings that concern what the server sends.
+// The header table size is not among them, the encoder never indexes.
+func (c *conn) applySettings(settings []Setting) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range settings {
+		switch s.ID {
+		case SettingInitialWindowSize:
+			// the change applies to the windows of all open streams, section 6.9.2
+			delta := int64(s.Value) - c.peerWindow
+			c.peerWindow = int64(s.Value)
+
+			for _, st := range c.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+		case SettingMaxFrameSize:
+			c.peerMaxFrame = s.Value
+		}
+	}
+
+	c.cond.Broadcast()
+
+	return nil
+}
+
+func (c *conn) onWindowUpdate(f *Frame) error {
+	if f.Length != 4 {
+		return ConnError{ErrCodeFrameSize, "WINDOW_UPDATE length"}
+	}
+
+	increment := int64(binary.BigEndian.Uint32(f.Payload) & maxWindowSize)
+
+	if increment == 0 {
+		if f.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+		}
+
+		return StreamError{f.StreamID, ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+	}
+
+	if f.StreamID > c.lastStreamID {
+		return ConnError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.StreamID == 0 {
+		c.sendWindow += increment
+		if c.sendWindow > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window overflow"}
+		}
+	} else if st := c.streams[f.StreamID]; st != nil {
+		st.sendWindow += increment
+		if st.sendWindow > maxWindowSize {
+			return StreamError{f.StreamID, ErrCodeFlowControl, "stream window overflow"}
+		}
+	}
+
+	c.cond.Broadcast()
+
+	return nil
+}
+
+func (c *conn) newStream(id uint32) *stream {
+	st := &stream{
+		id:            id,
+		contentLength: -1,
+		recvWindow:    int64(c.config.windowSize),
+		done:          make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	st.sendWindow = c.peerWindow
+	c.streams[id] = st
+	c.mu.Unlock()
+
+	return st
+}
+
+// cancel marks st as reset and ends a streamed body, returning the bytes of it that were
+// dropped unread. The caller holds conn.mu.
+func (st *stream) cancel() int64 {
+	if st.reset {
+		return 0
+	}
+
+	st.reset = true
+	close(st.done)
+
+	if st.pipe != nil {
+		return st.pipe.abort(errStreamClosed)
+	}
+
+	return 0
+}
+
+// removeStream forgets a stream that was reset and returns the bytes its connection
+// window has to get back, the caller holds mu
+func (c *conn) removeStream(id uint32) int64 {
+	st, ok := c.streams[id]
+	if !ok {
+		return 0
+	}
+
+	dropped := st.cancel()
+	delete(c.streams, id)
+
+	// a buffered body that never reached a handler is let go of right away
+	if !st.dispatched {
+		c.buffered -= st.buffered
+		st.buffered = 0
+	}
+
+	c.cond.Broadcast()
+
+	return dropped
+}
+
+func (c *conn) resetStream(id uint32, code ErrCode) {
+	c.writeFrame(FrameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+
+	c.mu.Lock()
+	dropped := c.removeStream(id)
+	c.mu.Unlock()
+
+	c.returnConnWindow(dropped)
+}
+
+func (c *conn) goAway(code ErrCode, reason string) {
+	payload := binary.BigEndian.AppendUint32(nil, c.lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+
+	c.writeFrame(FrameGoAway, 0, 0, payload)
+}
+
+// endRequest hands a stream whose request is complete to the handler, or ends the body
+// the handler of a streamed request is reading
+func (c *conn) endRequest(st *stream) error {
+	c.mu.Lock()
+	st.remoteClosed = true
+	c.mu.Unlock()
+
+	if st.contentLength >= 0 && st.received != st.contentLength {
+		return StreamError{st.id, ErrCodeProtocol, "body does not match content-length"}
+	}
+
+	if st.pipe != nil {
+		st.pipe.closeWithError(io.EOF)
+		return nil
+	}
+
+	if st.body.Len() > 0 {
+		st.req.Body = st.body.Bytes()
+		st.req.Headers.Set("Content-Length", strconv.Itoa(st.body.Len()))
+	}
+
+	c.dispatch(st)
+
+	return nil
+}
+
+func (c *conn) dispatch(st *stream) {
+	c.mu.Lock()
+	st.dispatched = true
+	c.mu.Unlock()
+
+	go func() {
+		pr, pw := io.Pipe()
+		relayed := make(chan struct{})
+
+		go func() {
+			defer close(relayed)
+			c.relay(st, pr)
+		}()
+
+		c.handler(pw, st.req, st.done)
+		c.releaseBody(st)
+
+		pw.Close()
+		<-relayed
+
+		c.closeStream(st)
+	}()
+}
+
+// releaseBody lets go of the request body once the handler has returned
+func (c *conn) releaseBody(st *stream) {
+	if st.pipe != nil {
+		c.returnConnWindow(st.pipe.abort(errStreamClosed))
+		return
+	}
+
+	c.mu.Lock()
+	c.buffered -= st.buffered
+	st.buffered = 0
+	c.mu.Unlock()
+}
+
+// closeStream forgets a stream whose response is complete. A client still sending the
+// request is told to stop with NO_ERROR, RFC 9113 section 8.1.
+func (c *conn) closeStream(st *stream) {
+	c.mu.Lock()
+	current := c.streams[st.id] == st
+	open := current && !st.remoteClosed
+	if current && !open {
+		delete(c.streams, st.id)
+	}
+	c.mu.Unlock()
+
+	if open {
+		c.resetStream(st.id, ErrCodeNo)
+	}
+}
+
+// relay turns the HTTP/1.1 response the handler writes into frames on st
+func (c *conn) relay(st *stream, pr *io.PipeReader) {
+	// the handler must never block on a stream that is gone
+	defer io.Copy(io.Discard, pr)
+
+	err := c.writeResponse(st, bufio.NewReader(pr))
+
+	c.mu.Lock()
+	reset := st.reset
+	c.mu.Unlock()
+
+	if err != nil && !reset && !errors.Is(err, errStreamClosed) {
+		fmt.Println("error writing HTTP/2 response:", err)
+		c.resetStream(st.id, ErrCodeInternal)
+	}
+}
+
+func (c *conn) writeResponse(st *stream, br *bufio.Reader) error {
+	method := st.req.RequestLine.Method
+
+	resp, err := client.ReadResponse(br, method)
+	if err != nil {
+		return err
+	}
+
+	for _, interim := range resp.Interim {
+		if err := c.writeHeaders(st, responseFields(interim), false); err != nil {
+			return err
+		}
+	}
+
+	if resp.StatusCode == response.SwitchingProtocols {
+		return errors.New("error: protocol upgrades are not available over HTTP/2")
+	}
+
+	noBody := method == "HEAD" || resp.ContentLength == 0 ||
+		resp.StatusCode == 204 || resp.StatusCode == response.NotModified
+
+	if err := c.writeHeaders(st, responseFields(resp), noBody); err != nil || noBody {
+		return err
+	}
+
+	buf := make([]byte, minMaxFrameSize)
+
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if err := c.writeData(st, buf[:n], false); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(resp.Trailers) == 0 {
+		return c.writeData(st, nil, true)
+	}
+
+	trailers := make([]HeaderField, 0, len(resp.Trailers))
+	for k, v := range resp.Trailers {
+		trailers = append(trailers, HeaderField{strings.ToLower(k), v})
+	}
+
+	return c.writeHeaders(st, trailers, true)
+}
+
+// responseFields are the fields of resp without those HTTP/2 has no use for
+func responseFields(resp *client.Response) []HeaderField {
+	fields := []HeaderField{{":status", strconv.Itoa(int(resp.StatusCode))}}
+
+	for k, v := range resp.Headers {
+		name := strings.ToLower(k)
+		if !isConnectionHeader(name) {
+			fields = append(fields, HeaderField{name, v})
+		}
+	}
+
+	for _, v := range resp.SetCookie {
+		fields = append(fields, HeaderField{"set-cookie", v})
+	}
+
+	return fields
+}
+
+func isConnectionHeader(name string) bool {
+	for _, k := range connectionHeaders {
+		if name == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRequest builds the request of a stream from its header block, checking what RFC
+// 9113 section 8.3 requires of the pseudo-headers. The content-length is -1 without one.
+func (c *conn) newRequest(fields []HeaderField) (*request.Request, int64, error) {
+	pseudo := map[string]string{}
+	h := headers.NewHeaders()
+	regular := false
+
+	for _, f := range fields {
+		if name, ok := strings.CutPrefix(f.Name, ":"); ok {
+			if regular {
+				return nil, 0, errors.New("pseudo-header after regular header")
+			}
+
+			switch name {
+			case "method", "scheme", "path", "authority":
+			default:
+				return nil, 0, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+
+			if _, ok := pseudo[name]; ok {
+				return nil, 0, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+			}
+
+			pseudo[name] = f.Value
+
+			continue
+		}
+
+		regular = true
+
+		if !validFieldName(f.Name) || strings.ContainsAny(f.Value, "\r\n\x00") {
+			return nil, 0, fmt.Errorf("invalid header %q", f.Name)
+		}
+
+		if isConnectionHeader(f.Name) || (f.Name == "te" && f.Value != "trailers") {
+			return nil, 0, fmt.Errorf("connection-specific header %s", f.Name)
+		}
+
+		if previous := h.Get(f.Name); previous != "" {
+			// cookies may be split into several fields, section 8.2.3
+			separator := ", "
+			if f.Name == "cookie" {
+				separator = "; "
+			}
+
+			f.Value = previous + separator + f.Value
+		}
+
+		h.Set(f.Name, f.Value)
+	}
+
+	method := pseudo["method"]
+	target := pseudo["path"]
+
+	switch {
+	case method == "":
+		return nil, 0, errors.New("missing :method")
+	case method == "CONNECT":
+		if pseudo["authority"] == "" || pseudo["scheme"] != "" || target != "" {
+			return nil, 0, errors.New("malformed CONNECT request")
+		}
+
+		target = pseudo["authority"]
+	case pseudo["scheme"] == "" || target == "":
+		return nil, 0, errors.New("missing :scheme or :path")
+	}
+
+	if authority := pseudo["authority"]; authority != "" && !h.Exists("Host") {
+		h.Set("Host", authority)
+	}
+
+	contentLength := int64(-1)
+	if value := h.Get("Content-Length"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("invalid content-length %q", value)
+		}
+
+		contentLength = n
+	}
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: target,
+			HttpVersion:   "HTTP/2.0",
+		},
+		Headers:    h,
+		RemoteAddr: c.nc.RemoteAddr().String(),
+		Status:     request.RequestStateDone,
+	}
+
+	return req, contentLength, nil
+}
+
+// validFieldName reports whether name is a lowercase token, uppercase is malformed in HTTP/2
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+
+		switch {
+		case b >= 'a' && b <= 'z', b >= '0' && b <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", b) >= 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *conn) writeFrame(t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if err := WriteFrame(c.bw, t, flags, streamID, payload); err != nil {
+		return err
+	}
+
+	return c.bw.Flush()
+}
+
+func (c *conn) writeWindowUpdate(streamID, increment uint32) error {
+	return c.writeFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+// writeHeaders sends a header block on st, split into CONTINUATION frames as needed
+func (c *conn) writeHeaders(st *stream, fields []HeaderField, endStream bool) error {
+	block := AppendHeaderBlock(nil, fields)
+
+	c.mu.Lock()
+	gone := st.reset || c.closed
+	maxFrame := int(c.peerMaxFrame)
+	c.mu.Unlock()
+
+	if gone {
+		return errStreamClosed
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	frameType := FrameHeaders
+	flags := Flags(0)
+	if endStream {
+		flags = FlagEndStream
+	}
+
+	for {
+		chunk := block[:min(len(block), maxFrame)]
+		block = block[len(chunk):]
+
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+
+		if err := WriteFrame(c.bw, frameType, flags, st.id, chunk); err != nil {
+			return err
+		}
+
+		if len(block) == 0 {
+			return c.bw.Flush()
+		}
+
+		frameType, flags = FrameContinuation, 0
+	}
+}
+
+// writeData sends p on st as the send windows allow, waiting for WINDOW_UPDATE when
+// they are used up
+func (c *conn) writeData(st *stream, p []byte, endStream bool) error {
+	for {
+		c.mu.Lock()
+		for len(p) > 0 && !st.reset && !c.closed && (c.sendWindow <= 0 || st.sendWindow <= 0) {
+			c.cond.Wait()
+		}
+
+		if st.reset || c.closed {
+			c.mu.Unlock()
+			return errStreamClosed
+		}
+
+		n := min(int64(len(p)), c.sendWindow, st.sendWindow, int64(c.peerMaxFrame))
+		c.sendWindow -= n
+		st.sendWindow -= n
+		c.mu.Unlock()
+
+		chunk := p[:n]
+		p = p[n:]
+
+		flags := Flags(0)
+		if endStream && len(p) == 0 {
+			flags = FlagEndStream
+		}
+
+		if err := c.writeFrame(FrameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// ParseUpgradeSettings decodes the HTTP2-Settings header of an h2c upgrade request, a
+// SETTINGS payload in unpadded base64url, RFC 7540 section 3.2.1
+func ParseUpgradeSettings(value string) ([]Setting, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid HTTP2-Settings: %w", err)
+	}
+
+	return ParseSettings(payload)
+}
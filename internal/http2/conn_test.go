@@ -0,0 +1,724 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo answers with the method, target and body of the request
+func echo(w io.Writer, req *request.Request, closed <-chan struct{}) {
+	body := fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body)
+	fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nConnection: close\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	br      *bufio.Reader
+	decoder *Decoder
+}
+
+type testResponse struct {
+	fields   []HeaderField
+	body     string
+	trailers []HeaderField
+}
+
+// dialServer serves handler on a loopback connection, the client has sent the preface
+// and settings
+func dialServer(t *testing.T, handler Handler, settings []Setting, options ...Option) *testClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		nc, err := l.Accept()
+		if err == nil {
+			ServeConn(nc, handler, options...)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	c := &testClient{t: t, conn: conn, br: bufio.NewReader(conn), decoder: NewDecoder(4096, 0)}
+
+	_, err = io.WriteString(conn, Preface)
+	require.NoError(t, err)
+	c.write(FrameSettings, 0, 0, AppendSettings(nil, settings...))
+
+	return c
+}
+
+func (c *testClient) write(t FrameType, flags Flags, id uint32, payload []byte) {
+	require.NoError(c.t, WriteFrame(c.conn, t, flags, id, payload))
+}
+
+func (c *testClient) request(id uint32, endStream bool, fields ...HeaderField) {
+	flags := FlagEndHeaders
+	if endStream {
+		flags |= FlagEndStream
+	}
+
+	c.write(FrameHeaders, flags, id, AppendHeaderBlock(nil, fields))
+}
+
+func (c *testClient) get(id uint32, path string) {
+	c.request(id, true, HeaderField{":method", "GET"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", path}, HeaderField{":authority", "example.com"})
+}
+
+// next returns the next frame that is not connection housekeeping
+func (c *testClient) next() *Frame {
+	for {
+		f, err := ReadFrame(c.br, maxMaxFrameSize)
+		require.NoError(c.t, err)
+
+		switch f.Type {
+		case FrameSettings, FrameWindowUpdate:
+			continue
+		}
+
+		return f
+	}
+}
+
+// response collects the frames of stream id until it ends
+func (c *testClient) response(id uint32) testResponse {
+	var resp testResponse
+
+	for {
+		f := c.next()
+		require.Equal(c.t, id, f.StreamID, "frame %d", f.Type)
+
+		switch f.Type {
+		case FrameHeaders:
+			fields, err := c.decoder.Decode(f.Payload)
+			require.NoError(c.t, err)
+
+			if resp.fields == nil {
+				resp.fields = fields
+			} else {
+				resp.trailers = fields
+			}
+		case FrameData:
+			resp.body += string(f.Payload)
+		default:
+			c.t.Fatalf("unexpected frame %d", f.Type)
+		}
+
+		if f.Flags.Has(FlagEndStream) {
+			return resp
+		}
+	}
+}
+
+func (r testResponse) field(name string) string {
+	for _, f := range r.fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+
+	return ""
+}
+
+func TestServeConnAnswersRequests(t *testing.T) {
+	c := dialServer(t, echo, nil)
+	c.get(1, "/hello?x=1")
+
+	resp := c.response(1)
+	assert.Equal(t, []HeaderField{
+		{":status", "200"},
+		{"content-length", "15"},
+		{"content-type", "text/plain"},
+	}, sortFields(resp.fields))
+	assert.Equal(t, "GET /hello?x=1 ", resp.body)
+	assert.Equal(t, ":status", resp.fields[0].Name)
+}
+
+func sortFields(fields []HeaderField) []HeaderField {
+	sorted := append([]HeaderField(nil), fields...)
+	for i := 1; i < len(sorted); i++ {
+		for j := i; j > 0 && sorted[j].Name < sorted[j-1].Name; j-- {
+			sorted[j], sorted[j-1] = sorted[j-1], sorted[j]
+		}
+	}
+
+	return sorted
+}
+
+func TestServeConnRequestBody(t *testing.T) {
+	c := dialServer(t, echo, nil)
+
+	c.request(1, false, HeaderField{":method", "POST"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/upload"}, HeaderField{":authority", "example.com"},
+		HeaderField{"content-length", "11"})
+	c.write(FrameData, 0, 1, []byte("hello "))
+	c.write(FrameData, FlagEndStream|FlagPadded, 1, []byte("\x03world\x00\x00\x00"))
+
+	assert.Equal(t, "POST /upload hello world", c.response(1).body)
+}
+
+func TestServeConnMultiplexesStreams(t *testing.T) {
+	release := make(chan struct{})
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-release
+		}
+
+		echo(w, req, closed)
+	}, nil)
+
+	c.get(1, "/slow")
+	c.get(3, "/fast")
+
+	// stream 3 is answered while stream 1 is still waiting
+	assert.Equal(t, "GET /fast ", c.response(3).body)
+
+	close(release)
+	assert.Equal(t, "GET /slow ", c.response(1).body)
+}
+
+func TestServeConnRequestHeaders(t *testing.T) {
+	got := make(chan *request.Request, 1)
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		got <- req
+		echo(w, req, closed)
+	}, nil)
+
+	c.request(1, true, HeaderField{":method", "GET"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/"}, HeaderField{":authority", "example.com:8080"},
+		HeaderField{"cookie", "a=1"}, HeaderField{"cookie", "b=2"},
+		HeaderField{"accept", "text/html"}, HeaderField{"accept", "*/*"})
+	c.response(1)
+
+	req := <-got
+	assert.Equal(t, "HTTP/2.0", req.RequestLine.HttpVersion)
+	assert.Equal(t, "example.com:8080", req.Headers.Get("Host"))
+	assert.Equal(t, "a=1; b=2", req.Headers.Get("Cookie"))
+	assert.Equal(t, "text/html, */*", req.Headers.Get("Accept"))
+}
+
+func TestServeConnRejectsMalformedRequests(t *testing.T) {
+	base := []HeaderField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}}
+
+	cases := map[string][]HeaderField{
+		"missing path":           {{":method", "GET"}, {":scheme", "http"}},
+		"uppercase name":         append(base, HeaderField{"X-Upper", "1"}),
+		"connection header":      append(base, HeaderField{"connection", "keep-alive"}),
+		"te other than trailers": append(base, HeaderField{"te", "gzip"}),
+		"pseudo after regular":   {{":method", "GET"}, {"accept", "*/*"}, {":scheme", "http"}, {":path", "/"}},
+		"unknown pseudo":         append(base, HeaderField{":protocol", "websocket"}),
+		"duplicate pseudo":       append(base, HeaderField{":path", "/again"}),
+	}
+
+	for name, fields := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := dialServer(t, echo, nil)
+			c.request(1, true, fields...)
+
+			f := c.next()
+			assert.Equal(t, FrameRSTStream, f.Type)
+			assert.Equal(t, uint32(1), f.StreamID)
+			assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload))
+
+			// the connection carries on
+			c.get(3, "/next")
+			assert.Equal(t, "GET /next ", c.response(3).body)
+		})
+	}
+}
+
+func TestServeConnFlowControl(t *testing.T) {
+	body := strings.Repeat("x", 100000)
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}, []Setting{{SettingInitialWindowSize, 1000}})
+
+	c.get(1, "/")
+
+	f := c.next()
+	require.Equal(t, FrameHeaders, f.Type)
+
+	received, window := 0, 1000
+	for received < len(body) {
+		f = c.next()
+		require.Equal(t, FrameData, f.Type)
+
+		// the server stops at the stream window until it grows
+		received += len(f.Payload)
+		require.LessOrEqual(t, received, window)
+
+		if received == window {
+			c.write(FrameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 1000))
+			c.write(FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, 1000))
+			window += 1000
+		}
+	}
+
+	assert.Equal(t, len(body), received)
+}
+
+func TestServeConnSplitsFramesToMaxFrameSize(t *testing.T) {
+	body := strings.Repeat("y", 3*minMaxFrameSize)
+	// together the fields do not fit in one frame
+	large := strings.Repeat("z", 6000)
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nX-A: %s\r\nX-B: %s\r\nX-C: %s\r\nX-D: %s\r\nContent-Length: %d\r\n\r\n%s",
+			large, large, large, large, len(body), body)
+	}, []Setting{{SettingInitialWindowSize, maxWindowSize}})
+
+	c.write(FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, 1<<20))
+	c.get(1, "/")
+
+	f := c.next()
+	require.Equal(t, FrameHeaders, f.Type)
+	assert.False(t, f.Flags.Has(FlagEndHeaders))
+
+	block := f.Payload
+	for !f.Flags.Has(FlagEndHeaders) {
+		f = c.next()
+		require.Equal(t, FrameContinuation, f.Type)
+		block = append(block, f.Payload...)
+	}
+
+	fields, err := c.decoder.Decode(block)
+	require.NoError(t, err)
+	assert.Contains(t, fields, HeaderField{"x-d", large})
+
+	received := 0
+	for {
+		f = c.next()
+		require.Equal(t, FrameData, f.Type)
+		assert.LessOrEqual(t, len(f.Payload), minMaxFrameSize)
+
+		received += len(f.Payload)
+		if f.Flags.Has(FlagEndStream) {
+			break
+		}
+	}
+
+	assert.Equal(t, len(body), received)
+}
+
+func TestServeConnTrailers(t *testing.T) {
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		io.WriteString(w, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"+
+			"5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n")
+	}, nil)
+
+	c.get(1, "/")
+
+	resp := c.response(1)
+	assert.Equal(t, "hello", resp.body)
+	assert.Equal(t, "", resp.field("transfer-encoding"))
+	assert.Equal(t, []HeaderField{{"x-checksum", "abc"}}, resp.trailers)
+}
+
+func TestServeConnEmptyResponses(t *testing.T) {
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		io.WriteString(w, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\n\r\n")
+	}, nil)
+
+	c.get(1, "/")
+
+	f := c.next()
+	require.Equal(t, FrameHeaders, f.Type)
+	assert.False(t, f.Flags.Has(FlagEndStream))
+
+	interim, err := c.decoder.Decode(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{":status", "100"}}, interim)
+
+	f = c.next()
+	require.Equal(t, FrameHeaders, f.Type)
+	assert.True(t, f.Flags.Has(FlagEndStream))
+
+	fields, err := c.decoder.Decode(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{":status", "204"}, {"set-cookie", "a=1"}, {"set-cookie", "b=2"}}, fields)
+}
+
+func TestServeConnResetClosesStream(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		if req.RequestLine.RequestTarget == "/wait" {
+			<-closed
+			close(cancelled)
+
+			return
+		}
+
+		echo(w, req, closed)
+	}, nil)
+
+	c.get(1, "/wait")
+	c.write(FrameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not told about the reset")
+	}
+
+	c.get(3, "/")
+	assert.Equal(t, "GET / ", c.response(3).body)
+}
+
+func TestServeConnRefusesStreamsOverLimit(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		<-release
+	}, nil, WithMaxConcurrentStreams(1))
+
+	c.get(1, "/")
+	c.get(3, "/")
+
+	f := c.next()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeRefusedStream), binary.BigEndian.Uint32(f.Payload))
+}
+
+func TestServeConnPing(t *testing.T) {
+	c := dialServer(t, echo, nil)
+	c.write(FramePing, 0, 0, []byte("12345678"))
+
+	f := c.next()
+	assert.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Flags.Has(FlagAck))
+	assert.Equal(t, []byte("12345678"), f.Payload)
+}
+
+func TestServeConnGoesAwayOnProtocolErrors(t *testing.T) {
+	cases := map[string]func(c *testClient){
+		"even stream id":       func(c *testClient) { c.get(2, "/") },
+		"decreasing stream id": func(c *testClient) { c.get(5, "/"); c.response(5); c.get(3, "/") },
+		"continuation alone":   func(c *testClient) { c.write(FrameContinuation, FlagEndHeaders, 1, nil) },
+		"push promise":         func(c *testClient) { c.write(FramePushPromise, 0, 1, make([]byte, 4)) },
+		"window overflow": func(c *testClient) {
+			c.write(FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, maxWindowSize))
+		},
+		"bad hpack": func(c *testClient) { c.write(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, []byte{0x80}) },
+	}
+
+	for name, send := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := dialServer(t, echo, nil)
+			send(c)
+
+			f := c.next()
+			require.Equal(t, FrameGoAway, f.Type)
+
+			code := ErrCode(binary.BigEndian.Uint32(f.Payload[4:]))
+			assert.Contains(t, []ErrCode{ErrCodeProtocol, ErrCodeFlowControl, ErrCodeCompression, ErrCodeStreamClosed}, code)
+
+			_, err := c.br.ReadByte()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestServeConnRequiresSettingsFirst(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		nc, err := l.Accept()
+		if err == nil {
+			ServeConn(nc, echo)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	io.WriteString(conn, Preface)
+	WriteFrame(conn, FramePing, 0, 0, make([]byte, 8))
+
+	br := bufio.NewReader(conn)
+	for {
+		f, err := ReadFrame(br, maxMaxFrameSize)
+		require.NoError(t, err)
+
+		if f.Type == FrameGoAway {
+			assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload[4:]))
+			return
+		}
+	}
+}
+
+func TestServeUpgrade(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/upgraded", HttpVersion: "HTTP/1.1"},
+		Headers:     headers.NewHeaders(),
+		Status:      request.RequestStateDone,
+	}
+	req.Headers.Set("Host", "example.com")
+	req.Headers.Set("Connection", "Upgrade, HTTP2-Settings")
+	req.Headers.Set("Upgrade", "h2c")
+
+	got := make(chan *request.Request, 1)
+	go ServeUpgrade(server, req, nil, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		got <- req
+		echo(w, req, closed)
+	})
+
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	c := &testClient{t: t, conn: client, br: bufio.NewReader(client), decoder: NewDecoder(4096, 0)}
+
+	status, err := c.br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+
+	for line := ""; line != "\r\n"; {
+		line, err = c.br.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	// the preface is still owed to the server, which answers stream 1 without waiting for it
+	go func() {
+		io.WriteString(client, Preface)
+		WriteFrame(client, FrameSettings, 0, 0, nil)
+	}()
+
+	assert.Equal(t, "GET /upgraded ", c.response(1).body)
+	upgraded := <-got
+	assert.False(t, upgraded.Headers.Exists("Upgrade"))
+	assert.Equal(t, "HTTP/2.0", upgraded.RequestLine.HttpVersion)
+}
+
+func TestServeConnRefusesLargeBodies(t *testing.T) {
+	post := func(c *testClient, id uint32, fields ...HeaderField) {
+		c.request(id, false, append([]HeaderField{{":method", "POST"}, {":scheme", "http"},
+			{":path", "/upload"}, {":authority", "example.com"}}, fields...)...)
+	}
+
+	expectRefused := func(c *testClient, id uint32) {
+		f := c.next()
+		require.Equal(t, FrameHeaders, f.Type)
+		assert.True(t, f.Flags.Has(FlagEndStream))
+
+		fields, err := c.decoder.Decode(f.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, HeaderField{":status", "413"}, fields[0])
+
+		f = c.next()
+		require.Equal(t, FrameRSTStream, f.Type)
+		assert.Equal(t, id, f.StreamID)
+		assert.Equal(t, uint32(ErrCodeNo), binary.BigEndian.Uint32(f.Payload))
+	}
+
+	c := dialServer(t, echo, nil, WithMaxBodySize(100))
+
+	// declared too large up front
+	post(c, 1, HeaderField{"content-length", "1000"})
+	expectRefused(c, 1)
+
+	// found out while the body arrives
+	post(c, 3)
+	c.write(FrameData, 0, 3, make([]byte, 60))
+	c.write(FrameData, 0, 3, make([]byte, 60))
+	expectRefused(c, 3)
+
+	// bodies within the limit are still accepted
+	post(c, 5)
+	c.write(FrameData, FlagEndStream, 5, []byte("small"))
+	assert.Equal(t, "POST /upload small", c.response(5).body)
+}
+
+func TestServeConnStreamWindowFollowsMaxBodySize(t *testing.T) {
+	c := dialServer(t, echo, nil, WithMaxBodySize(DefaultWindowSize+1000), WithWindowSize(DefaultWindowSize))
+
+	c.request(1, false, HeaderField{":method", "POST"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/upload"}, HeaderField{":authority", "example.com"})
+	c.write(FrameData, 0, 1, make([]byte, 10000))
+
+	// the stream window is reopened only as far as the remaining 1000 bytes
+	for {
+		f, err := ReadFrame(c.br, maxMaxFrameSize)
+		require.NoError(t, err)
+
+		if f.Type == FrameWindowUpdate && f.StreamID == 1 {
+			assert.Equal(t, uint32(1000), binary.BigEndian.Uint32(f.Payload))
+			return
+		}
+	}
+}
+
+func TestServeConnMaxHeaderListSize(t *testing.T) {
+	c := dialServer(t, echo, nil, WithMaxHeaderListSize(256))
+
+	c.request(1, true, HeaderField{":method", "GET"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/"}, HeaderField{"x-large", strings.Repeat("a", 300)})
+
+	f := c.next()
+	require.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, uint32(ErrCodeCompression), binary.BigEndian.Uint32(f.Payload[4:]))
+}
+
+func post(c *testClient, id uint32) {
+	c.request(id, false, HeaderField{":method", "POST"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/upload"}, HeaderField{":authority", "example.com"})
+}
+
+func TestServeConnLimitsBufferedBodies(t *testing.T) {
+	c := dialServer(t, echo, nil, WithMaxBufferedBodies(100))
+
+	post(c, 1)
+	c.write(FrameData, 0, 1, make([]byte, 60))
+
+	// both bodies would not fit at once
+	post(c, 3)
+	c.write(FrameData, 0, 3, make([]byte, 60))
+
+	f := c.next()
+	require.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeRefusedStream), binary.BigEndian.Uint32(f.Payload))
+
+	c.write(FrameData, FlagEndStream, 1, []byte("done"))
+	assert.Equal(t, "POST /upload "+string(make([]byte, 60))+"done", c.response(1).body)
+
+	// the first body is let go of once its handler returned
+	post(c, 5)
+	c.write(FrameData, FlagEndStream, 5, make([]byte, 60))
+	assert.Len(t, c.response(5).body, len("POST /upload ")+60)
+}
+
+func TestServeConnStreamsBodies(t *testing.T) {
+	// answers every piece of the body as it arrives, like a bidirectional RPC
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		io.WriteString(w, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")
+
+		buf := make([]byte, 64)
+		for {
+			n, err := req.BodyReader().Read(buf)
+			if n > 0 {
+				fmt.Fprintf(w, "%x\r\necho %s\r\n", n+5, buf[:n])
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		io.WriteString(w, "0\r\n\r\n")
+	}, nil, WithStreamingBodies())
+
+	post(c, 1)
+
+	f := c.next()
+	require.Equal(t, FrameHeaders, f.Type)
+
+	for _, message := range []string{"ping", "pong"} {
+		c.write(FrameData, 0, 1, []byte(message))
+
+		f = c.next()
+		require.Equal(t, FrameData, f.Type)
+		assert.Equal(t, "echo "+message, string(f.Payload))
+	}
+
+	c.write(FrameData, FlagEndStream, 1, nil)
+
+	f = c.next()
+	require.Equal(t, FrameData, f.Type)
+	assert.True(t, f.Flags.Has(FlagEndStream))
+}
+
+func TestServeConnStreamedBodyWindowFollowsReads(t *testing.T) {
+	release := make(chan struct{})
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		<-release
+
+		body, err := io.ReadAll(req.BodyReader())
+		assert.NoError(t, err)
+
+		fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%d", len(strconv.Itoa(len(body))), len(body))
+	}, nil, WithStreamingBodies(), WithWindowSize(defaultWindowSize))
+
+	post(c, 1)
+
+	// fill both windows while the handler is not reading
+	for sent := 0; sent < defaultWindowSize; sent += minMaxFrameSize {
+		c.write(FrameData, 0, 1, make([]byte, min(minMaxFrameSize, defaultWindowSize-sent)))
+	}
+
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	for {
+		f, err := ReadFrame(c.br, maxMaxFrameSize)
+		if err != nil {
+			break
+		}
+
+		assert.NotEqual(t, FrameWindowUpdate, f.Type, "window given back before the body was read")
+	}
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	close(release)
+
+	granted := map[uint32]int{}
+	for granted[0] < defaultWindowSize || granted[1] < defaultWindowSize {
+		f, err := ReadFrame(c.br, maxMaxFrameSize)
+		require.NoError(t, err)
+
+		if f.Type == FrameWindowUpdate {
+			granted[f.StreamID] += int(binary.BigEndian.Uint32(f.Payload))
+		}
+	}
+
+	c.write(FrameData, FlagEndStream, 1, nil)
+	assert.Equal(t, strconv.Itoa(defaultWindowSize), c.response(1).body)
+}
+
+func TestServeConnResetEndsStreamedBody(t *testing.T) {
+	readErr := make(chan error, 1)
+
+	c := dialServer(t, func(w io.Writer, req *request.Request, closed <-chan struct{}) {
+		_, err := io.ReadAll(req.BodyReader())
+		readErr <- err
+	}, nil, WithStreamingBodies())
+
+	post(c, 1)
+	c.write(FrameData, 0, 1, []byte("partial"))
+	c.write(FrameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+
+	select {
+	case err := <-readErr:
+		assert.ErrorIs(t, err, errStreamClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler kept waiting for the body of a reset stream")
+	}
+}
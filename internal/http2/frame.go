@@ -0,0 +1,215 @@
+// Package http2 serves HTTP/2 over cleartext connections (h2c), RFC 9113, with header
+// compression from RFC 7541
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Preface is what a client sends first on an HTTP/2 connection
+	Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	frameHeaderLen = 9
+	// minMaxFrameSize is the frame size every endpoint has to accept, and the default
+	minMaxFrameSize = 1 << 14
+	maxMaxFrameSize = 1<<24 - 1
+	maxWindowSize   = 1<<31 - 1
+	// defaultWindowSize is the initial window of connections and streams before SETTINGS
+	defaultWindowSize = 65535
+)
+
+var ErrFrameTooLarge = errors.New("error: frame larger than SETTINGS_MAX_FRAME_SIZE")
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+// ErrCode is sent in RST_STREAM and GOAWAY, RFC 9113 section 7
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+// ConnError ends the connection with a GOAWAY
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("error: HTTP/2 connection error %d: %s", e.Code, e.Reason)
+}
+
+// StreamError resets a single stream
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("error: HTTP/2 stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+type FrameHeader struct {
+	Length   uint32
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+}
+
+type Frame struct {
+	FrameHeader
+	Payload []byte
+}
+
+// ReadFrame reads the next frame, rejecting payloads over maxSize before reading them
+func ReadFrame(r io.Reader, maxSize uint32) (*Frame, error) {
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	f := &Frame{FrameHeader: FrameHeader{
+		Length:   uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2]),
+		Type:     FrameType(head[3]),
+		Flags:    Flags(head[4]),
+		StreamID: binary.BigEndian.Uint32(head[5:]) & maxWindowSize,
+	}}
+
+	if f.Length > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, f.Length)
+	}
+
+	f.Payload = make([]byte, f.Length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// WriteFrame writes a frame, the caller keeps payload within the peer's maximum frame size
+func WriteFrame(w io.Writer, t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	head := [frameHeaderLen]byte{
+		byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)),
+		byte(t), byte(flags),
+	}
+	binary.BigEndian.PutUint32(head[5:], streamID)
+
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+
+	return err
+}
+
+// unpad strips the padding of DATA and HEADERS frames, RFC 9113 section 6.1
+func unpad(f *Frame) ([]byte, error) {
+	p := f.Payload
+	if !f.Flags.Has(FlagPadded) {
+		return p, nil
+	}
+
+	if len(p) == 0 || int(p[0]) >= len(p) {
+		return nil, ConnError{ErrCodeProtocol, "padding exceeds the frame"}
+	}
+
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+// ParseSettings decodes a SETTINGS payload and checks the values, RFC 9113 section 6.5.2
+func ParseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "SETTINGS length is not a multiple of 6"}
+	}
+
+	settings := make([]Setting, 0, len(payload)/6)
+
+	for p := payload; len(p) > 0; p = p[6:] {
+		s := Setting{ID: SettingID(binary.BigEndian.Uint16(p)), Value: binary.BigEndian.Uint32(p[2:])}
+
+		switch {
+		case s.ID == SettingEnablePush && s.Value > 1:
+			return nil, ConnError{ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+		case s.ID == SettingInitialWindowSize && s.Value > maxWindowSize:
+			return nil, ConnError{ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+		case s.ID == SettingMaxFrameSize && (s.Value < minMaxFrameSize || s.Value > maxMaxFrameSize):
+			return nil, ConnError{ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+		}
+
+		settings = append(settings, s)
+	}
+
+	return settings, nil
+}
+
+func AppendSettings(dst []byte, settings ...Setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(s.ID))
+		dst = binary.BigEndian.AppendUint32(dst, s.Value)
+	}
+
+	return dst
+}
@@ -0,0 +1,79 @@
+package http2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, FrameHeaders, FlagEndStream|FlagEndHeaders, 7, []byte("block")))
+
+	f, err := ReadFrame(&buf, minMaxFrameSize)
+	require.NoError(t, err)
+
+	assert.Equal(t, FrameHeader{Length: 5, Type: FrameHeaders, Flags: FlagEndStream | FlagEndHeaders, StreamID: 7}, f.FrameHeader)
+	assert.Equal(t, []byte("block"), f.Payload)
+	assert.True(t, f.Flags.Has(FlagEndHeaders))
+	assert.False(t, f.Flags.Has(FlagPadded))
+}
+
+func TestReadFrameRejectsLargeFrames(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, FrameData, 0, 1, make([]byte, minMaxFrameSize+1)))
+
+	_, err := ReadFrame(&buf, minMaxFrameSize)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+}
+
+func TestUnpad(t *testing.T) {
+	f := &Frame{FrameHeader: FrameHeader{Flags: FlagPadded}, Payload: []byte("\x02data\x00\x00")}
+
+	data, err := unpad(f)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	f.Payload = []byte("\x05abc")
+	_, err = unpad(f)
+	assert.ErrorAs(t, err, &ConnError{})
+}
+
+func TestSettings(t *testing.T) {
+	settings := []Setting{{SettingInitialWindowSize, 1 << 20}, {SettingMaxFrameSize, 1 << 15}, {0x99, 1}}
+
+	parsed, err := ParseSettings(AppendSettings(nil, settings...))
+	require.NoError(t, err)
+	assert.Equal(t, settings, parsed)
+
+	invalid := map[string][]byte{
+		"length":       {0, 1, 0},
+		"enable push":  AppendSettings(nil, Setting{SettingEnablePush, 2}),
+		"window size":  AppendSettings(nil, Setting{SettingInitialWindowSize, 1 << 31}),
+		"frame size":   AppendSettings(nil, Setting{SettingMaxFrameSize, 1 << 10}),
+		"frame size 2": AppendSettings(nil, Setting{SettingMaxFrameSize, 1 << 24}),
+	}
+
+	for name, payload := range invalid {
+		_, err := ParseSettings(payload)
+		assert.ErrorAs(t, err, &ConnError{}, name)
+	}
+}
+
+func TestParseUpgradeSettings(t *testing.T) {
+	// what net/http and curl send, with and without padding
+	for _, value := range []string{"AAMAAABkAAQAoAAAAAIAAAAA", "AAMAAABkAAQAoAAAAAIAAAAA=="} {
+		settings, err := ParseUpgradeSettings(value)
+		require.NoError(t, err)
+		assert.Equal(t, []Setting{
+			{SettingMaxConcurrentStreams, 100},
+			{SettingInitialWindowSize, 10485760},
+			{SettingEnablePush, 0},
+		}, settings)
+	}
+
+	_, err := ParseUpgradeSettings("not base64!")
+	assert.Error(t, err)
+}
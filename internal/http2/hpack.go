@@ -0,0 +1,382 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrCompression = errors.New("error: invalid HPACK header block")
+
+// HeaderField is a decoded header or pseudo-header, names are lowercase on the wire
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// size is the size of the field in the dynamic table, RFC 7541 section 4.1
+func (f HeaderField) size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+// staticTable is RFC 7541 Appendix A, index 1 is staticTable[0]
+var staticTable = []HeaderField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// dynamicTable holds the fields added by the peer, newest last
+type dynamicTable struct {
+	entries []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	// a field larger than the table empties it, RFC 7541 section 4.4
+	if f.size() > t.maxSize {
+		t.entries = t.entries[:0]
+		t.size = 0
+
+		return
+	}
+
+	t.size += f.size()
+	t.entries = append(t.entries, f)
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	n := 0
+	for t.size > t.maxSize {
+		t.size -= t.entries[n].size()
+		n++
+	}
+
+	t.entries = append(t.entries[:0], t.entries[n:]...)
+}
+
+// field looks up index in the static table followed by the dynamic table
+func (t *dynamicTable) field(index uint64) (HeaderField, error) {
+	if index == 0 {
+		return HeaderField{}, fmt.Errorf("%w: index 0", ErrCompression)
+	}
+
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], nil
+	}
+
+	i := index - uint64(len(staticTable))
+	if i > uint64(len(t.entries)) {
+		return HeaderField{}, fmt.Errorf("%w: index %d out of range", ErrCompression, index)
+	}
+
+	return t.entries[uint64(len(t.entries))-i], nil
+}
+
+// Decoder decodes header blocks from one peer, its dynamic table lives as long as the
+// connection
+type Decoder struct {
+	table dynamicTable
+	// maxTableSize is the SETTINGS_HEADER_TABLE_SIZE we advertised, the peer may shrink
+	// the table below it but not grow it beyond
+	maxTableSize uint32
+	// maxListSize bounds the decoded fields, as SETTINGS_MAX_HEADER_LIST_SIZE counts them
+	maxListSize uint32
+}
+
+func NewDecoder(maxTableSize, maxListSize uint32) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+		maxListSize:  maxListSize,
+	}
+}
+
+// Decode decodes a complete header block. Any error leaves the table out of sync with
+// the peer, so the connection has to be closed with COMPRESSION_ERROR.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	var listSize uint32
+
+	p := block
+	for len(p) > 0 {
+		var f HeaderField
+		var err error
+
+		b := p[0]
+
+		switch {
+		case b&0x80 != 0:
+			// indexed header field, section 6.1
+			var index uint64
+			if index, p, err = readInt(p, 7); err != nil {
+				return nil, err
+			}
+
+			if f, err = d.table.field(index); err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing, section 6.2.1
+			if f, p, err = d.readLiteral(p, 6); err != nil {
+				return nil, err
+			}
+
+			d.table.add(f)
+		case b&0xe0 == 0x20:
+			// dynamic table size update, section 6.3, only at the start of a block
+			if len(fields) > 0 {
+				return nil, fmt.Errorf("%w: table size update after a field", ErrCompression)
+			}
+
+			var size uint64
+			if size, p, err = readInt(p, 5); err != nil {
+				return nil, err
+			}
+
+			if size > uint64(d.maxTableSize) {
+				return nil, fmt.Errorf("%w: table size %d over the limit", ErrCompression, size)
+			}
+
+			d.table.setMaxSize(uint32(size))
+
+			continue
+		default:
+			// literal without indexing or never indexed, sections 6.2.2 and 6.2.3
+			if f, p, err = d.readLiteral(p, 4); err != nil {
+				return nil, err
+			}
+		}
+
+		listSize += f.size()
+		if d.maxListSize > 0 && listSize > d.maxListSize {
+			return nil, fmt.Errorf("%w: header list larger than %d bytes", ErrCompression, d.maxListSize)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix
+func (d *Decoder) readLiteral(p []byte, n uint8) (HeaderField, []byte, error) {
+	index, p, err := readInt(p, n)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var f HeaderField
+
+	if index > 0 {
+		indexed, err := d.table.field(index)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+
+		f.Name = indexed.Name
+	} else if f.Name, p, err = readString(p); err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	if f.Value, p, err = readString(p); err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	return f, p, nil
+}
+
+// readInt decodes an integer with an n-bit prefix, RFC 7541 section 5.1
+func readInt(p []byte, n uint8) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated integer", ErrCompression)
+	}
+
+	limit := uint64(1)<<n - 1
+	v := uint64(p[0]) & limit
+	p = p[1:]
+
+	if v < limit {
+		return v, p, nil
+	}
+
+	for shift := uint(0); ; shift += 7 {
+		if len(p) == 0 {
+			return 0, nil, fmt.Errorf("%w: truncated integer", ErrCompression)
+		}
+
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("%w: integer overflow", ErrCompression)
+		}
+
+		b := p[0]
+		p = p[1:]
+		v += uint64(b&0x7f) << shift
+
+		if b&0x80 == 0 {
+			return v, p, nil
+		}
+	}
+}
+
+// readString decodes a string literal, RFC 7541 section 5.2
+func readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, fmt.Errorf("%w: truncated string", ErrCompression)
+	}
+
+	huffman := p[0]&0x80 != 0
+
+	length, p, err := readInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if uint64(len(p)) < length {
+		return "", nil, fmt.Errorf("%w: truncated string", ErrCompression)
+	}
+
+	data, p := p[:length], p[length:]
+
+	if !huffman {
+		return string(data), p, nil
+	}
+
+	s, err := huffmanDecode(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s, p, nil
+}
+
+// AppendHeaderBlock encodes fields without touching the peer's dynamic table: fields
+// found in the static table are indexed, everything else is sent as a literal without
+// indexing, Huffman coded when that is shorter.
+func AppendHeaderBlock(dst []byte, fields []HeaderField) []byte {
+	for _, f := range fields {
+		nameIndex := 0
+
+		for i, s := range staticTable {
+			if s.Name != f.Name {
+				continue
+			}
+
+			if s.Value == f.Value {
+				nameIndex = -(i + 1)
+				break
+			}
+
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+		}
+
+		if nameIndex < 0 {
+			dst = appendInt(dst, 0x80, 7, uint64(-nameIndex))
+			continue
+		}
+
+		dst = appendInt(dst, 0x00, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			dst = appendString(dst, f.Name)
+		}
+
+		dst = appendString(dst, f.Value)
+	}
+
+	return dst
+}
+
+// appendInt encodes v with an n-bit prefix, the bits above the prefix are taken from first
+func appendInt(dst []byte, first byte, n uint8, v uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if v < limit {
+		return append(dst, first|byte(v))
+	}
+
+	dst = append(dst, first|byte(limit))
+	v -= limit
+
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(dst, byte(v))
+}
+
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+
+	return append(dst, s...)
+}
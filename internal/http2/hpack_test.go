@@ -0,0 +1,146 @@
+package http2
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestIntegers(t *testing.T) {
+	// RFC 7541 Appendix C.1
+	cases := []struct {
+		prefix  uint8
+		value   uint64
+		encoded string
+	}{
+		{5, 10, "0a"},
+		{5, 1337, "1f9a0a"},
+		{8, 42, "2a"},
+	}
+
+	for _, c := range cases {
+		encoded := appendInt(nil, 0, c.prefix, c.value)
+		assert.Equal(t, c.encoded, hex.EncodeToString(encoded))
+
+		value, rest, err := readInt(encoded, c.prefix)
+		require.NoError(t, err)
+		assert.Equal(t, c.value, value)
+		assert.Empty(t, rest)
+	}
+
+	_, _, err := readInt(unhex(t, "1f9a"), 5)
+	assert.ErrorIs(t, err, ErrCompression)
+}
+
+func TestDecodeRequests(t *testing.T) {
+	// RFC 7541 Appendix C.3 and C.4, the same requests without and with Huffman coding
+	blocks := map[string][]string{
+		"plain": {
+			"828684410f7777772e6578616d706c652e636f6d",
+			"828684be58086e6f2d6361636865",
+			"828785bf400a637573746f6d2d6b65790c637573746f6d2d76616c7565",
+		},
+		"huffman": {
+			"828684418cf1e3c2e5f23a6ba0ab90f4ff",
+			"828684be5886a8eb10649cbf",
+			"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+		},
+	}
+
+	expected := [][]HeaderField{
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}},
+		{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}},
+	}
+
+	for name, hexBlocks := range blocks {
+		t.Run(name, func(t *testing.T) {
+			d := NewDecoder(4096, 0)
+
+			for i, block := range hexBlocks {
+				fields, err := d.Decode(unhex(t, block))
+				require.NoError(t, err)
+				assert.Equal(t, expected[i], fields)
+			}
+
+			assert.Equal(t, []HeaderField{
+				{":authority", "www.example.com"},
+				{"cache-control", "no-cache"},
+				{"custom-key", "custom-value"},
+			}, d.table.entries)
+			assert.Equal(t, uint32(164), d.table.size)
+		})
+	}
+}
+
+func TestDecodeRejectsInvalidBlocks(t *testing.T) {
+	cases := map[string]string{
+		"index 0":                 "80",
+		"index out of range":      "be",
+		"truncated string":        "400a6375",
+		"table size over limit":   "3fe21f",
+		"size update after field": "8220",
+		"huffman padding of 0s":   "418100",
+	}
+
+	for name, block := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewDecoder(4096, 0).Decode(unhex(t, block))
+			assert.ErrorIs(t, err, ErrCompression)
+		})
+	}
+}
+
+func TestDecodeLimitsHeaderListSize(t *testing.T) {
+	block := AppendHeaderBlock(nil, []HeaderField{{"x-large", string(make([]byte, 100))}})
+
+	_, err := NewDecoder(4096, 64).Decode(block)
+	assert.ErrorIs(t, err, ErrCompression)
+}
+
+func TestHuffman(t *testing.T) {
+	assert.Equal(t, "f1e3c2e5f23a6ba0ab90f4ff", hex.EncodeToString(huffmanEncode(nil, "www.example.com")))
+
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	for _, s := range []string{"", "no-cache", "Mon, 21 Oct 2013 20:13:21 GMT", string(all)} {
+		encoded := huffmanEncode(nil, s)
+		assert.Len(t, encoded, huffmanEncodedLen(s))
+
+		decoded, err := huffmanDecode(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, s, decoded)
+	}
+}
+
+func TestAppendHeaderBlock(t *testing.T) {
+	fields := []HeaderField{
+		{":status", "200"},
+		{":status", "418"},
+		{"content-type", "text/plain"},
+		{"x-request-id", "abc"},
+		{"cache-control", "private, max-age=0"},
+	}
+
+	block := AppendHeaderBlock(nil, fields)
+	// fully indexed from the static table
+	assert.Equal(t, byte(0x88), block[0])
+
+	d := NewDecoder(4096, 0)
+	decoded, err := d.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+	assert.Empty(t, d.table.entries)
+}
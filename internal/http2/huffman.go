@@ -0,0 +1,107 @@
+package http2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// huffmanNode is a node of the decoding tree, leaves have no children
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   byte
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{}
+
+	for symbol, code := range huffmanCodes {
+		node := root
+
+		for i := int(huffmanCodeLens[symbol]) - 1; i >= 0; i-- {
+			bit := (code >> i) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+
+			node = node.children[bit]
+		}
+
+		node.symbol = byte(symbol)
+	}
+
+	return root
+}
+
+func (n *huffmanNode) leaf() bool {
+	return n.children[0] == nil && n.children[1] == nil
+}
+
+// huffmanDecode decodes data, which has to end with at most 7 bits of the EOS code as
+// padding, RFC 7541 section 5.2
+func huffmanDecode(data []byte) (string, error) {
+	var sb strings.Builder
+
+	node := huffmanRoot
+	// pending counts the bits read since the last symbol, ones whether all of them were 1
+	pending, ones := 0, true
+
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> i) & 1
+
+			node = node.children[bit]
+			if node == nil {
+				// only EOS leads off the tree
+				return "", fmt.Errorf("%w: invalid Huffman code", ErrCompression)
+			}
+
+			pending++
+			ones = ones && bit == 1
+
+			if node.leaf() {
+				sb.WriteByte(node.symbol)
+				node = huffmanRoot
+				pending, ones = 0, true
+			}
+		}
+	}
+
+	if pending > 7 || !ones {
+		return "", fmt.Errorf("%w: invalid Huffman padding", ErrCompression)
+	}
+
+	return sb.String(), nil
+}
+
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLens[s[i]])
+	}
+
+	return (bits + 7) / 8
+}
+
+// huffmanEncode appends the Huffman code of s, padded with the most significant bits of EOS
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	bits := 0
+
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLens[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += int(huffmanCodeLens[s[i]])
+
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>bits))
+		}
+	}
+
+	if bits > 0 {
+		dst = append(dst, byte(acc<<(8-bits))|byte(0xff>>bits))
+	}
+
+	return dst
+}
@@ -0,0 +1,173 @@
+package multipart
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrMessageTooLarge = errors.New("error: multipart message too large")
+)
+
+// Form is a parsed multipart form, file parts larger than the memory threshold
+// given to ReadForm are stored in temporary files that RemoveAll deletes
+type Form struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+type FileHeader struct {
+	Filename string
+	Headers  *headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// ReadForm reads every part, keeping at most maxMemory bytes of values and file
+// contents in memory. Non-file values beyond maxMemory fail with ErrMessageTooLarge,
+// file contents beyond it are spilled to disk.
+func (r *Reader) ReadForm(maxMemory int64) (*Form, error) {
+	form := &Form{
+		Value: map[string][]string{},
+		File:  map[string][]*FileHeader{},
+	}
+
+	remaining := maxMemory
+
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			var value bytes.Buffer
+
+			n, err := io.CopyN(&value, part, remaining+1)
+			if err != nil && err != io.EOF {
+				form.RemoveAll()
+				return nil, err
+			}
+
+			remaining -= n
+			if remaining < 0 {
+				form.RemoveAll()
+				return nil, ErrMessageTooLarge
+			}
+
+			form.Value[name] = append(form.Value[name], value.String())
+
+			continue
+		}
+
+		file, err := readFilePart(part, &remaining)
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		form.File[name] = append(form.File[name], file)
+	}
+}
+
+func readFilePart(part *Part, remaining *int64) (*FileHeader, error) {
+	file := &FileHeader{
+		Filename: part.FileName(),
+		Headers:  part.Headers,
+	}
+
+	var content bytes.Buffer
+
+	n, err := io.CopyN(&content, part, max(*remaining, 0)+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if n <= *remaining {
+		*remaining -= n
+		file.content = content.Bytes()
+		file.Size = n
+
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, io.MultiReader(&content, part))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	file.tmpfile = tmp.Name()
+	file.Size = size
+
+	return file, nil
+}
+
+func (f *FileHeader) Open() (File, error) {
+	if f.tmpfile != "" {
+		return os.Open(f.tmpfile)
+	}
+
+	return sectionReadCloser{io.NewSectionReader(bytes.NewReader(f.content), 0, int64(len(f.content)))}, nil
+}
+
+// OnDisk reports whether the file was spilled to a temporary file
+func (f *FileHeader) OnDisk() bool {
+	return f.tmpfile != ""
+}
+
+func (f *Form) RemoveAll() error {
+	var errs []error
+
+	for _, files := range f.File {
+		for _, file := range files {
+			if file.tmpfile == "" {
+				continue
+			}
+
+			if err := os.Remove(file.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadCloser) Close() error {
+	return nil
+}
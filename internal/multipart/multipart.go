@@ -0,0 +1,238 @@
+package multipart
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+)
+
+const (
+	bufferSize     = 64 * 1024
+	maxHeaderBytes = 16 * 1024
+)
+
+var (
+	ErrMalformedMultipart = errors.New("error: malformed multipart body")
+	ErrInvalidBoundary    = errors.New("error: invalid multipart boundary")
+)
+
+// Reader iterates over the parts of a multipart body (RFC 2046 section 5.1), each part
+// is streamed from the underlying reader and never buffered as a whole
+type Reader struct {
+	br             *bufio.Reader
+	dashBoundary   []byte
+	nlDashBoundary []byte
+	currentPart    *Part
+	partsRead      int
+	done           bool
+}
+
+type Part struct {
+	Headers *headers.Headers
+
+	r           *Reader
+	done        bool
+	disposition string
+	params      map[string]string
+}
+
+func NewReader(r io.Reader, boundary string) (*Reader, error) {
+	if boundary == "" || len(boundary) > 70 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidBoundary, boundary)
+	}
+
+	return &Reader{
+		br:             bufio.NewReaderSize(r, bufferSize),
+		dashBoundary:   []byte("--" + boundary),
+		nlDashBoundary: []byte("\r\n--" + boundary),
+	}, nil
+}
+
+// NextPart discards whatever is left of the current part and returns the next one,
+// io.EOF is returned after the closing boundary
+func (r *Reader) NextPart() (*Part, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	if r.currentPart != nil {
+		if _, err := io.Copy(io.Discard, r.currentPart); err != nil {
+			return nil, err
+		}
+
+		r.currentPart = nil
+	}
+
+	expectNewPart := false
+
+	for {
+		line, err := r.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull && r.partsRead == 0 {
+			// overly long preamble lines are skipped in pieces
+			continue
+		}
+
+		if err == io.EOF {
+			if r.boundaryLine(line) == boundaryLast {
+				r.done = true
+				return nil, io.EOF
+			}
+
+			return nil, fmt.Errorf("%w: missing closing boundary", ErrMalformedMultipart)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch r.boundaryLine(line) {
+		case boundaryNext:
+			part, err := r.newPart()
+			if err != nil {
+				return nil, err
+			}
+
+			r.partsRead++
+			r.currentPart = part
+
+			return part, nil
+		case boundaryLast:
+			r.done = true
+			return nil, io.EOF
+		}
+
+		if r.partsRead == 0 {
+			continue
+		}
+
+		// the CRLF in front of the boundary belongs to the delimiter, not to the previous part
+		if !expectNewPart && bytes.Equal(line, []byte("\r\n")) {
+			expectNewPart = true
+			continue
+		}
+
+		return nil, fmt.Errorf("%w: unexpected line %q", ErrMalformedMultipart, line)
+	}
+}
+
+type boundaryKind int
+
+const (
+	boundaryNone boundaryKind = iota
+	boundaryNext
+	boundaryLast
+)
+
+func (r *Reader) boundaryLine(line []byte) boundaryKind {
+	rest, found := bytes.CutPrefix(line, r.dashBoundary)
+	if !found {
+		return boundaryNone
+	}
+
+	kind := boundaryNext
+	if next, found := bytes.CutPrefix(rest, []byte("--")); found {
+		kind = boundaryLast
+		rest = next
+	}
+
+	rest = bytes.TrimRight(rest, " \t")
+	if bytes.Equal(rest, []byte("\r\n")) || (kind == boundaryLast && len(rest) == 0) {
+		return kind
+	}
+
+	return boundaryNone
+}
+
+func (r *Reader) newPart() (*Part, error) {
+	var block []byte
+
+	for {
+		line, err := r.br.ReadSlice('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: part headers: %v", ErrMalformedMultipart, err)
+		}
+
+		block = append(block, line...)
+		if len(block) > maxHeaderBytes {
+			return nil, fmt.Errorf("%w: part headers too large", ErrMalformedMultipart)
+		}
+
+		if bytes.Equal(line, []byte("\r\n")) {
+			break
+		}
+	}
+
+	h := headers.NewHeaders()
+	if _, done, err := h.Parse(block); err != nil || !done {
+		return nil, fmt.Errorf("%w: part headers: %v", ErrMalformedMultipart, err)
+	}
+
+	part := &Part{
+		Headers: h,
+		r:       r,
+	}
+
+	if value := h.Get("Content-Disposition"); value != "" {
+		part.disposition, part.params, _ = mime.ParseMediaType(value)
+	}
+
+	return part, nil
+}
+
+// Read returns part data up to the next boundary delimiter
+func (p *Part) Read(d []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+
+	br := p.r.br
+	delimiter := p.r.nlDashBoundary
+
+	_, err := br.Peek(len(delimiter))
+	peek, _ := br.Peek(br.Buffered())
+
+	if i := bytes.Index(peek, delimiter); i >= 0 {
+		if i == 0 {
+			p.done = true
+			return 0, io.EOF
+		}
+
+		n := copy(d, peek[:i])
+		br.Discard(n)
+
+		return n, nil
+	}
+
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return 0, err
+	}
+
+	// hold back a tail that could be the start of the delimiter
+	safe := len(peek) - len(delimiter) + 1
+	n := copy(d, peek[:safe])
+	br.Discard(n)
+
+	return n, nil
+}
+
+// FormName is the name parameter of a form-data Content-Disposition
+func (p *Part) FormName() string {
+	if p.disposition != "form-data" {
+		return ""
+	}
+
+	return p.params["name"]
+}
+
+func (p *Part) FileName() string {
+	return p.params["filename"]
+}
@@ -0,0 +1,156 @@
+package multipart
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBoundary = "X-BOUNDARY"
+
+func testBody(fileContent string) string {
+	return "preamble to ignore\r\n" +
+		"--" + testBoundary + "\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"hello\r\nworld\r\n" +
+		"--" + testBoundary + "\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		fileContent + "\r\n" +
+		"--" + testBoundary + "\r\n" +
+		"Content-Disposition: form-data; name=\"empty\"\r\n" +
+		"\r\n" +
+		"\r\n" +
+		"--" + testBoundary + "--\r\n" +
+		"epilogue"
+}
+
+func TestNextPartStreamsParts(t *testing.T) {
+	reader, err := NewReader(iotest.OneByteReader(strings.NewReader(testBody("file -- data\r\n--X-BOUND"))), testBoundary)
+	require.NoError(t, err)
+
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Equal(t, "", part.FileName())
+
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\r\nworld", string(data))
+
+	part, err = reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName())
+	assert.Equal(t, "notes.txt", part.FileName())
+	assert.Equal(t, "text/plain", part.Headers.Get("Content-Type"))
+
+	data, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "file -- data\r\n--X-BOUND", string(data))
+
+	part, err = reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "empty", part.FormName())
+
+	_, err = reader.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestNextPartSkipsUnreadData(t *testing.T) {
+	reader, err := NewReader(strings.NewReader(testBody("unread")), testBoundary)
+	require.NoError(t, err)
+
+	names := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		names = append(names, part.FormName())
+	}
+
+	assert.Equal(t, []string{"title", "upload", "empty"}, names)
+}
+
+func TestMalformedMultipart(t *testing.T) {
+	reader, err := NewReader(strings.NewReader("--"+testBoundary+"\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nno end"), testBoundary)
+	require.NoError(t, err)
+
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = NewReader(strings.NewReader(""), "")
+	assert.ErrorIs(t, err, ErrInvalidBoundary)
+}
+
+func TestReadFormInMemory(t *testing.T) {
+	reader, err := NewReader(strings.NewReader(testBody("small")), testBoundary)
+	require.NoError(t, err)
+
+	form, err := reader.ReadForm(1024)
+	require.NoError(t, err)
+	defer form.RemoveAll()
+
+	assert.Equal(t, []string{"hello\r\nworld"}, form.Value["title"])
+	assert.Equal(t, []string{""}, form.Value["empty"])
+	require.Len(t, form.File["upload"], 1)
+
+	header := form.File["upload"][0]
+	assert.False(t, header.OnDisk())
+	assert.Equal(t, int64(5), header.Size)
+
+	file, err := header.Open()
+	require.NoError(t, err)
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+}
+
+func TestReadFormSpillsToDisk(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	reader, err := NewReader(strings.NewReader(testBody(content)), testBoundary)
+	require.NoError(t, err)
+
+	form, err := reader.ReadForm(100)
+	require.NoError(t, err)
+
+	header := form.File["upload"][0]
+	assert.True(t, header.OnDisk())
+	assert.Equal(t, int64(len(content)), header.Size)
+
+	file, err := header.Open()
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal([]byte(content), data))
+	file.Close()
+
+	require.NoError(t, form.RemoveAll())
+
+	_, err = header.Open()
+	assert.Error(t, err)
+}
+
+func TestReadFormValueTooLarge(t *testing.T) {
+	reader, err := NewReader(strings.NewReader(testBody("small")), testBoundary)
+	require.NoError(t, err)
+
+	_, err = reader.ReadForm(4)
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+}
@@ -0,0 +1,229 @@
+package nethttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// result is what a response looks like on the wire, stripped of the fields that differ
+// between servers like Date and Server
+type result struct {
+	Status        response.StatusCode
+	ContentType   string
+	ContentLength string
+	Chunked       bool
+	Location      string
+	SetCookie     []string
+	Body          string
+	Trailers      headers.Headers
+}
+
+func serveNative(t *testing.T, h server.Handler) string {
+	srv, err := server.Serve(0, h)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return srv.Listener.Addr().String()
+}
+
+func serveNetHTTP(t *testing.T, h http.Handler) string {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return srv.Listener.Addr().String()
+}
+
+// exchange sends raw to addr and parses the response with the client's parser
+func exchange(t *testing.T, addr, method, raw string) result {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	resp, err := client.ReadResponse(bufio.NewReader(conn), method)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return result{
+		Status:        resp.StatusCode,
+		ContentType:   resp.Headers.Get("Content-Type"),
+		ContentLength: resp.Headers.Get("Content-Length"),
+		Chunked:       resp.Headers.Get("Transfer-Encoding") == "chunked",
+		Location:      resp.Headers.Get("Location"),
+		SetCookie:     resp.SetCookie,
+		Body:          string(body),
+		Trailers:      resp.Trailers,
+	}
+}
+
+type conformanceCase struct {
+	name   string
+	method string
+	raw    string
+}
+
+func get(name, target string) conformanceCase {
+	return conformanceCase{name, "GET", "GET " + target + " HTTP/1.1\r\nHost: conformance.test\r\nConnection: close\r\n\r\n"}
+}
+
+// TestFromHTTPConformance serves the same net/http handler with net/http and through
+// FromHTTP and expects the same responses
+func TestFromHTTPConformance(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first ")
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, "second")
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 10000))
+	})
+	mux.HandleFunc("/length", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10000")
+		fmt.Fprint(w, strings.Repeat("y", 10000))
+	})
+	mux.HandleFunc("/trailers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		fmt.Fprint(w, "body")
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "def")
+	})
+	mux.HandleFunc("/cookies", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+		http.SetCookie(w, &http.Cookie{Name: "b", Value: "2"})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/text", http.StatusFound)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s %q %s", r.Method, r.Host, r.URL.Path, r.URL.Query().Get("q"), r.Header.Get("X-Test"), body)
+	})
+
+	native := serveNetHTTP(t, mux)
+	adapted := serveNative(t, FromHTTP(mux))
+
+	cases := []conformanceCase{
+		get("sniffed content type", "/text"),
+		get("explicit status and type", "/json"),
+		get("flushed body", "/flush"),
+		get("large body", "/large"),
+		get("handler content length", "/length"),
+		get("trailers", "/trailers"),
+		get("set-cookie", "/cookies"),
+		get("redirect", "/redirect"),
+		get("not found", "/missing"),
+		{"request body", "POST", "POST /echo?q=search HTTP/1.1\r\nHost: conformance.test\r\nX-Test: value\r\nContent-Length: 7\r\nConnection: close\r\n\r\npayload"},
+		{"chunked request body", "POST", "POST /echo HTTP/1.1\r\nHost: conformance.test\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n3\r\nhel\r\n2\r\nlo\r\n0\r\n\r\n"},
+		{"head", "HEAD", "HEAD /length HTTP/1.1\r\nHost: conformance.test\r\nConnection: close\r\n\r\n"},
+		{"patch", "PATCH", "PATCH /echo HTTP/1.1\r\nHost: conformance.test\r\nContent-Length: 7\r\nConnection: close\r\n\r\npayload"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := exchange(t, native, tc.method, tc.raw)
+			got := exchange(t, adapted, tc.method, tc.raw)
+
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+// TestToHTTPConformance serves the same handler with this server and through ToHTTP on
+// net/http. Framing is net/http's business so only the content is compared.
+func TestToHTTPConformance(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		switch req.RequestLine.RequestTarget {
+		case "/text":
+			body := []byte("hello")
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+			w.Write(body)
+		case "/unframed":
+			w.WriteStatusLine(response.OK)
+			w.Write([]byte("<p>until close</p>"))
+		case "/chunked":
+			h := response.GetDefaultHeaders(0, "text/plain")
+			h.Delete("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Trailer", "X-Checksum")
+
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("one "))
+			w.WriteChunkedBody([]byte("two"))
+			w.WriteTrailers(headers.Headers{"x-checksum": "abc"})
+		case "/cookies":
+			w.WriteStatusLine(response.OK)
+			w.AddHeader("Set-Cookie", "a=1")
+			w.AddHeader("Set-Cookie", "b=2")
+			w.WriteHeaders(response.GetDefaultHeaders(0, "text/plain"))
+		case "/echo":
+			body := fmt.Sprintf("%s %s %s %q %s", req.RequestLine.Method, req.Headers.Get("Host"), req.RequestLine.RequestTarget, req.Headers.Get("X-Test"), req.Body)
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+			w.Write([]byte(body))
+		default:
+			return &server.HandlerError{Status: response.NotFound, Message: "Nothing here"}
+		}
+
+		return nil
+	}
+
+	native := serveNative(t, handler)
+	adapted := serveNetHTTP(t, ToHTTP(handler))
+
+	cases := []conformanceCase{
+		get("content length", "/text"),
+		get("close delimited", "/unframed"),
+		get("chunked with trailers", "/chunked"),
+		get("set-cookie", "/cookies"),
+		get("handler error", "/missing"),
+		{"request body", "POST", "POST /echo HTTP/1.1\r\nHost: conformance.test\r\nX-Test: value\r\nContent-Length: 7\r\nConnection: close\r\n\r\npayload"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := exchange(t, native, tc.method, tc.raw)
+			got := exchange(t, adapted, tc.method, tc.raw)
+
+			want.ContentLength, got.ContentLength = "", ""
+			want.Chunked, got.Chunked = false, false
+
+			assert.Equal(t, want, got)
+		})
+	}
+}
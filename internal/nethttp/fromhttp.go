@@ -0,0 +1,327 @@
+// Package nethttp adapts net/http handlers to this server and the other way around
+package nethttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+)
+
+const (
+	// bufferBeforeStreaming is how much of a body is held back so small responses can be
+	// sent with a Content-Length instead of chunked, the same amount as net/http
+	bufferBeforeStreaming = 2 << 10
+)
+
+// FromHTTP mounts h on this server. Responses are buffered like net/http does, so small
+// ones get a Content-Length and larger or flushed ones are sent chunked unless h set a
+// Content-Length itself. The ResponseWriter implements http.Flusher and http.Hijacker,
+// and the request context is cancelled once h returns.
+func FromHTTP(h http.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r, err := newHTTPRequest(ctx, req)
+		if err != nil {
+			return &server.HandlerError{Message: "Invalid request target", Status: response.BadRequest, Cause: err}
+		}
+
+		rw := &responseWriter{w: w, req: r, header: http.Header{}}
+		h.ServeHTTP(rw, r)
+
+		if w.Hijacked() {
+			return nil
+		}
+
+		if err := rw.finish(); err != nil {
+			return &server.HandlerError{Status: response.InternalServerError, Internal: "finishing net/http response", Cause: err}
+		}
+
+		return nil
+	}
+}
+
+func newHTTPRequest(ctx context.Context, req *request.Request) (*http.Request, error) {
+	target := req.RequestLine.RequestTarget
+
+	var u *url.URL
+	var err error
+
+	if req.RequestLine.Method == "CONNECT" {
+		u = &url.URL{Host: target}
+	} else if u, err = url.ParseRequestURI(target); err != nil {
+		return nil, err
+	}
+
+	major, minor, ok := http.ParseHTTPVersion(req.RequestLine.HttpVersion)
+	if !ok {
+		major, minor = 1, 1
+	}
+
+	r := &http.Request{
+		Method:     req.RequestLine.Method,
+		URL:        u,
+		Proto:      fmt.Sprintf("HTTP/%d.%d", major, minor),
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Host:       req.Headers.Get("Host"),
+		RemoteAddr: req.RemoteAddr,
+		RequestURI: target,
+		TLS:        req.TLS,
+		// the server closes every connection after its response
+		Close: true,
+	}
+
+	for k, v := range *req.Headers {
+		if k != "host" && k != "transfer-encoding" {
+			r.Header.Set(k, v)
+		}
+	}
+
+	// the parser has decoded a chunked body already, net/http reports it as unknown length
+	if req.Headers.Exists("Transfer-Encoding") {
+		r.TransferEncoding = []string{"chunked"}
+		r.ContentLength = -1
+		r.Body = io.NopCloser(req.BodyReader())
+
+		return r.WithContext(ctx), nil
+	}
+
+	if value := req.Headers.Get("Content-Length"); value != "" {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		r.ContentLength = length
+		if length > 0 {
+			r.Body = io.NopCloser(req.BodyReader())
+		}
+	}
+
+	return r.WithContext(ctx), nil
+}
+
+// responseWriter is the http.ResponseWriter handed to net/http handlers
+type responseWriter struct {
+	w   *response.Writer
+	req *http.Request
+
+	header      http.Header
+	sent        http.Header
+	status      int
+	wroteHeader bool
+
+	buf       bytes.Buffer
+	written   int64
+	streaming bool
+	chunked   bool
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.header
+}
+
+// WriteHeader takes a snapshot of the headers like net/http, later changes only count
+// for trailers. Informational responses other than 101 are dropped, the Writer has no
+// way to send them.
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		fmt.Println("Warning: superfluous WriteHeader call with status", code)
+		return
+	}
+
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		return
+	}
+
+	rw.status = code
+	rw.sent = rw.header.Clone()
+	rw.wroteHeader = true
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		if rw.header.Get("Content-Type") == "" && rw.header.Get("Transfer-Encoding") == "" && len(p) > 0 {
+			rw.header.Set("Content-Type", http.DetectContentType(p))
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !bodyAllowed(rw.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+
+	rw.written += int64(len(p))
+
+	// HEAD responses count the body for their Content-Length but do not send it
+	if rw.req.Method == "HEAD" {
+		return len(p), nil
+	}
+
+	if !rw.streaming {
+		rw.buf.Write(p)
+
+		if rw.buf.Len() > bufferBeforeStreaming {
+			if err := rw.commit(false); err != nil {
+				return 0, err
+			}
+
+			return len(p), nil
+		}
+
+		return len(p), nil
+	}
+
+	if rw.chunked {
+		rw.w.WriteChunkedBody(p)
+	} else {
+		rw.w.Write(p)
+	}
+
+	if err := rw.w.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (rw *responseWriter) Flush() {
+	rw.FlushError()
+}
+
+// FlushError is used by http.ResponseController
+func (rw *responseWriter) FlushError() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !rw.streaming {
+		if err := rw.commit(false); err != nil {
+			return err
+		}
+	}
+
+	return rw.w.Flush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return rw.w.Hijack()
+}
+
+// commit writes the status line, headers and whatever body is buffered. Once the handler
+// is done the buffered body is all there is, so it gets a Content-Length.
+func (rw *responseWriter) commit(final bool) error {
+	rw.streaming = true
+
+	rw.w.WriteStatusLine(response.StatusCode(rw.status))
+
+	for k, values := range rw.sent {
+		if len(values) > 1 && k == "Set-Cookie" {
+			for _, v := range values {
+				rw.w.AddHeader(k, v)
+			}
+
+			continue
+		}
+
+		rw.w.SetHeader(k, strings.Join(values, ", "))
+	}
+
+	rw.w.Headers.Delete("Transfer-Encoding")
+
+	switch {
+	case rw.sent.Get("Content-Length") != "" || !bodyAllowed(rw.status):
+	case final && len(rw.trailers()) == 0:
+		rw.w.SetHeader("Content-Length", strconv.FormatInt(rw.written, 10))
+	case rw.req.Method != "HEAD":
+		rw.chunked = true
+		rw.w.SetHeader("Transfer-Encoding", "chunked")
+	}
+
+	if err := rw.w.WriteHeaders(rw.w.Headers); err != nil {
+		return err
+	}
+
+	if rw.buf.Len() == 0 {
+		return nil
+	}
+
+	if rw.chunked {
+		rw.w.WriteChunkedBody(rw.buf.Bytes())
+	} else {
+		rw.w.Write(rw.buf.Bytes())
+	}
+
+	rw.buf.Reset()
+
+	return nil
+}
+
+// finish sends what is still buffered once the handler returned and ends a chunked body
+// with the trailers the handler announced or set with http.TrailerPrefix
+func (rw *responseWriter) finish() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !rw.streaming {
+		if err := rw.commit(true); err != nil {
+			return err
+		}
+	}
+
+	if !rw.chunked {
+		return nil
+	}
+
+	if trailers := rw.trailers(); len(trailers) > 0 {
+		return rw.w.WriteTrailers(trailers)
+	}
+
+	_, err := rw.w.WriteChunkedBodyDone()
+
+	return err
+}
+
+func (rw *responseWriter) trailers() headers.Headers {
+	trailers := headers.Headers{}
+
+	for _, declared := range rw.sent.Values("Trailer") {
+		for k := range strings.SplitSeq(declared, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if v := rw.header.Values(k); len(v) > 0 {
+				trailers[k] = strings.Join(v, ", ")
+			}
+		}
+	}
+
+	for k, v := range rw.header {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			trailers[name] = strings.Join(v, ", ")
+		}
+	}
+
+	return trailers
+}
+
+// bodyAllowed reports whether status may have content, RFC 9110 section 6.4.1
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
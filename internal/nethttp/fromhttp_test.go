@@ -0,0 +1,165 @@
+package nethttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dial(t *testing.T, addr, raw string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	return conn, bufio.NewReader(conn)
+}
+
+func TestFromHTTPStreamsFlushedWrites(t *testing.T) {
+	release := make(chan struct{})
+
+	addr := serveNative(t, FromHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first")
+		require.NoError(t, http.NewResponseController(w).Flush())
+
+		<-release
+		fmt.Fprint(w, "second")
+	})))
+
+	_, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+
+	// the first write arrives while the handler is still blocked
+	buf := make([]byte, 5)
+	_, err = resp.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+
+	close(release)
+
+	n, _ := resp.Body.Read(buf)
+	assert.Equal(t, "secon", string(buf[:n]))
+}
+
+func TestFromHTTPCancelsContextAfterHandler(t *testing.T) {
+	done := make(chan (<-chan struct{}), 1)
+
+	addr := serveNative(t, FromHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.Context().Err())
+		done <- r.Context().Done()
+	})))
+
+	_, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	_, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+
+	select {
+	case <-<-done:
+	case <-time.After(time.Second):
+		t.Fatal("request context was not cancelled")
+	}
+}
+
+func TestFromHTTPHijack(t *testing.T) {
+	addr := serveNative(t, FromHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo: " + line)
+		brw.Flush()
+	})))
+
+	conn, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 101, int(resp.StatusCode))
+
+	_, err = conn.Write([]byte("ping\n"))
+	require.NoError(t, err)
+
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: ping\n", line)
+}
+
+func TestFromHTTPInvalidTarget(t *testing.T) {
+	addr := serveNative(t, FromHTTP(http.NotFoundHandler()))
+
+	_, br := dial(t, addr, "GET not-a-path HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 400, int(resp.StatusCode))
+}
+
+func TestFromHTTPProtoMatchesParser(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	r, err := newHTTPRequest(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, req.RequestLine.HttpVersion, r.Proto)
+	assert.Equal(t, 1, r.ProtoMajor)
+	assert.Equal(t, 1, r.ProtoMinor)
+
+	// what the server hands over for h2c streams
+	req.RequestLine.HttpVersion = "HTTP/2.0"
+
+	r, err = newHTTPRequest(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0", r.Proto)
+	assert.Equal(t, 2, r.ProtoMajor)
+	assert.Equal(t, 0, r.ProtoMinor)
+}
+
+func TestFromHTTPStreamsChunkedRequestBody(t *testing.T) {
+	srv, err := server.Serve(0, FromHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(-1), r.ContentLength)
+		assert.Equal(t, []string{"chunked"}, r.TransferEncoding)
+
+		fmt.Fprintf(w, "got=%s", body)
+	})), server.WithStreamingBodies())
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	_, br := dial(t, srv.Listener.Addr().String(), "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "POST")
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "got=hello", string(body))
+}
@@ -0,0 +1,280 @@
+package nethttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+)
+
+var errHijacked = errors.New("error: connection hijacked before a response was written")
+
+// hopHeaders are left to net/http, which frames the response and manages the connection
+var hopHeaders = []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Te", "Upgrade", "Proxy-Connection"}
+
+// ToHTTP mounts h on a net/http server. What h writes is parsed back with the client's
+// response parser and replayed on the http.ResponseWriter as it arrives, so flushed
+// bodies stream. CloseNotify follows the request context and Hijack is supported.
+func ToHTTP(h server.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req, err := newRequest(r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pr, pw := io.Pipe()
+		conn := &pipeConn{pw: pw}
+
+		relay := &relay{rw: rw, method: r.Method, done: make(chan struct{})}
+		go relay.run(pr)
+
+		w := response.NewConnWriter(conn)
+		w.SetCloseNotify(func() <-chan struct{} {
+			return r.Context().Done()
+		})
+		w.SetHijacker(func() (net.Conn, *bufio.ReadWriter, error) {
+			return relay.hijack(conn)
+		})
+
+		handlerErr := h(w, req)
+
+		switch {
+		case w.Hijacked():
+			if handlerErr != nil {
+				fmt.Println("error after connection was hijacked:", handlerErr)
+			}
+
+			return
+		case handlerErr != nil && w.Committed():
+			fmt.Println("error after response was sent:", handlerErr)
+		case handlerErr != nil:
+			fmt.Println("error:", handlerErr)
+			server.WriteHandlerError(conn, handlerErr)
+		default:
+			if w.State < 2 {
+				w.WriteHeaders(response.GetDefaultHeaders(len(w.Body), "text/html"))
+			}
+
+			if err := w.Flush(); err != nil {
+				fmt.Println("error:", err)
+			}
+		}
+
+		pw.Close()
+		<-relay.done
+	})
+}
+
+// newRequest reads the whole body like the server does without WithStreamingBodies
+func newRequest(r *http.Request) (*request.Request, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        r.Method,
+			RequestTarget: r.RequestURI,
+			HttpVersion:   r.Proto,
+		},
+		Headers:    headers.NewHeaders(),
+		RemoteAddr: r.RemoteAddr,
+		TLS:        r.TLS,
+		Status:     request.RequestStateDone,
+	}
+
+	if req.RequestLine.RequestTarget == "" {
+		req.RequestLine.RequestTarget = r.URL.RequestURI()
+	}
+
+	for k, values := range r.Header {
+		separator := ", "
+		if k == "Cookie" {
+			separator = "; "
+		}
+
+		req.Headers.Set(k, strings.Join(values, separator))
+	}
+
+	req.Headers.Set("Host", r.Host)
+	req.Headers.Delete("Transfer-Encoding")
+
+	if len(body) > 0 || req.Headers.Exists("Content-Length") {
+		req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+		req.Body = body
+	}
+
+	return req, nil
+}
+
+// pipeConn is the connection of the response.Writer, it remembers whether anything was
+// written to it
+type pipeConn struct {
+	pw    *io.PipeWriter
+	wrote bool
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		c.wrote = true
+	}
+
+	return c.pw.Write(p)
+}
+
+// relay parses the handler's output and replays it on rw
+type relay struct {
+	rw     http.ResponseWriter
+	method string
+	// done is closed once the relay stopped, it guards the fields below
+	done chan struct{}
+
+	upgraded  bool
+	conn      net.Conn
+	brw       *bufio.ReadWriter
+	hijackErr error
+}
+
+func (r *relay) run(pr *io.PipeReader) {
+	defer close(r.done)
+
+	br := bufio.NewReader(pr)
+
+	if err := r.copy(br); err != nil && !errors.Is(err, errHijacked) && !errors.Is(err, io.EOF) {
+		fmt.Println("error relaying response to net/http:", err)
+	}
+
+	if !r.upgraded {
+		// whatever follows a complete response is dropped, the handler must not block on it
+		io.Copy(io.Discard, pr)
+	}
+}
+
+func (r *relay) copy(br *bufio.Reader) error {
+	resp, err := client.ReadResponse(br, r.method)
+	if err != nil {
+		return err
+	}
+
+	for _, interim := range resp.Interim {
+		copyHeaders(r.rw.Header(), interim)
+		r.rw.WriteHeader(int(interim.StatusCode))
+	}
+
+	if resp.StatusCode == response.SwitchingProtocols {
+		return r.upgrade(resp)
+	}
+
+	copyHeaders(r.rw.Header(), resp)
+
+	if resp.Headers.Exists("Trailer") {
+		r.rw.Header().Set("Trailer", resp.Headers.Get("Trailer"))
+	}
+
+	// a nil Content-Type keeps net/http from sniffing one the handler did not send
+	if !resp.Headers.Exists("Content-Type") {
+		r.rw.Header()["Content-Type"] = nil
+	}
+
+	r.rw.WriteHeader(int(resp.StatusCode))
+
+	controller := http.NewResponseController(r.rw)
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := r.rw.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			controller.Flush()
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, v := range resp.Trailers {
+		r.rw.Header().Set(k, v)
+	}
+
+	return nil
+}
+
+// upgrade takes the connection away from net/http and writes the 101 response on it
+// raw, net/http would treat it as an informational response
+func (r *relay) upgrade(resp *client.Response) error {
+	conn, brw, err := http.NewResponseController(r.rw).Hijack()
+
+	r.upgraded = true
+	r.conn, r.brw, r.hijackErr = conn, brw, err
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 %s\r\n", response.StatusText(resp.StatusCode))
+
+	for k, v := range resp.Headers {
+		fmt.Fprintf(brw, "%s: %s\r\n", http.CanonicalHeaderKey(k), v)
+	}
+
+	brw.WriteString("\r\n")
+
+	return brw.Flush()
+}
+
+// hijack runs on the handler's goroutine after the Writer flushed, so the relay has either
+// seen a 101 and hijacked already, or has to be stopped before hijacking here
+func (r *relay) hijack(conn *pipeConn) (net.Conn, *bufio.ReadWriter, error) {
+	if conn.wrote {
+		conn.pw.Close()
+	} else {
+		conn.pw.CloseWithError(errHijacked)
+	}
+
+	<-r.done
+
+	if r.upgraded {
+		return r.conn, r.brw, r.hijackErr
+	}
+
+	return http.NewResponseController(r.rw).Hijack()
+}
+
+// copyHeaders sets the end-to-end fields of resp on h
+func copyHeaders(h http.Header, resp *client.Response) {
+	for k, v := range resp.Headers {
+		h.Set(k, v)
+	}
+
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+
+	if resp.Headers.Get("Transfer-Encoding") != "" {
+		h.Del("Content-Length")
+	}
+
+	for _, v := range resp.SetCookie {
+		h.Add("Set-Cookie", v)
+	}
+}
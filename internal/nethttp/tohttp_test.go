@@ -0,0 +1,155 @@
+package nethttp
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkedHeaders() headers.Headers {
+	h := response.GetDefaultHeaders(0, "text/plain")
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+
+	return h
+}
+
+func TestToHTTPStreamsChunks(t *testing.T) {
+	release := make(chan struct{})
+
+	addr := serveNetHTTP(t, ToHTTP(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(chunkedHeaders())
+		w.WriteChunkedBody([]byte("first"))
+		if err := w.Flush(); err != nil {
+			return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+
+		<-release
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteChunkedBodyDone()
+
+		return nil
+	}))
+
+	_, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+
+	buf := make([]byte, 5)
+	_, err = resp.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+
+	close(release)
+}
+
+func TestToHTTPCloseNotifyFollowsContext(t *testing.T) {
+	gone := make(chan struct{})
+
+	addr := serveNetHTTP(t, ToHTTP(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		<-w.CloseNotify()
+		close(gone)
+
+		return nil
+	}))
+
+	conn, _ := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	conn.Close()
+
+	<-gone
+}
+
+func TestToHTTPHijack(t *testing.T) {
+	addr := serveNetHTTP(t, ToHTTP(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, brw, err := w.Hijack()
+		if err != nil {
+			return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nraw\n")
+		brw.Flush()
+
+		return nil
+	}))
+
+	_, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+}
+
+func TestToHTTPUpgrade(t *testing.T) {
+	addr := serveNetHTTP(t, ToHTTP(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.SwitchingProtocols)
+		w.WriteHeaders(headers.Headers{"upgrade": "echo", "connection": "Upgrade"})
+
+		conn, brw, err := w.Hijack()
+		if err != nil {
+			return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+		defer conn.Close()
+
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo: " + line)
+		brw.Flush()
+
+		return nil
+	}))
+
+	conn, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, int(resp.StatusCode))
+	assert.Equal(t, "echo", resp.Headers.Get("Upgrade"))
+
+	_, err = conn.Write([]byte("ping\n"))
+	require.NoError(t, err)
+
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: ping\n", line)
+}
+
+func TestToHTTPVersionMatchesParser(t *testing.T) {
+	raw := "GET /path?q=1 HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	parsed, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	require.NoError(t, err)
+
+	req, err := newRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, parsed.RequestLine, req.RequestLine)
+}
+
+func TestToHTTPHandlerErrorHeaders(t *testing.T) {
+	addr := serveNetHTTP(t, ToHTTP(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{Status: response.NotFound, Message: "Nothing here"}
+	}))
+
+	_, br := dial(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	resp, err := client.ReadResponse(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, int(resp.StatusCode))
+	assert.Equal(t, server.DefaultServerName, resp.Headers.Get("Server"))
+
+	_, err = headers.ParseTime(resp.Headers.Get("Date"))
+	assert.NoError(t, err)
+}
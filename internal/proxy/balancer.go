@@ -0,0 +1,426 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+)
+
+const (
+	DefaultHealthCheckTimeout = 2 * time.Second
+)
+
+var errNoBackend = errors.New("error: no upstream available")
+
+// idempotentMethods can be sent to another backend after a failed attempt, RFC 9110
+// section 9.2.2
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+
+// Backend is one upstream of a Pool
+type Backend struct {
+	URL *url.URL
+
+	active       atomic.Int64
+	failures     atomic.Int64
+	unhealthy    atomic.Bool
+	ejectedUntil atomic.Int64
+}
+
+// Active is the number of requests the backend is currently serving
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+// Healthy reports the result of the last active health check
+func (b *Backend) Healthy() bool {
+	return !b.unhealthy.Load()
+}
+
+// Available reports whether the backend is healthy and not ejected after failures
+func (b *Backend) Available() bool {
+	return b.Healthy() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+// Balancer picks the backend for a request
+type Balancer interface {
+	// Pick chooses one of candidates, which is never empty
+	Pick(candidates []*Backend, req *request.Request) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (r *roundRobin) Pick(candidates []*Backend, req *request.Request) *Backend {
+	n := r.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+type leastConnections struct{}
+
+// LeastConnections picks the backend with the fewest requests in flight, ties go to the
+// first one
+func LeastConnections() Balancer {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(candidates []*Backend, req *request.Request) *Backend {
+	best := candidates[0]
+
+	for _, b := range candidates[1:] {
+		if b.Active() < best.Active() {
+			best = b
+		}
+	}
+
+	return best
+}
+
+type consistentHash struct {
+	key func(req *request.Request) string
+}
+
+// HashByHeader sends requests with the same value of header to the same backend. It uses
+// rendezvous hashing, so a backend going away only moves the keys that were on it.
+func HashByHeader(header string) Balancer {
+	return consistentHash{
+		key: func(req *request.Request) string {
+			return req.Headers.Get(header)
+		},
+	}
+}
+
+// HashByClientIP keeps every client on the same backend
+func HashByClientIP() Balancer {
+	return consistentHash{
+		key: func(req *request.Request) string {
+			if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+				return host
+			}
+
+			return req.RemoteAddr
+		},
+	}
+}
+
+func (c consistentHash) Pick(candidates []*Backend, req *request.Request) *Backend {
+	key := c.key(req)
+
+	var best *Backend
+	var bestScore uint64
+
+	for _, b := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.URL.String()))
+
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+
+	return best
+}
+
+// Pool is a set of backends with health checking, see LoadBalancedProxy
+type Pool struct {
+	backends []*Backend
+	balancer Balancer
+
+	healthPath     string
+	healthInterval time.Duration
+	healthClient   *client.Client
+
+	maxFailures int64
+	ejectFor    time.Duration
+	retries     int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type PoolOption func(*Pool)
+
+// WithBalancer replaces RoundRobin
+func WithBalancer(balancer Balancer) PoolOption {
+	return func(p *Pool) {
+		p.balancer = balancer
+	}
+}
+
+// WithHealthCheck requests path on every backend each interval, backends answering with
+// anything but 2xx or 3xx receive no traffic until a later check passes
+func WithHealthCheck(path string, interval time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.healthPath = path
+		p.healthInterval = interval
+	}
+}
+
+// WithPassiveEjection takes a backend out of rotation for cooldown after maxFailures
+// consecutive failed requests, a failure being an error reaching it or a 502, 503 or 504
+func WithPassiveEjection(maxFailures int, cooldown time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxFailures = int64(maxFailures)
+		p.ejectFor = cooldown
+	}
+}
+
+// WithRetries sends idempotent requests to up to n other backends when a backend cannot
+// be reached. Requests with a streamed body are never retried.
+func WithRetries(n int) PoolOption {
+	return func(p *Pool) {
+		p.retries = n
+	}
+}
+
+// NewPool starts health checks right away when they are configured, Close stops them
+func NewPool(targets []*url.URL, options ...PoolOption) *Pool {
+	p := &Pool{
+		balancer: RoundRobin(),
+		healthClient: client.New(
+			client.WithDialTimeout(DefaultHealthCheckTimeout),
+			client.WithResponseHeaderTimeout(DefaultHealthCheckTimeout),
+		),
+		stop: make(chan struct{}),
+	}
+
+	for _, target := range targets {
+		p.backends = append(p.backends, &Backend{URL: target})
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	if p.healthPath != "" && p.healthInterval > 0 {
+		go p.healthChecks()
+	}
+
+	return p
+}
+
+func (p *Pool) Backends() []*Backend {
+	return p.backends
+}
+
+func (p *Pool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// pick returns an available backend that was not tried yet, nil when there is none
+func (p *Pool) pick(req *request.Request, tried []*Backend) *Backend {
+	var candidates []*Backend
+
+	for _, b := range p.backends {
+		if b.Available() && !slices.Contains(tried, b) {
+			candidates = append(candidates, b)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return p.balancer.Pick(candidates, req)
+}
+
+func (p *Pool) reportFailure(b *Backend) {
+	if p.maxFailures <= 0 {
+		return
+	}
+
+	if b.failures.Add(1) >= p.maxFailures {
+		b.failures.Store(0)
+		b.ejectedUntil.Store(time.Now().Add(p.ejectFor).UnixNano())
+
+		fmt.Printf("backend %s ejected for %s\n", b.URL, p.ejectFor)
+	}
+}
+
+func (p *Pool) reportSuccess(b *Backend) {
+	b.failures.Store(0)
+}
+
+func (p *Pool) healthChecks() {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		p.checkAll()
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+
+	for _, b := range p.backends {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			healthy := p.check(b)
+			if b.unhealthy.Swap(!healthy) == healthy {
+				fmt.Printf("backend %s healthy: %t\n", b.URL, healthy)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (p *Pool) check(b *Backend) bool {
+	resp, err := p.healthClient.Get(b.URL.JoinPath(p.healthPath).String())
+	if err != nil {
+		return false
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+func (p *reverseProxy) serveBalanced(w *response.Writer, req *request.Request) *server.HandlerError {
+	// only the headers of this request are used, every attempt builds its own for the
+	// backend it goes to
+	outReq, err := p.outgoingRequest(req, &url.URL{})
+	if err != nil {
+		return &server.HandlerError{Message: "Invalid request target", Status: response.BadRequest, Cause: err}
+	}
+
+	resp, err := p.roundTrip(req, outReq, func(out *client.Request) (*client.Response, error) {
+		return p.fetchBalanced(req, out.Headers)
+	})
+	if errors.Is(err, errNoBackend) {
+		return &server.HandlerError{Message: "No upstream available", Status: response.ServiceUnavailable}
+	}
+
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	defer resp.Body.Close()
+
+	return writeResponse(w, req, resp)
+}
+
+// fetchBalanced sends req with the outgoing headers h to a backend of the pool, trying
+// other backends when a replayable request cannot reach one
+func (p *reverseProxy) fetchBalanced(req *request.Request, h headers.Headers) (*client.Response, error) {
+	attempts := 1
+	if p.replayable(req) {
+		attempts += p.pool.retries
+	}
+
+	var tried []*Backend
+	var lastErr error
+
+	for range attempts {
+		b := p.pool.pick(req, tried)
+		if b == nil {
+			break
+		}
+
+		tried = append(tried, b)
+
+		outReq, err := p.outgoingRequest(req, b.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		outReq.Headers = h
+
+		b.active.Add(1)
+
+		resp, err := p.client.Do(outReq)
+		if err != nil {
+			b.active.Add(-1)
+			p.pool.reportFailure(b)
+
+			lastErr = err
+			continue
+		}
+
+		switch resp.StatusCode {
+		case response.BadGateway, response.ServiceUnavailable, response.GatewayTimeout:
+			p.pool.reportFailure(b)
+		default:
+			p.pool.reportSuccess(b)
+		}
+
+		resp.Body = &activeBody{ReadCloser: resp.Body, backend: b}
+
+		return resp, nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, errNoBackend
+}
+
+// activeBody counts its request as active on the backend until the body is closed
+type activeBody struct {
+	io.ReadCloser
+	backend *Backend
+	once    sync.Once
+}
+
+func (a *activeBody) Close() error {
+	a.once.Do(func() {
+		a.backend.active.Add(-1)
+	})
+
+	return a.ReadCloser.Close()
+}
+
+// replayable reports whether the request can be sent again, which needs an idempotent
+// method and a body that is either empty or buffered
+func (p *reverseProxy) replayable(req *request.Request) bool {
+	if !slices.Contains(idempotentMethods, req.RequestLine.Method) {
+		return false
+	}
+
+	if req.Body != nil {
+		return true
+	}
+
+	// a streamed chunked body has no Content-Length and is gone once it was read
+	if req.Headers.Exists("Transfer-Encoding") {
+		return false
+	}
+
+	length, _ := strconv.ParseInt(req.Headers.Get("Content-Length"), 10, 64)
+
+	return length == 0
+}
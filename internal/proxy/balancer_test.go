@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNamedBackend answers every request with its name, /health answers with healthy
+func startNamedBackend(t *testing.T, name string, healthy *atomic.Bool) *url.URL {
+	srv := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if req.RequestLine.RequestTarget == "/health" && healthy != nil && !healthy.Load() {
+			return &server.HandlerError{Message: "unhealthy", Status: response.ServiceUnavailable}
+		}
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(name), "text/plain"))
+		w.Write([]byte(name))
+
+		return nil
+	})
+
+	return serverURL(t, srv, "")
+}
+
+func closedBackend(t *testing.T) *url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	u, err := url.Parse("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	listener.Close()
+
+	return u
+}
+
+func startBalancer(t *testing.T, targets []*url.URL, options ...PoolOption) (*server.Server, *Pool) {
+	pool := NewPool(targets, options...)
+	t.Cleanup(pool.Close)
+
+	return startTestServer(t, LoadBalancedProxy(pool, WithTimeouts(time.Second, time.Second))), pool
+}
+
+func bodyOf(out string) string {
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	return body
+}
+
+func TestRoundRobinSpreadsRequests(t *testing.T) {
+	targets := []*url.URL{
+		startNamedBackend(t, "a", nil),
+		startNamedBackend(t, "b", nil),
+		startNamedBackend(t, "c", nil),
+	}
+
+	proxy, _ := startBalancer(t, targets)
+
+	counts := map[string]int{}
+	for range 6 {
+		counts[bodyOf(roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))]++
+	}
+
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, counts)
+}
+
+func TestLeastConnectionsPicksIdlestBackend(t *testing.T) {
+	backends := []*Backend{{}, {}, {}}
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+
+	assert.Same(t, backends[1], LeastConnections().Pick(backends, nil))
+}
+
+func TestConsistentHashIsStable(t *testing.T) {
+	var backends []*Backend
+	for i := range 4 {
+		backends = append(backends, &Backend{URL: &url.URL{Scheme: "http", Host: "backend" + strconv.Itoa(i)}})
+	}
+
+	balancer := HashByHeader("X-User")
+	pick := func(candidates []*Backend, user string) *Backend {
+		h := headers.NewHeaders()
+		h.Set("X-User", user)
+
+		return balancer.Pick(candidates, &request.Request{Headers: h})
+	}
+
+	used := map[*Backend]bool{}
+	for i := range 100 {
+		user := "user-" + strconv.Itoa(i)
+		b := pick(backends, user)
+		used[b] = true
+
+		assert.Same(t, b, pick(backends, user))
+
+		// dropping another backend must not move this key
+		for _, other := range backends {
+			if other == b {
+				continue
+			}
+
+			var remaining []*Backend
+			for _, candidate := range backends {
+				if candidate != other {
+					remaining = append(remaining, candidate)
+				}
+			}
+
+			assert.Same(t, b, pick(remaining, user))
+		}
+	}
+
+	assert.Len(t, used, 4)
+
+	ip := HashByClientIP()
+	first := ip.Pick(backends, &request.Request{RemoteAddr: "10.0.0.7:5000"})
+	assert.Same(t, first, ip.Pick(backends, &request.Request{RemoteAddr: "10.0.0.7:6000"}))
+}
+
+func TestHealthCheckRemovesFailingBackend(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	targets := []*url.URL{
+		startNamedBackend(t, "a", &healthy),
+		startNamedBackend(t, "b", nil),
+	}
+
+	proxy, pool := startBalancer(t, targets, WithHealthCheck("/health", 20*time.Millisecond))
+
+	healthy.Store(false)
+	require.Eventually(t, func() bool {
+		return !pool.Backends()[0].Healthy()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for range 4 {
+		assert.Equal(t, "b", bodyOf(roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")))
+	}
+
+	healthy.Store(true)
+	require.Eventually(t, func() bool {
+		return pool.Backends()[0].Healthy()
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRetriesAndPassiveEjection(t *testing.T) {
+	targets := []*url.URL{
+		closedBackend(t),
+		startNamedBackend(t, "b", nil),
+	}
+
+	proxy, pool := startBalancer(t, targets, WithRetries(1), WithPassiveEjection(2, time.Minute))
+
+	for range 4 {
+		out := roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+		assert.Equal(t, "b", bodyOf(out))
+	}
+
+	assert.False(t, pool.Backends()[0].Available())
+	assert.True(t, pool.Backends()[1].Available())
+}
+
+func TestNonIdempotentRequestsAreNotRetried(t *testing.T) {
+	proxy, _ := startBalancer(t, []*url.URL{closedBackend(t), startNamedBackend(t, "b", nil)}, WithRetries(1))
+
+	out := roundTrip(t, proxy, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"), out)
+}
+
+func TestNoAvailableBackend(t *testing.T) {
+	target := startNamedBackend(t, "a", nil)
+	proxy, pool := startBalancer(t, []*url.URL{target})
+
+	pool.Backends()[0].unhealthy.Store(true)
+
+	out := roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+}
+
+func TestStreamedChunkedRequestsAreNotRetried(t *testing.T) {
+	p := &reverseProxy{}
+	raw := "PUT / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n"
+
+	// the stream may be partly consumed by a failed attempt, a retry would truncate it
+	streamed, err := request.RequestHeadFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.False(t, p.replayable(streamed))
+
+	buffered, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.True(t, p.replayable(buffered))
+
+	// and buffered, it reaches the next backend
+	proxy, _ := startBalancer(t, []*url.URL{closedBackend(t), startNamedBackend(t, "b", nil)}, WithRetries(1))
+
+	out := roundTrip(t, proxy, raw)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Equal(t, "b", bodyOf(out))
+}
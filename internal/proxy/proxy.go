@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/cache"
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+)
+
+const (
+	DefaultDialTimeout           = 10 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+)
+
+// hopHeaders only apply to a single connection and are never forwarded, RFC 9110
+// section 7.6.1. Fields listed in Connection are removed as well.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type reverseProxy struct {
+	target        *url.URL
+	pool          *Pool
+	client        *client.Client
+	cache         *cache.Cache
+	stripPrefix   string
+	preserveHost  bool
+	dialTimeout   time.Duration
+	headerTimeout time.Duration
+}
+
+type Option func(*reverseProxy)
+
+// WithClient replaces the default client, the timeouts of WithTimeouts are then up to
+// the given client
+func WithClient(c *client.Client) Option {
+	return func(p *reverseProxy) {
+		p.client = c
+	}
+}
+
+// WithCache answers GET and HEAD requests from c whenever RFC 9111 allows it, entries
+// are keyed by the Host header and request target the client sent
+func WithCache(c *cache.Cache) Option {
+	return func(p *reverseProxy) {
+		p.cache = c
+	}
+}
+
+// WithTimeouts bounds connecting to the upstream and waiting for its response headers,
+// exceeding either answers 504 Gateway Timeout
+func WithTimeouts(dial, responseHeader time.Duration) Option {
+	return func(p *reverseProxy) {
+		p.dialTimeout = dial
+		p.headerTimeout = responseHeader
+	}
+}
+
+// WithStripPrefix removes prefix from the request path before it is appended to the
+// upstream path, so the proxy can be mounted under e.g. "/httpbin"
+func WithStripPrefix(prefix string) Option {
+	return func(p *reverseProxy) {
+		p.stripPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithPreserveHost forwards the client's Host header instead of the upstream host
+func WithPreserveHost() Option {
+	return func(p *reverseProxy) {
+		p.preserveHost = true
+	}
+}
+
+// ReverseProxy returns a handler forwarding every request, body included, to target and
+// streaming the upstream response back with its status, headers and trailers
+func ReverseProxy(target *url.URL, options ...Option) server.Handler {
+	p := newReverseProxy(options)
+	p.target = target
+
+	return p.serve
+}
+
+// LoadBalancedProxy is a ReverseProxy spreading requests over the backends of pool
+func LoadBalancedProxy(pool *Pool, options ...Option) server.Handler {
+	p := newReverseProxy(options)
+	p.pool = pool
+
+	return p.serveBalanced
+}
+
+func newReverseProxy(options []Option) *reverseProxy {
+	p := &reverseProxy{
+		dialTimeout:   DefaultDialTimeout,
+		headerTimeout: DefaultResponseHeaderTimeout,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	if p.client == nil {
+		p.client = client.New(
+			client.WithDialTimeout(p.dialTimeout),
+			client.WithResponseHeaderTimeout(p.headerTimeout),
+			client.WithMaxIdlePerHost(16),
+		)
+	}
+
+	return p
+}
+
+func (p *reverseProxy) serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	outReq, err := p.outgoingRequest(req, p.target)
+	if err != nil {
+		return &server.HandlerError{Message: "Invalid request target", Status: response.BadRequest, Cause: err}
+	}
+
+	resp, err := p.roundTrip(req, outReq, p.client.Do)
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	defer resp.Body.Close()
+
+	return writeResponse(w, req, resp)
+}
+
+// roundTrip sends outReq through fetch, by way of the cache when there is one
+func (p *reverseProxy) roundTrip(req *request.Request, outReq *client.Request, fetch cache.Fetch) (*client.Response, error) {
+	if p.cache == nil {
+		return fetch(outReq)
+	}
+
+	return p.cache.Do(req.Headers.Get("Host")+req.RequestLine.RequestTarget, outReq, fetch)
+}
+
+func (p *reverseProxy) outgoingRequest(req *request.Request, upstream *url.URL) (*client.Request, error) {
+	incoming, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	target := *upstream
+	target.Path = joinPath(upstream.Path, p.strip(incoming.Path))
+	target.RawPath = joinPath(upstream.EscapedPath(), p.strip(incoming.EscapedPath()))
+
+	if target.RawQuery == "" || incoming.RawQuery == "" {
+		target.RawQuery += incoming.RawQuery
+	} else {
+		target.RawQuery += "&" + incoming.RawQuery
+	}
+
+	var contentLength int64
+	if value := req.Headers.Get("Content-Length"); value != "" {
+		contentLength, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var body io.Reader
+	if contentLength > 0 {
+		body = req.BodyReader()
+	}
+
+	// a chunked upload is streamed on with chunked coding again
+	if req.Headers.Exists("Transfer-Encoding") {
+		body = req.BodyReader()
+		contentLength = -1
+	}
+
+	outReq := &client.Request{
+		Method:        req.RequestLine.Method,
+		URL:           &target,
+		Headers:       headers.Headers{},
+		Body:          body,
+		ContentLength: contentLength,
+	}
+
+	for k, v := range *req.Headers {
+		outReq.Headers.Set(k, v)
+	}
+
+	removeHopHeaders(outReq.Headers)
+	outReq.Headers.Delete("Host")
+	outReq.Headers.Delete("Content-Length")
+
+	// TE is hop-by-hop, but the upstream has to know the client accepts trailers
+	if headers.HasToken(req.Headers.Get("TE"), "trailers") {
+		outReq.Headers.Set("Te", "trailers")
+	}
+
+	if p.preserveHost {
+		outReq.Host = req.Headers.Get("Host")
+	}
+
+	addForwardedHeaders(outReq.Headers, req)
+
+	return outReq, nil
+}
+
+// strip removes the prefix only as whole path segments, "/httpbinfoo" is not under "/httpbin"
+func (p *reverseProxy) strip(path string) string {
+	if p.stripPrefix != "" && (path == p.stripPrefix || strings.HasPrefix(path, p.stripPrefix+"/")) {
+		path = path[len(p.stripPrefix):]
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
+
+func writeResponse(w *response.Writer, req *request.Request, resp *client.Response) *server.HandlerError {
+	w.WriteStatusLine(resp.StatusCode)
+
+	// the announced trailer fields have to be read before Trailer goes with the hop headers
+	var trailerKeys []string
+	for k := range strings.SplitSeq(resp.Headers.Get("Trailer"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			trailerKeys = append(trailerKeys, k)
+		}
+	}
+
+	sort.Strings(trailerKeys)
+
+	removeHopHeaders(resp.Headers)
+
+	for k, v := range resp.Headers {
+		w.SetHeader(k, v)
+	}
+
+	for _, v := range resp.SetCookie {
+		w.AddHeader("Set-Cookie", v)
+	}
+
+	if !hasBody(req, resp) {
+		if err := w.WriteHeaders(w.Headers); err != nil {
+			return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+
+		return nil
+	}
+
+	chunked := len(trailerKeys) > 0 || resp.ContentLength < 0
+	if chunked {
+		w.Headers.Delete("Content-Length")
+		w.SetHeader("Transfer-Encoding", "chunked")
+
+		if len(trailerKeys) > 0 {
+			w.SetHeader("Trailer", strings.Join(trailerKeys, ", "))
+		}
+	} else {
+		w.SetHeader("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+
+	if err := w.WriteHeaders(w.Headers); err != nil {
+		return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+	}
+
+	if _, err := w.ReadFrom(resp.Body); err != nil {
+		return &server.HandlerError{Status: response.BadGateway, Internal: "copying upstream body", Cause: err}
+	}
+
+	if !chunked {
+		return nil
+	}
+
+	if len(trailerKeys) == 0 {
+		w.WriteChunkedBodyDone()
+		return nil
+	}
+
+	// resp.Trailers is only filled in once the body has been read to the end
+	trailers := headers.Headers{}
+	for _, k := range trailerKeys {
+		if resp.Trailers.Exists(k) {
+			trailers[k] = resp.Trailers.Get(k)
+		}
+	}
+
+	if err := w.WriteTrailers(trailers); err != nil {
+		return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+	}
+
+	return nil
+}
+
+// hasBody reports whether the response carries content, RFC 9110 section 6.4.1
+func hasBody(req *request.Request, resp *client.Response) bool {
+	if req.RequestLine.Method == "HEAD" {
+		return false
+	}
+
+	switch {
+	case resp.StatusCode >= 100 && resp.StatusCode < 200:
+		return false
+	case resp.StatusCode == 204, resp.StatusCode == response.NotModified:
+		return false
+	default:
+		return true
+	}
+}
+
+func upstreamError(err error) *server.HandlerError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &server.HandlerError{Message: "Upstream timed out", Status: response.GatewayTimeout, Cause: err}
+	}
+
+	return &server.HandlerError{Message: "Upstream unavailable", Status: response.BadGateway, Cause: err}
+}
+
+func removeHopHeaders(h headers.Headers) {
+	for field := range strings.SplitSeq(h.Get("Connection"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			h.Delete(field)
+		}
+	}
+
+	for _, k := range hopHeaders {
+		h.Delete(k)
+	}
+}
+
+// addForwardedHeaders records the client in both the de facto X-Forwarded-* headers and
+// the standard Forwarded header of RFC 7239, appending to what earlier proxies sent
+func addForwardedHeaders(h headers.Headers, req *request.Request) {
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+
+	host := req.Headers.Get("Host")
+	proto := scheme(req)
+
+	if clientIP != "" {
+		appendHeader(h, "X-Forwarded-For", clientIP)
+	}
+
+	h.Set("X-Forwarded-Proto", proto)
+
+	if host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+
+	element := "for=" + forwardedNode(clientIP) + ";proto=" + proto
+	if host != "" {
+		element += ";host=" + strconv.Quote(host)
+	}
+
+	appendHeader(h, "Forwarded", element)
+}
+
+func scheme(req *request.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// forwardedNode formats a node for Forwarded, IPv6 addresses have to be bracketed and quoted
+func forwardedNode(ip string) string {
+	switch {
+	case ip == "":
+		return "unknown"
+	case strings.Contains(ip, ":"):
+		return `"[` + ip + `]"`
+	default:
+		return ip
+	}
+}
+
+func appendHeader(h headers.Headers, key, value string) {
+	if prior := h.Get(key); prior != "" {
+		value = prior + ", " + value
+	}
+
+	h.Set(key, value)
+}
+
+func joinPath(base, path string) string {
+	switch {
+	case base == "":
+		return path
+	case strings.HasSuffix(base, "/"):
+		return base + strings.TrimPrefix(path, "/")
+	default:
+		return base + path
+	}
+}
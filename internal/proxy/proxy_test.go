@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/cache"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/kx0101/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, handler server.Handler, options ...server.Option) *server.Server {
+	srv, err := server.Serve(0, handler, options...)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return srv
+}
+
+func serverURL(t *testing.T, srv *server.Server, path string) *url.URL {
+	u, err := url.Parse(fmt.Sprintf("http://127.0.0.1:%d%s", srv.Listener.Addr().(*net.TCPAddr).Port, path))
+	require.NoError(t, err)
+
+	return u
+}
+
+func roundTrip(t *testing.T, srv *server.Server, raw string) string {
+	conn, err := net.Dial("tcp", serverURL(t, srv, "").Host)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+// echoUpstream answers with a description of the request it received
+func echoUpstream(w *response.Writer, req *request.Request) *server.HandlerError {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s\n", req.RequestLine.Method, req.RequestLine.RequestTarget)
+
+	for _, k := range []string{"host", "x-custom", "x-secret", "keep-alive", "x-forwarded-for", "x-forwarded-host", "x-forwarded-proto", "forwarded"} {
+		if req.Headers.Exists(k) {
+			fmt.Fprintf(&b, "%s=%s\n", k, req.Headers.Get(k))
+		}
+	}
+
+	fmt.Fprintf(&b, "body=%s\n", req.Body)
+
+	w.WriteStatusLine(response.StatusCode(201))
+	w.SetCookie(&headers.Cookie{Name: "a", Value: "1"})
+	w.SetCookie(&headers.Cookie{Name: "b", Value: "2"})
+	w.Write([]byte(b.String()))
+
+	return nil
+}
+
+func TestStripPrefixStopsAtSegments(t *testing.T) {
+	p := &reverseProxy{}
+	WithStripPrefix("/httpbin/")(p)
+
+	cases := map[string]string{
+		"/httpbin":         "/",
+		"/httpbin/":        "/",
+		"/httpbin/get":     "/get",
+		"/httpbinfoo":      "/httpbinfoo",
+		"/httpbinfoo/bar":  "/httpbinfoo/bar",
+		"/other/httpbin/x": "/other/httpbin/x",
+	}
+
+	for path, expected := range cases {
+		assert.Equal(t, expected, p.strip(path), path)
+	}
+}
+
+func TestReverseProxyForwardsRequest(t *testing.T) {
+	upstream := startTestServer(t, echoUpstream)
+	proxy := startTestServer(t, ReverseProxy(serverURL(t, upstream, "/base?fixed=1"), WithStripPrefix("/api")))
+
+	out := roundTrip(t, proxy, "POST /api/items?q=go HTTP/1.1\r\n"+
+		"Host: public.example\r\n"+
+		"Connection: close, X-Secret\r\n"+
+		"X-Secret: hop\r\n"+
+		"Keep-Alive: timeout=5\r\n"+
+		"X-Custom: kept\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 "), out)
+	assert.Equal(t, 2, strings.Count(out, "\r\nSet-Cookie: "), out)
+
+	_, body, found := strings.Cut(out, "\r\n\r\n")
+	require.True(t, found)
+
+	assert.Contains(t, body, "POST /base/items?fixed=1&q=go\n")
+	assert.Contains(t, body, "x-custom=kept\n")
+	assert.NotContains(t, body, "x-secret")
+	assert.NotContains(t, body, "keep-alive")
+	assert.Contains(t, body, "host=127.0.0.1:")
+	assert.Contains(t, body, "x-forwarded-for=10.0.0.1, 127.0.0.1\n")
+	assert.Contains(t, body, "x-forwarded-host=public.example\n")
+	assert.Contains(t, body, "x-forwarded-proto=http\n")
+	// the upstream's header parser puts a space after every semicolon
+	assert.Contains(t, body, `forwarded=for=127.0.0.1; proto=http; host="public.example"`+"\n")
+	assert.Contains(t, body, "body=hello\n")
+}
+
+func TestReverseProxyForwardsHeadAndPatch(t *testing.T) {
+	upstream := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		body := fmt.Sprintf("%s %s body=%s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body)
+
+		w.WriteStatusLine(response.OK)
+
+		if req.RequestLine.Method == "HEAD" {
+			// the length of the body a GET would have received
+			h := response.GetDefaultHeaders(42, "text/plain")
+			h.Set("X-Method", "HEAD")
+			w.WriteHeaders(h)
+
+			return nil
+		}
+
+		w.SetHeader("X-Method", req.RequestLine.Method)
+		w.Write([]byte(body))
+
+		return nil
+	})
+	proxy := startTestServer(t, ReverseProxy(serverURL(t, upstream, "")))
+
+	out := roundTrip(t, proxy, "HEAD /doc HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "x-method: HEAD\r\n")
+	assert.Contains(t, out, "content-length: 42\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), "HEAD response has a body: %q", out)
+
+	out = roundTrip(t, proxy, "PATCH /doc HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\ntitle=new")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "x-method: PATCH\r\n")
+	assert.Contains(t, out, "\r\nPATCH /doc body=title=new\r\n")
+}
+
+func TestReverseProxyForwardsChunkedUploads(t *testing.T) {
+	upstream := startTestServer(t, echoUpstream)
+	proxy := startTestServer(t, ReverseProxy(serverURL(t, upstream, "")), server.WithStreamingBodies())
+
+	out := roundTrip(t, proxy, "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 "), out)
+	assert.Contains(t, out, "body=hello world\n")
+}
+
+func TestReverseProxyForwardsHTTPSProto(t *testing.T) {
+	upstream := startTestServer(t, echoUpstream)
+	proxy := ReverseProxy(serverURL(t, upstream, ""))
+
+	req := servertest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{ServerName: servertest.DefaultHost}
+
+	rec := servertest.NewRecorder()
+	require.Nil(t, proxy(rec.Writer, req))
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+
+	assert.Contains(t, string(res.Body), "x-forwarded-proto=https\n")
+	assert.Contains(t, string(res.Body), "proto=https;")
+}
+
+func TestReverseProxyStreamsChunkedBodyAndTrailers(t *testing.T) {
+	upstream := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.SetHeader("Trailer", "X-Checksum")
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteTrailers(headers.Headers{"X-Checksum": "abc123"})
+
+		return nil
+	})
+	proxy := startTestServer(t, ReverseProxy(serverURL(t, upstream, "")))
+
+	out := roundTrip(t, proxy, "GET /stream HTTP/1.1\r\nHost: localhost\r\nTE: trailers\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, out, "trailer: X-Checksum\r\n")
+	assert.True(t, strings.HasSuffix(out, "0\r\nX-Checksum: abc123\r\n\r\n"), out)
+
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	assert.Contains(t, body, "hello ")
+	assert.Contains(t, body, "world")
+}
+
+func TestReverseProxyUpstreamErrors(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	closedURL, err := url.Parse("http://" + closed.Addr().String())
+	require.NoError(t, err)
+	closed.Close()
+
+	slow := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		time.Sleep(500 * time.Millisecond)
+
+		w.WriteStatusLine(response.OK)
+		w.Write([]byte("too late"))
+
+		return nil
+	})
+
+	tests := []struct {
+		name   string
+		target *url.URL
+		status string
+	}{
+		{name: "connection refused", target: closedURL, status: "HTTP/1.1 502 Bad Gateway\r\n"},
+		{name: "response timeout", target: serverURL(t, slow, ""), status: "HTTP/1.1 504 Gateway Timeout\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := startTestServer(t, ReverseProxy(tt.target, WithTimeouts(time.Second, 50*time.Millisecond)))
+
+			out := roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: text/plain\r\n\r\n")
+			assert.True(t, strings.HasPrefix(out, tt.status), out)
+		})
+	}
+}
+
+func TestReverseProxyCachesResponses(t *testing.T) {
+	var hits atomic.Int64
+
+	upstream := startTestServer(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		n := hits.Add(1)
+
+		w.WriteStatusLine(response.OK)
+		w.SetHeader("Cache-Control", "max-age=60")
+		w.Write([]byte(fmt.Sprintf("response %d", n)))
+
+		return nil
+	})
+	proxy := startTestServer(t, ReverseProxy(serverURL(t, upstream, ""), WithCache(cache.New())))
+
+	first := roundTrip(t, proxy, "GET /cached HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, first, "x-cache: MISS\r\n")
+	assert.Equal(t, "response 1", bodyOf(first))
+
+	second := roundTrip(t, proxy, "GET /cached HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, second, "x-cache: HIT\r\n")
+	assert.Contains(t, second, "age: 0\r\n")
+	assert.Equal(t, "response 1", bodyOf(second))
+
+	other := roundTrip(t, proxy, "GET /other HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "response 2", bodyOf(other))
+	assert.Equal(t, int64(2), hits.Load())
+}
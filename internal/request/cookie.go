@@ -0,0 +1,25 @@
+package request
+
+import (
+	"errors"
+
+	h "github.com/kx0101/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrNoCookie = errors.New("error: named cookie not present")
+)
+
+func (r *Request) Cookies() []*h.Cookie {
+	return h.ParseCookies(r.Headers.Get("Cookie"))
+}
+
+func (r *Request) Cookie(name string) (*h.Cookie, error) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie, nil
+		}
+	}
+
+	return nil, ErrNoCookie
+}
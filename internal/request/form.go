@@ -0,0 +1,185 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/multipart"
+)
+
+const (
+	MaxFormSize        = 10 << 20
+	MaxMultipartMemory = 32 << 20
+)
+
+var (
+	ErrMalformedForm        = errors.New("error: malformed form")
+	ErrFormTooLarge         = errors.New("error: form too large")
+	ErrUnsupportedMediaType = errors.New("error: unsupported media type")
+	ErrMissingFile          = errors.New("error: no such file in form")
+)
+
+// Query parses the query string of the request target
+func (r *Request) Query() (url.Values, error) {
+	_, rawQuery, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+	}
+
+	return values, nil
+}
+
+// ParseForm fills PostForm from an application/x-www-form-urlencoded body and Form
+// from both the body and the query string, body values come first. It is safe to call
+// more than once.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	query, err := r.Query()
+	if err != nil {
+		return err
+	}
+
+	postForm, err := r.parsePostForm()
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	for k, v := range postForm {
+		form[k] = append(form[k], v...)
+	}
+
+	for k, v := range query {
+		form[k] = append(form[k], v...)
+	}
+
+	r.PostForm = postForm
+	r.Form = form
+
+	return nil
+}
+
+// FormValue returns the first value for key, parse errors are ignored
+func (r *Request) FormValue(key string) string {
+	if err := r.ParseForm(); err != nil {
+		return ""
+	}
+
+	return r.Form.Get(key)
+}
+
+// MultipartReader streams the parts of a multipart/form-data body, use it instead of
+// ParseMultipartForm to process uploads part by part
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedMediaType, r.Headers.Get("Content-Type"))
+	}
+
+	reader, err := multipart.NewReader(r.BodyReader(), params["boundary"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+	}
+
+	return reader, nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm, file parts
+// above maxMemory bytes are spilled to temporary files. Text values are also added to
+// Form and PostForm.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form, err := reader.ReadForm(maxMemory)
+	if errors.Is(err, multipart.ErrMessageTooLarge) {
+		return fmt.Errorf("%w: %v", ErrFormTooLarge, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedForm, err)
+	}
+
+	for k, v := range form.Value {
+		r.Form[k] = append(r.Form[k], v...)
+		r.PostForm[k] = append(r.PostForm[k], v...)
+	}
+
+	r.MultipartForm = form
+
+	return nil
+}
+
+// FormFile opens the first file uploaded under key, parsing the form if needed
+func (r *Request) FormFile(key string) (multipart.File, *multipart.FileHeader, error) {
+	if err := r.ParseMultipartForm(MaxMultipartMemory); err != nil {
+		return nil, nil, err
+	}
+
+	files := r.MultipartForm.File[key]
+	if len(files) == 0 {
+		return nil, nil, ErrMissingFile
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, files[0], nil
+}
+
+func (r *Request) parsePostForm() (url.Values, error) {
+	contentType := r.Headers.Get("Content-Type")
+	if contentType == "" && r.Headers.Get("Content-Length") == "" && len(r.Body) == 0 {
+		return url.Values{}, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == "multipart/form-data" {
+		// multipart bodies are read by ParseMultipartForm
+		return url.Values{}, nil
+	}
+
+	body, err := r.ReadBody(MaxFormSize)
+	if errors.Is(err, ErrBodyTooLarge) {
+		return nil, fmt.Errorf("%w: %v", ErrFormTooLarge, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return url.Values{}, nil
+	}
+
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedMediaType, contentType)
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+	}
+
+	return values, nil
+}
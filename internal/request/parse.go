@@ -3,9 +3,11 @@ package request
 import (
 	"errors"
 	"fmt"
-	"slices"
+	"net"
 	"strconv"
 	"strings"
+
+	h "github.com/kx0101/httpfromtcp/internal/headers"
 )
 
 const (
@@ -13,7 +15,6 @@ const (
 )
 
 var (
-	methods                             = []string{"GET", "POST", "PUT", "DELETE"}
 	ErrTryingToParseDoneRequest         = errors.New("error: trying to read data in a done request")
 	ErrInvalidRequestLine               = errors.New("error: invalid request line")
 	ErrInvalidMethod                    = errors.New("error: invalid method")
@@ -27,7 +28,7 @@ var (
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 
-	for r.Status != RequestStateDone {
+	for r.Status != RequestStateDone && !(r.headOnly && r.Status == RequestStateParsingBody) {
 		bytesParsed, err := r.parseSingle(data[totalBytesParsed:])
 
 		if err != nil {
@@ -41,7 +42,7 @@ func (r *Request) parse(data []byte) (int, error) {
 		totalBytesParsed += bytesParsed
 	}
 
-	if r.Status == RequestStateDone && totalBytesParsed != len(data) {
+	if r.Status == RequestStateDone && totalBytesParsed != len(data) && !r.headOnly {
 		if r.Headers.Get("Content-Length") != "" {
 			return totalBytesParsed, ErrInvalidContentLengthExpectedMore
 		}
@@ -140,11 +141,18 @@ func parseRequestLine(data string) (RequestLine, int, error) {
 
 	method, requestTarget, httpVersion := components[0], components[1], components[2]
 
-	if !slices.Contains(methods, method) {
-		return RequestLine{}, 0, fmt.Errorf("%w: %s, expected one of: %v", ErrInvalidMethod, method, methods)
+	// any token is a method, unknown ones are for the handler to refuse, RFC 9110 section 9.1
+	if !h.IsToken(method) {
+		return RequestLine{}, 0, fmt.Errorf("%w: %s", ErrInvalidMethod, method)
 	}
 
-	if !strings.HasPrefix(requestTarget, "/") {
+	if method == "CONNECT" {
+		if !isAuthorityForm(requestTarget) {
+			return RequestLine{}, 0, fmt.Errorf("%w: %s, expected host:port", ErrInvalidTarget, requestTarget)
+		}
+	} else if method == "OPTIONS" && requestTarget == "*" {
+		// asterisk-form asks about the server as a whole, RFC 9112 section 3.2.4
+	} else if !strings.HasPrefix(requestTarget, "/") {
 		return RequestLine{}, 0, fmt.Errorf("%w: %s, expected to start with '/'", ErrInvalidTarget, requestTarget)
 	}
 
@@ -158,3 +166,16 @@ func parseRequestLine(data string) (RequestLine, int, error) {
 		HttpVersion:   httpVersion,
 	}, endIndex + 2, nil
 }
+
+// isAuthorityForm reports whether target is the host:port form CONNECT requires,
+// RFC 9112 section 3.2.3
+func isAuthorityForm(target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" || strings.ContainsAny(host, "/?#@") {
+		return false
+	}
+
+	n, err := strconv.Atoi(port)
+
+	return err == nil && n > 0 && n <= 65535
+}
@@ -0,0 +1,31 @@
+package request
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+var (
+	ErrNoPeerCertificate = errors.New("error: no verified client certificate")
+)
+
+// PeerChain returns the client certificate chain the server verified, leaf first, or nil
+// when the request was not made over mutual TLS
+func (r *Request) PeerChain() []*x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0]
+}
+
+// PeerCertificate returns the verified client certificate. Unverified certificates in
+// TLS.PeerCertificates are never returned.
+func (r *Request) PeerCertificate() (*x509.Certificate, error) {
+	chain := r.PeerChain()
+	if len(chain) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+
+	return chain[0], nil
+}
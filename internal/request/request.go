@@ -1,11 +1,15 @@
 package request
 
 import (
+	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net/url"
 	"strconv"
 
 	h "github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/multipart"
 )
 
 const (
@@ -21,6 +25,23 @@ type Request struct {
 	Headers     *h.Headers
 	Body        []byte
 	Status      Status
+
+	// RemoteAddr is the client's network address, set by the server
+	RemoteAddr string
+	// TLS is set by the server for requests received over TLS
+	TLS *tls.ConnectionState
+	// Trailers of a chunked body, filled in once the body has been read to the end
+	Trailers h.Headers
+
+	// Form and PostForm are only populated after ParseForm
+	Form          url.Values
+	PostForm      url.Values
+	MultipartForm *multipart.Form
+
+	headOnly bool
+	pending  *bytes.Reader
+	body     io.Reader
+	bodyDone chan struct{}
 }
 
 type RequestLine struct {
@@ -87,6 +108,19 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		return nil, err
 	}
 
+	r.pending = bytes.NewReader(append([]byte(nil), buf[:readToIndex]...))
+
+	if r.Headers.Exists("Transfer-Encoding") {
+		body, err := r.chunkedBody(io.MultiReader(r.pending, reader))
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Body, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
 	return &r, nil
 }
 
@@ -1,8 +1,15 @@
 package request
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"strconv"
+	"strings"
 	"testing"
 
+	h "github.com/kx0101/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -99,6 +106,53 @@ func TestInvalidVersion(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestAnyTokenMethod(t *testing.T) {
+	for _, method := range []string{"HEAD", "PATCH", "OPTIONS", "PROPFIND", "M-SEARCH"} {
+		r, err := RequestFromReader(&chunkReader{data: method + " /item HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 3})
+		require.NoError(t, err, method)
+		assert.Equal(t, method, r.RequestLine.Method)
+	}
+
+	r, err := RequestFromReader(&chunkReader{data: "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 64})
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.RequestLine.RequestTarget)
+
+	_, err = RequestFromReader(&chunkReader{data: "GET * HTTP/1.1\r\n\r\n", numBytesPerRead: 64})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	for _, method := range []string{"GE(T", "G\"ET", "GET;"} {
+		_, err := RequestFromReader(&chunkReader{data: method + " / HTTP/1.1\r\n\r\n", numBytesPerRead: 64})
+		assert.ErrorIs(t, err, ErrInvalidMethod, method)
+	}
+}
+
+func TestConnectRequestLine(t *testing.T) {
+	reader := &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	r, err = RequestFromReader(&chunkReader{data: "CONNECT [::1]:8443 HTTP/1.1\r\n\r\n", numBytesPerRead: 64})
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:8443", r.RequestLine.RequestTarget)
+
+	for _, line := range []string{
+		"CONNECT /tunnel HTTP/1.1",
+		"CONNECT example.com HTTP/1.1",
+		"CONNECT example.com:0 HTTP/1.1",
+		"CONNECT user@example.com:443 HTTP/1.1",
+		"GET example.com:443 HTTP/1.1",
+	} {
+		_, err := RequestFromReader(&chunkReader{data: line + "\r\n\r\n", numBytesPerRead: 64})
+		require.ErrorIs(t, err, ErrInvalidTarget, line)
+	}
+}
+
 func TestRequestWithHeaders(t *testing.T) {
 	reader := &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -198,3 +252,303 @@ func TestInvalidRequestWithBody(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, ErrInvalidContentLengthExpectedMore, err)
 }
+
+func TestRequestCookies(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc; theme=dark\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+
+	r, err := RequestFromReader(reader)
+
+	require.NoError(t, err)
+	require.Len(t, r.Cookies(), 2)
+
+	cookie, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", cookie.Value)
+
+	_, err = r.Cookie("missing")
+	require.ErrorIs(t, err, ErrNoCookie)
+}
+
+func TestParseFormMergesQueryAndBody(t *testing.T) {
+	body := "name=John+Doe&city=S%C3%A3o+Paulo&tag=body"
+	reader := &chunkReader{
+		data: "POST /submit?tag=query&page=2 HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 3,
+	}
+
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "John Doe", r.FormValue("name"))
+	assert.Equal(t, "São Paulo", r.PostForm.Get("city"))
+	assert.Equal(t, []string{"body", "query"}, r.Form["tag"])
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Empty(t, r.PostForm.Get("page"))
+}
+
+func TestParseFormErrors(t *testing.T) {
+	newRequest := func(target, contentType, body string) *Request {
+		headers := h.NewHeaders()
+		headers.Set("Content-Type", contentType)
+
+		return &Request{
+			RequestLine: RequestLine{Method: "POST", RequestTarget: target, HttpVersion: "HTTP/1.1"},
+			Headers:     headers,
+			Body:        []byte(body),
+		}
+	}
+
+	assert.ErrorIs(t, newRequest("/", "application/json", `{"a":1}`).ParseForm(), ErrUnsupportedMediaType)
+	assert.ErrorIs(t, newRequest("/", "application/x-www-form-urlencoded", "a=%zz").ParseForm(), ErrMalformedForm)
+	assert.ErrorIs(t, newRequest("/?a=%zz", "", "").ParseForm(), ErrMalformedForm)
+	assert.ErrorIs(t, newRequest("/", "application/x-www-form-urlencoded", strings.Repeat("a", MaxFormSize+1)).ParseForm(), ErrFormTooLarge)
+}
+
+func TestRequestHeadFromReaderStreamsBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 5,
+	}
+
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.Nil(t, r.Body)
+
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+}
+
+func TestRequestHeadFromReaderTruncatedBody(t *testing.T) {
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 5,
+	}
+
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+
+	_, err = r.ReadBody(100)
+	require.ErrorIs(t, err, ErrInvalidContentLengthExpectedMore)
+}
+
+func TestRequestHeadFromReaderChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;ext=1\r\nhello \r\n" +
+			"6\r\nworld!\r\n" +
+			"0\r\nX-Checksum: abc\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+
+	body, err := r.ReadBody(100)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+
+	// the next request is left on the connection
+	next, err := RequestHeadFromReader(io.MultiReader(bytes.NewReader(r.Buffered()), reader))
+	require.NoError(t, err)
+	assert.Equal(t, "/next", next.RequestLine.RequestTarget)
+}
+
+func TestRequestFromReaderChunkedBody(t *testing.T) {
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+	assert.Empty(t, r.Buffered())
+}
+
+func TestRequestHeadFromReaderRejectsChunkedErrors(t *testing.T) {
+	head := "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"
+
+	for name, body := range map[string]string{
+		"bad size":        "zz\r\nhello\r\n0\r\n\r\n",
+		"missing crlf":    "5\r\nhelloX0\r\n\r\n",
+		"truncated chunk": "a\r\nhello",
+		"no last chunk":   "5\r\nhello\r\n",
+	} {
+		r, err := RequestHeadFromReader(&chunkReader{data: head + body, numBytesPerRead: 3})
+		require.NoError(t, err, name)
+
+		_, err = r.ReadBody(100)
+		assert.Error(t, err, name)
+	}
+
+	_, err := RequestHeadFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+
+	_, err = RequestHeadFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	assert.ErrorIs(t, err, ErrContentLengthAndTransferCode)
+}
+
+func TestBodyDone(t *testing.T) {
+	isDone := func(r *Request) bool {
+		select {
+		case <-r.BodyDone():
+			return true
+		default:
+			return false
+		}
+	}
+
+	r, err := RequestHeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET"))
+	require.NoError(t, err)
+	assert.False(t, isDone(r))
+
+	_, err = io.ReadFull(r.BodyReader(), make([]byte, 5))
+	require.NoError(t, err)
+	assert.True(t, isDone(r))
+
+	r, err = RequestHeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(r.BodyReader(), make([]byte, 5))
+	require.NoError(t, err)
+	assert.False(t, isDone(r), "the last chunk has not been read")
+
+	_, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.True(t, isDone(r))
+
+	r, err = RequestHeadFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, isDone(r))
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, isDone(r))
+}
+
+func TestRequestHeadFromReaderIncomplete(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: local",
+		numBytesPerRead: 5,
+	}
+
+	_, err := RequestHeadFromReader(reader)
+	require.ErrorIs(t, err, ErrIncompleteRequest)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	body := "--b\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"hello\r\n" +
+		"--b\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"a.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"file content\r\n" +
+		"--b--\r\n"
+
+	reader := &chunkReader{
+		data: "POST /upload?page=1 HTTP/1.1\r\n" +
+			"Content-Type: multipart/form-data; boundary=b\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 7,
+	}
+
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+
+	require.NoError(t, r.ParseMultipartForm(1024))
+	defer r.MultipartForm.RemoveAll()
+
+	assert.Equal(t, "hello", r.FormValue("title"))
+	assert.Equal(t, "1", r.FormValue("page"))
+
+	file, header, err := r.FormFile("upload")
+	require.NoError(t, err)
+	defer file.Close()
+
+	assert.Equal(t, "a.txt", header.Filename)
+
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "file content", string(data))
+
+	_, _, err = r.FormFile("missing")
+	assert.ErrorIs(t, err, ErrMissingFile)
+}
+
+func TestBufferedReturnsBytesPastTheRequest(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET /chat HTTP/1.1\r\nUpgrade: custom\r\n\r\nhello after the head",
+		numBytesPerRead: 64,
+	}
+
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+
+	// whatever the parser did not read yet is still in the reader
+	rest, err := io.ReadAll(io.MultiReader(bytes.NewReader(r.Buffered()), reader))
+	require.NoError(t, err)
+	assert.Equal(t, "hello after the head", string(rest))
+	assert.Empty(t, r.Buffered())
+
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nContent-Length: 4\r\n\r\nbodyextra",
+		numBytesPerRead: 64,
+	}
+
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, "bodyextra", string(r.Buffered()))
+}
+
+func TestPeerCertificate(t *testing.T) {
+	r := &Request{}
+
+	_, err := r.PeerCertificate()
+	assert.ErrorIs(t, err, ErrNoPeerCertificate)
+	assert.Nil(t, r.PeerChain())
+
+	// a certificate the server did not verify is not an identity
+	leaf := &x509.Certificate{DNSNames: []string{"billing.internal"}}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+
+	_, err = r.PeerCertificate()
+	assert.ErrorIs(t, err, ErrNoPeerCertificate)
+
+	ca := &x509.Certificate{IsCA: true}
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{leaf, ca}}
+
+	cert, err := r.PeerCertificate()
+	require.NoError(t, err)
+	assert.Same(t, leaf, cert)
+	assert.Equal(t, []*x509.Certificate{leaf, ca}, r.PeerChain())
+}
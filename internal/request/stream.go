@@ -0,0 +1,396 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	h "github.com/kx0101/httpfromtcp/internal/headers"
+)
+
+const (
+	// maxChunkLineBytes bounds a chunk size line and each trailer field line
+	maxChunkLineBytes = 4096
+	maxTrailerBytes   = 8 << 10
+)
+
+var (
+	ErrIncompleteRequest            = errors.New("error: connection closed before end of request head")
+	ErrBodyTooLarge                 = errors.New("error: request body too large")
+	ErrMalformedChunk               = errors.New("error: malformed chunked request body")
+	ErrUnsupportedTransferEncoding  = errors.New("error: unsupported transfer coding")
+	ErrContentLengthAndTransferCode = errors.New("error: request has both Content-Length and Transfer-Encoding")
+)
+
+// RequestHeadFromReader parses the request line and headers only. The body is left
+// unread and is available as a stream through BodyReader, which lets handlers consume
+// uploads larger than what RequestFromReader could hold in memory.
+func RequestHeadFromReader(reader io.Reader) (*Request, error) {
+	buf := make([]byte, BufferSize)
+	readToIndex := 0
+
+	r := Request{
+		RequestLine: RequestLine{},
+		Headers:     h.NewHeaders(),
+		Body:        nil,
+		Status:      Initialized,
+		headOnly:    true,
+	}
+
+	for r.Status == Initialized || r.Status == RequestStateParsingHeaders {
+		if readToIndex >= len(buf) {
+			newBuf := make([]byte, len(buf)*2)
+			copy(newBuf, buf)
+
+			buf = newBuf
+		}
+
+		n, err := reader.Read(buf[readToIndex:])
+		readToIndex += n
+
+		if n > 0 {
+			bytesParsed, parseErr := r.parse(buf[:readToIndex])
+			if parseErr != nil {
+				return nil, parseErr
+			}
+
+			if bytesParsed > 0 {
+				copy(buf, buf[bytesParsed:])
+				readToIndex -= bytesParsed
+			}
+		}
+
+		if err == io.EOF && (r.Status == Initialized || r.Status == RequestStateParsingHeaders) {
+			return nil, ErrIncompleteRequest
+		}
+
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	r.Status = RequestStateDone
+	r.pending = bytes.NewReader(append([]byte(nil), buf[:readToIndex]...))
+	src := io.MultiReader(r.pending, reader)
+
+	bodyDone := make(chan struct{})
+	var once sync.Once
+	r.bodyDone = bodyDone
+	finish := func() { once.Do(func() { close(bodyDone) }) }
+
+	if r.Headers.Exists("Transfer-Encoding") {
+		body, err := r.chunkedBody(src)
+		if err != nil {
+			return nil, err
+		}
+
+		body.finish = finish
+		r.body = body
+
+		return &r, nil
+	}
+
+	contentLength := int64(0)
+	if value := r.Headers.Get("Content-Length"); value != "" {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return nil, ErrInvalidContentLength
+		}
+
+		contentLength = length
+	}
+
+	r.body = &bodyReader{
+		src:       src,
+		remaining: contentLength,
+		finish:    finish,
+	}
+
+	if contentLength == 0 {
+		finish()
+	}
+
+	return &r, nil
+}
+
+// closedChan is what BodyDone returns for bodies that were never streamed
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+
+	return ch
+}()
+
+// BodyDone returns a channel that is closed once the streamed body has been read to
+// the end or failed, from then on the connection holds only what the client sent next.
+// For requests read with RequestFromReader it is closed already.
+func (r *Request) BodyDone() <-chan struct{} {
+	if r.bodyDone == nil {
+		return closedChan
+	}
+
+	return r.bodyDone
+}
+
+// BodyReader returns the request body as a stream, for requests read with
+// RequestFromReader it reads the buffered Body
+func (r *Request) BodyReader() io.Reader {
+	if r.body != nil && r.Body == nil {
+		return r.body
+	}
+
+	return bytes.NewReader(r.Body)
+}
+
+// SetBodyReader makes body the stream BodyReader and ReadBody consume, for requests whose
+// body does not come from RequestHeadFromReader, like those of HTTP/2 streams
+func (r *Request) SetBodyReader(body io.Reader) {
+	r.Body = nil
+	r.body = body
+}
+
+// ReadBody buffers a streamed body into Body, failing with ErrBodyTooLarge when it is
+// longer than limit. Requests read with RequestFromReader are returned as is.
+func (r *Request) ReadBody(limit int64) ([]byte, error) {
+	if r.body == nil || r.Body != nil {
+		if int64(len(r.Body)) > limit {
+			return nil, fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, len(r.Body))
+		}
+
+		return r.Body, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, limit)
+	}
+
+	r.Body = body
+
+	return body, nil
+}
+
+// Buffered returns the bytes that were read from the connection past the parsed part of
+// the request and not consumed since, e.g. the first bytes of a protocol the client
+// switched to. They are handed over only once.
+func (r *Request) Buffered() []byte {
+	if r.pending == nil || r.pending.Len() == 0 {
+		return nil
+	}
+
+	buffered := make([]byte, r.pending.Len())
+	r.pending.Read(buffered)
+
+	return buffered
+}
+
+// bodyReader limits the stream to Content-Length and reports truncated bodies
+type bodyReader struct {
+	src       io.Reader
+	remaining int64
+	finish    func()
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.src.Read(p)
+	b.remaining -= int64(n)
+
+	if b.remaining == 0 || err != nil {
+		b.finish()
+	}
+
+	if err == io.EOF && b.remaining > 0 {
+		return n, ErrInvalidContentLengthExpectedMore
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+// chunkedBody decodes the body of a request sent with Transfer-Encoding from src, only
+// the chunked coding is supported
+func (r *Request) chunkedBody(src io.Reader) (*chunkedReader, error) {
+	// a request framed both ways is how requests get smuggled, RFC 9112 section 6.3
+	if r.Headers.Exists("Content-Length") {
+		return nil, ErrContentLengthAndTransferCode
+	}
+
+	if te := r.Headers.Get("Transfer-Encoding"); !strings.EqualFold(te, "chunked") {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedTransferEncoding, te)
+	}
+
+	r.Trailers = h.Headers{}
+
+	return &chunkedReader{src: src, trailers: r.Trailers}, nil
+}
+
+// chunkedReader decodes a chunked request body, RFC 9112 section 7.1. It never reads
+// past the end of the body, whatever follows stays on the connection.
+type chunkedReader struct {
+	src       io.Reader
+	remaining int64
+	trailers  h.Headers
+	done      bool
+	err       error
+	finish    func()
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	n, err := c.read(p)
+
+	if (c.done || c.err != nil) && c.finish != nil {
+		c.finish()
+	}
+
+	return n, err
+}
+
+func (c *chunkedReader) read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 {
+		size, err := c.readSize()
+		if err != nil {
+			c.err = err
+			return 0, err
+		}
+
+		if size == 0 {
+			if err := c.readTrailers(); err != nil {
+				c.err = err
+				return 0, err
+			}
+
+			c.done = true
+
+			return 0, io.EOF
+		}
+
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.src.Read(p)
+	c.remaining -= int64(n)
+
+	if err == io.EOF {
+		c.err = ErrInvalidContentLengthExpectedMore
+		return n, c.err
+	}
+
+	if err == nil && c.remaining == 0 {
+		if line, lineErr := c.readLine(); lineErr != nil || line != "" {
+			c.err = fmt.Errorf("%w: chunk not followed by CRLF", ErrMalformedChunk)
+			return n, c.err
+		}
+	}
+
+	return n, err
+}
+
+func (c *chunkedReader) readSize() (int64, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	// chunk extensions are ignored
+	line, _, _ = strings.Cut(line, ";")
+
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: size %q", ErrMalformedChunk, line)
+	}
+
+	return size, nil
+}
+
+func (c *chunkedReader) readTrailers() error {
+	var block []byte
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+
+		block = append(block, line...)
+		block = append(block, crlf...)
+
+		if len(block) > maxTrailerBytes {
+			return fmt.Errorf("%w: trailer section too large", ErrMalformedChunk)
+		}
+
+		if line == "" {
+			break
+		}
+	}
+
+	if len(block) == 2 {
+		return nil
+	}
+
+	if _, _, err := c.trailers.Parse(block); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedChunk, err)
+	}
+
+	return nil
+}
+
+// readLine reads a CRLF terminated line a byte at a time, a buffered reader would
+// consume what follows the body
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	var b [1]byte
+
+	for {
+		if _, err := io.ReadFull(c.src, b[:]); err != nil {
+			if err == io.EOF {
+				err = ErrInvalidContentLengthExpectedMore
+			}
+
+			return "", err
+		}
+
+		if b[0] == '\n' {
+			if len(line) == 0 || line[len(line)-1] != '\r' {
+				return "", fmt.Errorf("%w: line not terminated by CRLF", ErrMalformedChunk)
+			}
+
+			return string(line[:len(line)-1]), nil
+		}
+
+		line = append(line, b[0])
+		if len(line) > maxChunkLineBytes {
+			return "", fmt.Errorf("%w: line too long", ErrMalformedChunk)
+		}
+	}
+}
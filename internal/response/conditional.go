@@ -0,0 +1,151 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+)
+
+// headers a 304 response keeps from the 200 response it replaces, see RFC 9110 section 15.4.5
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary", "Last-Modified"}
+
+func StrongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
+}
+
+func WeakETag(body []byte) string {
+	return "W/" + StrongETag(body)
+}
+
+// CheckPreconditions evaluates the conditional request headers against the ETag set in
+// w.Headers and modtime, in the order of RFC 9110 section 13.2.2. When a precondition
+// fails it writes the 304 Not Modified or 412 Precondition Failed response and returns
+// true, in which case the handler must not write anything else.
+func CheckPreconditions(w *Writer, req *request.Request, modtime time.Time) bool {
+	etag := w.Headers.Get("ETag")
+	method := req.RequestLine.Method
+
+	if ifMatch := req.Headers.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return writePreconditionFailed(w)
+		}
+	} else if ifUnmodifiedSince := req.Headers.Get("If-Unmodified-Since"); ifUnmodifiedSince != "" && !modtime.IsZero() {
+		t, err := headers.ParseTime(ifUnmodifiedSince)
+		if err == nil && modtime.Truncate(time.Second).After(t) {
+			return writePreconditionFailed(w)
+		}
+	}
+
+	if ifNoneMatch := req.Headers.Get("If-None-Match"); ifNoneMatch != "" {
+		if !etagListMatches(ifNoneMatch, etag, false) {
+			return false
+		}
+
+		if method == "GET" || method == "HEAD" {
+			return writeNotModified(w)
+		}
+
+		return writePreconditionFailed(w)
+	}
+
+	if ifModifiedSince := req.Headers.Get("If-Modified-Since"); ifModifiedSince != "" && !modtime.IsZero() && (method == "GET" || method == "HEAD") {
+		t, err := headers.ParseTime(ifModifiedSince)
+		if err == nil && !modtime.Truncate(time.Second).After(t) {
+			return writeNotModified(w)
+		}
+	}
+
+	return false
+}
+
+func writeNotModified(w *Writer) bool {
+	kept := headers.Headers{}
+	for _, key := range notModifiedHeaders {
+		if w.Headers.Exists(key) {
+			kept.Set(key, w.Headers.Get(key))
+		}
+	}
+
+	kept.Set("Connection", "close")
+	w.Headers = kept
+
+	w.WriteStatusLine(NotModified)
+	w.WriteHeaders(w.Headers)
+
+	return true
+}
+
+func writePreconditionFailed(w *Writer) bool {
+	w.WriteStatusLine(PreconditionFailed)
+	w.WriteHeaders(GetDefaultHeaders(0, "text/plain"))
+
+	return true
+}
+
+// etagListMatches reports whether etag is in the comma separated list of entity-tags,
+// If-Match uses the strong comparison and If-None-Match the weak one (RFC 9110 section 8.8.3.2)
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+
+		candidate, rest, ok := scanETag(list)
+		if !ok {
+			return false
+		}
+
+		if etagsMatch(candidate, etag, strong) {
+			return true
+		}
+
+		list = rest
+	}
+}
+
+func etagsMatch(a, b string, strong bool) bool {
+	if strong {
+		return !isWeakETag(a) && !isWeakETag(b) && a == b
+	}
+
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// scanETag reads a single entity-tag from the start of s, commas are valid inside the quotes
+func scanETag(s string) (etag string, rest string, ok bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+
+	if len(s) < start+2 || s[start] != '"' {
+		return "", "", false
+	}
+
+	end := strings.IndexByte(s[start+1:], '"')
+	if end == -1 {
+		return "", "", false
+	}
+
+	end += start + 2
+
+	return s[:end], s[end:], true
+}
@@ -0,0 +1,96 @@
+package response
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETagGeneration(t *testing.T) {
+	strong := StrongETag([]byte("hello world"))
+
+	assert.True(t, strings.HasPrefix(strong, "\""))
+	assert.True(t, strings.HasSuffix(strong, "\""))
+	assert.Equal(t, strong, StrongETag([]byte("hello world")))
+	assert.NotEqual(t, strong, StrongETag([]byte("hello world!")))
+	assert.Equal(t, "W/"+strong, WeakETag([]byte("hello world")))
+}
+
+func TestEtagListMatches(t *testing.T) {
+	assert.True(t, etagListMatches(`"a", "b,c"`, `"b,c"`, true))
+	assert.True(t, etagListMatches(`W/"a"`, `"a"`, false))
+	assert.False(t, etagListMatches(`W/"a"`, `"a"`, true))
+	assert.True(t, etagListMatches("*", `"a"`, true))
+	assert.False(t, etagListMatches("*", "", true))
+	assert.False(t, etagListMatches(`"a`, `"a"`, false))
+}
+
+func TestCheckPreconditionsIfNoneMatch(t *testing.T) {
+	w := NewWriter()
+	w.SetHeader("ETag", `"v1"`)
+	w.SetHeader("Content-Type", "text/plain")
+
+	done := CheckPreconditions(w, newRangeRequest(map[string]string{"If-None-Match": `"v0", W/"v1"`}), time.Time{})
+
+	require.True(t, done)
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, "etag: \"v1\"\r\n")
+	assert.NotContains(t, out, "content-type")
+}
+
+func TestCheckPreconditionsIfNoneMatchUnsafeMethod(t *testing.T) {
+	w := NewWriter()
+	w.SetHeader("ETag", `"v1"`)
+
+	req := newRangeRequest(map[string]string{"If-None-Match": "*"})
+	req.RequestLine.Method = "PUT"
+
+	require.True(t, CheckPreconditions(w, req, time.Time{}))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 412 Precondition Failed\r\n"))
+}
+
+func TestCheckPreconditionsIfMatch(t *testing.T) {
+	w := NewWriter()
+	w.SetHeader("ETag", `"v2"`)
+
+	require.True(t, CheckPreconditions(w, newRangeRequest(map[string]string{"If-Match": `"v1"`}), time.Time{}))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	w = NewWriter()
+	w.SetHeader("ETag", `"v2"`)
+	assert.False(t, CheckPreconditions(w, newRangeRequest(map[string]string{"If-Match": `"v1", "v2"`}), time.Time{}))
+}
+
+func TestCheckPreconditionsModifiedSince(t *testing.T) {
+	modtime := time.Date(2025, 4, 6, 12, 0, 0, 500, time.UTC)
+
+	w := NewWriter()
+	require.True(t, CheckPreconditions(w, newRangeRequest(map[string]string{"If-Modified-Since": headers.FormatTime(modtime)}), modtime))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 304 Not Modified\r\n"))
+
+	w = NewWriter()
+	assert.False(t, CheckPreconditions(w, newRangeRequest(map[string]string{"If-Modified-Since": headers.FormatTime(modtime.Add(-time.Hour))}), modtime))
+
+	w = NewWriter()
+	require.True(t, CheckPreconditions(w, newRangeRequest(map[string]string{"If-Unmodified-Since": headers.FormatTime(modtime.Add(-time.Hour))}), modtime))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 412 Precondition Failed\r\n"))
+}
+
+func TestCheckPreconditionsIfNoneMatchTakesPrecedence(t *testing.T) {
+	modtime := time.Date(2025, 4, 6, 12, 0, 0, 0, time.UTC)
+
+	w := NewWriter()
+	w.SetHeader("ETag", `"v2"`)
+
+	req := newRangeRequest(map[string]string{
+		"If-None-Match":     `"v1"`,
+		"If-Modified-Since": headers.FormatTime(modtime),
+	})
+
+	assert.False(t, CheckPreconditions(w, req, modtime))
+}
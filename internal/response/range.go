@@ -0,0 +1,298 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+)
+
+const (
+	maxRanges = 100
+)
+
+var (
+	ErrInvalidRange        = errors.New("error: invalid range")
+	ErrRangeNotSatisfiable = errors.New("error: range not satisfiable")
+)
+
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header value against a representation of the given size,
+// see RFC 9110 section 14.1.2. Unsatisfiable ranges are dropped and ErrRangeNotSatisfiable
+// is returned only if none of them remain.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, rangeSet, found := strings.Cut(value, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRange, value)
+	}
+
+	var ranges []ByteRange
+	specs := 0
+
+	for spec := range strings.SplitSeq(rangeSet, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		specs++
+
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+		}
+
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+			}
+
+			if suffix == 0 || size == 0 {
+				continue
+			}
+
+			suffix = min(suffix, size)
+			ranges = append(ranges, ByteRange{Start: size - suffix, Length: suffix})
+
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+		}
+
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+			}
+		}
+
+		if start >= size {
+			continue
+		}
+
+		end = min(end, size-1)
+		ranges = append(ranges, ByteRange{Start: start, Length: end - start + 1})
+	}
+
+	if specs == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRange, value)
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+
+	return ranges, nil
+}
+
+// ServeContent writes content as the response body, answering conditional requests with
+// 304 or 412 and Range requests with 206 Partial Content. The ETag validator is taken
+// from w.Headers when the handler has set one.
+func ServeContent(w *Writer, req *request.Request, contentType string, modtime time.Time, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	w.SetHeader("Accept-Ranges", "bytes")
+
+	if contentType != "" && !w.Headers.Exists("Content-Type") {
+		w.SetHeader("Content-Type", contentType)
+	}
+
+	if !modtime.IsZero() {
+		w.SetHeader("Last-Modified", headers.FormatTime(modtime))
+	}
+
+	if CheckPreconditions(w, req, modtime) {
+		return nil
+	}
+
+	ranges, err := requestedRanges(w, req, modtime, size)
+	if errors.Is(err, ErrRangeNotSatisfiable) {
+		w.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.SetHeader("Content-Length", "0")
+		w.Headers.Delete("Content-Type")
+
+		if err := w.WriteStatusLine(RangeNotSatisfiable); err != nil {
+			return err
+		}
+
+		return w.WriteHeaders(w.Headers)
+	}
+
+	switch {
+	case len(ranges) == 1:
+		r := ranges[0]
+
+		w.SetHeader("Content-Range", r.ContentRange(size))
+
+		return writeSection(w, PartialContent, content, r.Start, r.Length)
+	case len(ranges) > 1:
+		return writeMultipartRanges(w, content, w.Headers.Get("Content-Type"), ranges, size)
+	default:
+		return writeSection(w, OK, content, 0, size)
+	}
+}
+
+// requestedRanges returns the ranges to serve, or nil when the full representation
+// should be sent because there is no usable Range header or If-Range does not match
+func requestedRanges(w *Writer, req *request.Request, modtime time.Time, size int64) ([]ByteRange, error) {
+	rangeHeader := req.Headers.Get("Range")
+	if rangeHeader == "" || req.RequestLine.Method != "GET" {
+		return nil, nil
+	}
+
+	if ifRange := req.Headers.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, w.Headers.Get("ETag"), modtime) {
+		return nil, nil
+	}
+
+	ranges, err := ParseRange(rangeHeader, size)
+	if errors.Is(err, ErrInvalidRange) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ranges) > maxRanges || sumRanges(ranges) > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// ifRangeMatches only accepts strong validators, see RFC 9110 section 13.1.5
+func ifRangeMatches(ifRange, etag string, modtime time.Time) bool {
+	if strings.HasPrefix(ifRange, "\"") || isWeakETag(ifRange) {
+		return etag != "" && etagsMatch(ifRange, etag, true)
+	}
+
+	t, err := headers.ParseTime(ifRange)
+	if err != nil || modtime.IsZero() {
+		return false
+	}
+
+	return modtime.Truncate(time.Second).Equal(t)
+}
+
+func sumRanges(ranges []ByteRange) int64 {
+	var sum int64
+	for _, r := range ranges {
+		sum += r.Length
+	}
+
+	return sum
+}
+
+func writeSection(w *Writer, status StatusCode, content io.ReadSeeker, start, length int64) error {
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.SetHeader("Content-Length", strconv.FormatInt(length, 10))
+
+	if err := w.WriteStatusLine(status); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(w.Headers); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(w, content, length)
+
+	return err
+}
+
+func writeMultipartRanges(w *Writer, content io.ReadSeeker, contentType string, ranges []ByteRange, size int64) error {
+	// first pass only measures the multipart framing so Content-Length is known upfront
+	counter := &countingWriter{}
+	mw := multipart.NewWriter(counter)
+	for _, r := range ranges {
+		if _, err := mw.CreatePart(rangePartHeader(contentType, r, size)); err != nil {
+			return err
+		}
+
+		counter.n += r.Length
+	}
+
+	mw.Close()
+
+	w.SetHeader("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.SetHeader("Content-Length", strconv.FormatInt(counter.n, 10))
+
+	if err := w.WriteStatusLine(PartialContent); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(w.Headers); err != nil {
+		return err
+	}
+
+	body := multipart.NewWriter(w)
+	if err := body.SetBoundary(mw.Boundary()); err != nil {
+		return err
+	}
+
+	for _, r := range ranges {
+		part, err := body.CreatePart(rangePartHeader(contentType, r, size))
+		if err != nil {
+			return err
+		}
+
+		if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(part, content, r.Length); err != nil {
+			return err
+		}
+	}
+
+	return body.Close()
+}
+
+func rangePartHeader(contentType string, r ByteRange, size int64) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{
+		"Content-Range": {r.ContentRange(size)},
+	}
+
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return header
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,137 @@
+package response
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRangeRequest(h map[string]string) *request.Request {
+	reqHeaders := headers.NewHeaders()
+	for k, v := range h {
+		reqHeaders.Set(k, v)
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/video", HttpVersion: "HTTP/1.1"},
+		Headers:     reqHeaders,
+	}
+}
+
+func TestParseRangeSingle(t *testing.T) {
+	ranges, err := ParseRange("bytes=0-499", 1000)
+
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 500}}, ranges)
+}
+
+func TestParseRangeMultipleAndSuffix(t *testing.T) {
+	ranges, err := ParseRange("bytes=0-0, 500-, -100", 1000)
+
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1}, {Start: 500, Length: 500}, {Start: 900, Length: 100}}, ranges)
+}
+
+func TestParseRangeClampsEnd(t *testing.T) {
+	ranges, err := ParseRange("bytes=900-5000", 1000)
+
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 900, Length: 100}}, ranges)
+}
+
+func TestParseRangeInvalid(t *testing.T) {
+	for _, value := range []string{"items=0-1", "bytes=5-1", "bytes=abc", "bytes=", "bytes=--1"} {
+		_, err := ParseRange(value, 1000)
+
+		require.ErrorIs(t, err, ErrInvalidRange, value)
+	}
+}
+
+func TestParseRangeNotSatisfiable(t *testing.T) {
+	_, err := ParseRange("bytes=1000-2000", 1000)
+
+	require.ErrorIs(t, err, ErrRangeNotSatisfiable)
+}
+
+func TestServeContentFull(t *testing.T) {
+	w := NewWriter()
+	req := newRangeRequest(nil)
+
+	err := ServeContent(w, req, "text/plain", time.Time{}, strings.NewReader("hello world"))
+
+	require.NoError(t, err)
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "accept-ranges: bytes\r\n")
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+}
+
+func TestServeContentSingleRange(t *testing.T) {
+	w := NewWriter()
+	req := newRangeRequest(map[string]string{"Range": "bytes=6-"})
+
+	err := ServeContent(w, req, "text/plain", time.Time{}, strings.NewReader("hello world"))
+
+	require.NoError(t, err)
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 6-10/11\r\n")
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nworld"))
+}
+
+func TestServeContentMultipleRanges(t *testing.T) {
+	w := NewWriter()
+	req := newRangeRequest(map[string]string{"Range": "bytes=0-4, -5"})
+
+	err := ServeContent(w, req, "text/plain", time.Time{}, strings.NewReader("hello world"))
+
+	require.NoError(t, err)
+
+	out := string(w.Body)
+	head, body, found := strings.Cut(out, "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, head, "content-type: multipart/byteranges; boundary=")
+	assert.Contains(t, body, "Content-Range: bytes 0-4/11\r\n")
+	assert.Contains(t, body, "Content-Range: bytes 6-10/11\r\n")
+	assert.Contains(t, head+"\r\n", "content-length: "+strconv.Itoa(len(body))+"\r\n")
+}
+
+func TestServeContentNotSatisfiable(t *testing.T) {
+	w := NewWriter()
+	req := newRangeRequest(map[string]string{"Range": "bytes=100-"})
+
+	err := ServeContent(w, req, "text/plain", time.Time{}, strings.NewReader("hello world"))
+
+	require.NoError(t, err)
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */11\r\n")
+}
+
+func TestServeContentIfRange(t *testing.T) {
+	modtime := time.Date(2025, 4, 6, 12, 0, 0, 0, time.UTC)
+
+	w := NewWriter()
+	req := newRangeRequest(map[string]string{"Range": "bytes=0-4", "If-Range": headers.FormatTime(modtime)})
+	require.NoError(t, ServeContent(w, req, "text/plain", modtime, strings.NewReader("hello world")))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 206 Partial Content\r\n"))
+
+	w = NewWriter()
+	req = newRangeRequest(map[string]string{"Range": "bytes=0-4", "If-Range": headers.FormatTime(modtime.Add(-time.Hour))})
+	require.NoError(t, ServeContent(w, req, "text/plain", modtime, strings.NewReader("hello world")))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 200 OK\r\n"))
+
+	w = NewWriter()
+	w.SetHeader("ETag", `"v1"`)
+	req = newRangeRequest(map[string]string{"Range": "bytes=0-4", "If-Range": `"v1"`})
+	require.NoError(t, ServeContent(w, req, "text/plain", modtime, strings.NewReader("hello world")))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 206 Partial Content\r\n"))
+}
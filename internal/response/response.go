@@ -1,7 +1,11 @@
 package response
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"strconv"
 	"strings"
 
@@ -11,9 +15,62 @@ import (
 type StatusCode int
 
 const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
+	SwitchingProtocols   StatusCode = 101
+	OK                   StatusCode = 200
+	PartialContent       StatusCode = 206
+	MovedPermanently     StatusCode = 301
+	NotModified          StatusCode = 304
+	BadRequest           StatusCode = 400
+	Forbidden            StatusCode = 403
+	NotFound             StatusCode = 404
+	MethodNotAllowed     StatusCode = 405
+	NotAcceptable        StatusCode = 406
+	PreconditionFailed   StatusCode = 412
+	ContentTooLarge      StatusCode = 413
+	UnsupportedMediaType StatusCode = 415
+	RangeNotSatisfiable  StatusCode = 416
+	UpgradeRequired      StatusCode = 426
+	InternalServerError  StatusCode = 500
+	NotImplemented       StatusCode = 501
+	BadGateway           StatusCode = 502
+	ServiceUnavailable   StatusCode = 503
+	GatewayTimeout       StatusCode = 504
+)
+
+var statusText = map[StatusCode]string{
+	SwitchingProtocols:   "Switching Protocols",
+	OK:                   "OK",
+	PartialContent:       "Partial Content",
+	MovedPermanently:     "Moved Permanently",
+	NotModified:          "Not Modified",
+	BadRequest:           "Bad Request",
+	Forbidden:            "Forbidden",
+	NotFound:             "Not Found",
+	MethodNotAllowed:     "Method Not Allowed",
+	NotAcceptable:        "Not Acceptable",
+	PreconditionFailed:   "Precondition Failed",
+	ContentTooLarge:      "Content Too Large",
+	UnsupportedMediaType: "Unsupported Media Type",
+	RangeNotSatisfiable:  "Range Not Satisfiable",
+	UpgradeRequired:      "Upgrade Required",
+	InternalServerError:  "Internal Server Error",
+	NotImplemented:       "Not Implemented",
+	BadGateway:           "Bad Gateway",
+	ServiceUnavailable:   "Service Unavailable",
+	GatewayTimeout:       "Gateway Timeout",
+}
+
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
+const (
+	copyBufferSize = 32 * 1024
+)
+
+var (
+	ErrNotHijackable = errors.New("error: response writer does not support hijacking")
+	ErrHijacked      = errors.New("error: connection has been hijacked")
 )
 
 type Writer struct {
@@ -21,6 +78,20 @@ type Writer struct {
 	Headers    headers.Headers
 	Body       []byte
 	State      int
+
+	conn      io.Writer
+	defaults  headers.Headers
+	cookies   []*headers.Cookie
+	added     []headerLine
+	chunked   bool
+	bodyDone  bool
+	committed bool
+
+	closeNotify func() <-chan struct{}
+	closed      <-chan struct{}
+
+	hijack   func() (net.Conn, *bufio.ReadWriter, error)
+	hijacked bool
 }
 
 func NewWriter() *Writer {
@@ -31,6 +102,21 @@ func NewWriter() *Writer {
 	}
 }
 
+// NewConnWriter returns a Writer whose buffered output is sent to conn on Flush and
+// whose ReadFrom streams identity bodies straight to it
+func NewConnWriter(conn io.Writer) *Writer {
+	w := NewWriter()
+	w.conn = conn
+
+	return w
+}
+
+// SetDefaultHeaders registers headers that WriteHeaders adds to the response
+// whenever the written headers do not already contain them
+func (w *Writer) SetDefaultHeaders(h headers.Headers) {
+	w.defaults = h
+}
+
 func (w *Writer) SetHeader(key, value string) {
 	if w.State >= 2 {
 		fmt.Println("Warning: Attempted to modify headers after they were written")
@@ -40,24 +126,44 @@ func (w *Writer) SetHeader(key, value string) {
 	w.Headers.Set(key, value)
 }
 
+// SetCookie queues a Set-Cookie header, each cookie is written on its own line
+func (w *Writer) SetCookie(cookie *headers.Cookie) error {
+	if w.State >= 2 {
+		return fmt.Errorf("error: headers already written")
+	}
+
+	if err := cookie.Valid(); err != nil {
+		return err
+	}
+
+	w.cookies = append(w.cookies, cookie)
+
+	return nil
+}
+
+// AddHeader queues a header line next to Headers, for fields that may not be combined
+// into one comma separated value, such as Set-Cookie lines passed on by a proxy
+func (w *Writer) AddHeader(key, value string) {
+	if w.State >= 2 {
+		fmt.Println("Warning: Attempted to modify headers after they were written")
+		return
+	}
+
+	w.added = append(w.added, headerLine{key: key, value: value})
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.State != 0 {
 		return fmt.Errorf("error: status line already written")
 	}
 
-	var statusLine string
-
-	switch {
-	case statusCode == OK:
-		statusLine = "HTTP/1.1 200 OK\r\n"
-	case statusCode == BadRequest:
-		statusLine = "HTTP/1.1 400 Bad Request\r\n"
-	case statusCode == InternalServerError:
-		statusLine = "HTTP/1.1 500 Internal Server Error\r\n"
-	default:
-		statusLine = "HTTP/1.1 500 Internal Server Error\r\n"
+	if statusCode < 100 || statusCode > 999 {
+		statusCode = InternalServerError
 	}
 
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+
+	w.StatusCode = statusCode
 	w.Body = append(w.Body, []byte(statusLine)...)
 	w.State = 1
 
@@ -71,9 +177,27 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 
 	var headerStr strings.Builder
 	for k, v := range headers {
+		if strings.EqualFold(k, "Transfer-Encoding") && strings.EqualFold(v, "chunked") {
+			w.chunked = true
+		}
+
 		headerStr.WriteString(k + ": " + v + "\r\n")
 	}
 
+	for k, v := range w.defaults {
+		if !hasHeader(headers, k) {
+			headerStr.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+
+	for _, line := range w.added {
+		headerStr.WriteString(line.key + ": " + line.value + "\r\n")
+	}
+
+	for _, cookie := range w.cookies {
+		headerStr.WriteString("Set-Cookie: " + cookie.String() + "\r\n")
+	}
+
 	headerStr.WriteString("\r\n")
 
 	w.Body = append(w.Body, []byte(headerStr.String())...)
@@ -104,16 +228,25 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	finalChunk := []byte("0\r\n\r\n")
 	w.Body = append(w.Body, finalChunk...)
+	w.bodyDone = true
 
 	return len(w.Body), nil
 }
 
+// WriteTrailers ends a chunked body with the last chunk followed by the trailer fields,
+// it replaces WriteChunkedBodyDone
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.State < 2 {
 		return fmt.Errorf("error: headers not written yet")
 	}
 
+	if w.bodyDone {
+		return fmt.Errorf("error: chunked body already ended, trailers must replace WriteChunkedBodyDone")
+	}
+
 	var trailerStr strings.Builder
+	trailerStr.WriteString("0\r\n")
+
 	for k, v := range h {
 		trailerStr.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
 	}
@@ -122,18 +255,20 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 
 	w.Body = append(w.Body, []byte(trailerStr.String())...)
 	w.State = 3
+	w.bodyDone = true
 
 	return nil
 }
 
 func (w *Writer) Write(p []byte) (int, error) {
-	if w.State != 1 {
-		return 0, fmt.Errorf("error: body already written")
-
+	if w.State == 0 {
+		return 0, fmt.Errorf("error: status line not written yet")
 	}
 
 	if w.State < 2 {
-		w.WriteHeaders(w.Headers)
+		if err := w.WriteHeaders(w.Headers); err != nil {
+			return 0, err
+		}
 	}
 
 	w.Body = append(w.Body, p...)
@@ -142,12 +277,171 @@ func (w *Writer) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// ReadFrom copies r into the response body. Identity bodies are written directly to the
+// connection so a *net.TCPConn can use sendfile/splice when r is backed by an *os.File;
+// chunked bodies, or writers without a connection, are copied through a buffer.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.State == 0 {
+		return 0, fmt.Errorf("error: status line not written yet")
+	}
+
+	if w.State < 2 {
+		if err := w.WriteHeaders(w.Headers); err != nil {
+			return 0, err
+		}
+	}
+
+	w.State = 3
+
+	if w.chunked {
+		return w.copyChunked(r)
+	}
+
+	if w.conn == nil {
+		return io.Copy(bodyWriter{w}, r)
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w.conn, r)
+}
+
+func (w *Writer) copyChunked(r io.Reader) (int64, error) {
+	buf := make([]byte, copyBufferSize)
+	var total int64
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			w.WriteChunkedBody(buf[:n])
+			total += int64(n)
+
+			if flushErr := w.Flush(); flushErr != nil {
+				return total, flushErr
+			}
+		}
+
+		if err == io.EOF {
+			return total, nil
+		}
+
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Flush sends everything buffered so far to the connection, it is a no-op for writers
+// created with NewWriter
+func (w *Writer) Flush() error {
+	if w.conn == nil || len(w.Body) == 0 {
+		return nil
+	}
+
+	w.committed = true
+
+	_, err := w.conn.Write(w.Body)
+	w.Body = w.Body[:0]
+
+	return err
+}
+
+// Committed reports whether part of the response already reached the connection,
+// after which it can no longer be replaced by an error response
+func (w *Writer) Committed() bool {
+	return w.committed
+}
+
+// SetCloseNotify installs the function CloseNotify uses to watch the connection,
+// it is called at most once
+func (w *Writer) SetCloseNotify(closeNotify func() <-chan struct{}) {
+	w.closeNotify = closeNotify
+}
+
+// CloseNotify returns a channel that is closed once the client goes away. Writers
+// without a connection return a nil channel, which never fires.
+func (w *Writer) CloseNotify() <-chan struct{} {
+	if w.closed == nil && w.closeNotify != nil {
+		w.closed = w.closeNotify()
+	}
+
+	return w.closed
+}
+
+// SetHijacker installs the function Hijack uses to take the connection away from the server
+func (w *Writer) SetHijacker(hijack func() (net.Conn, *bufio.ReadWriter, error)) {
+	w.hijack = hijack
+}
+
+// Hijack flushes what has been written so far and hands the connection to the caller,
+// who is then responsible for closing it. The reader of the returned ReadWriter starts
+// with bytes the server already read past the request, so it should be read from
+// instead of the connection. The Writer must not be used afterwards.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+
+	if w.hijack == nil {
+		return nil, nil, ErrNotHijackable
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	conn, rw, err := w.hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.hijacked = true
+	w.committed = true
+
+	return conn, rw, nil
+}
+
+// Hijacked reports whether Hijack took over the connection
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// hasHeader looks key up case-insensitively, h may come from a literal map
+// that was not built through Headers.Set
+func hasHeader(h headers.Headers, key string) bool {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type headerLine struct {
+	key   string
+	value string
+}
+
+// bodyWriter appends to the buffered body without going through Writer.ReadFrom again
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	b.w.Body = append(b.w.Body, p...)
+	return len(p), nil
+}
+
 func GetDefaultHeaders(contentLen int, contentType string) headers.Headers {
 	contentLength := strconv.Itoa(contentLen)
 
-	return headers.Headers{
-		"Content-Type":   contentType,
-		"Content-Length": contentLength,
-		"Connection":     "close",
-	}
+	h := headers.Headers{}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", contentLength)
+	h.Set("Connection", "close")
+
+	return h
 }
@@ -0,0 +1,132 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFromFileOverTCP(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+
+		defer conn.Close()
+
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	w := NewConnWriter(conn)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(content), "application/octet-stream")))
+
+	n, err := w.ReadFrom(file)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	assert.True(t, w.Committed())
+	assert.Empty(t, w.Body)
+
+	require.NoError(t, conn.Close())
+
+	data := <-received
+	head, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	require.True(t, found)
+	assert.True(t, bytes.HasPrefix(head, []byte("HTTP/1.1 200 OK\r\n")))
+	assert.Equal(t, content, body)
+}
+
+func TestReadFromChunkedFallback(t *testing.T) {
+	var conn bytes.Buffer
+
+	w := NewConnWriter(&conn)
+	require.NoError(t, w.WriteStatusLine(OK))
+	w.SetHeader("Transfer-Encoding", "chunked")
+
+	n, err := w.ReadFrom(strings.NewReader("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+
+	w.WriteChunkedBodyDone()
+	require.NoError(t, w.Flush())
+
+	assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\nb\r\nhello world\r\n0\r\n\r\n"))
+}
+
+func TestWriteTrailersEndsChunkedBody(t *testing.T) {
+	w := NewWriter()
+	require.NoError(t, w.WriteStatusLine(OK))
+	w.SetHeader("Trailer", "X-Checksum")
+	w.AddHeader("Set-Cookie", "a=1")
+	w.AddHeader("Set-Cookie", "b=2")
+
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"X-Checksum": "abc"}))
+
+	out := string(w.Body)
+	assert.Contains(t, out, "Set-Cookie: a=1\r\nSet-Cookie: b=2\r\n")
+	assert.True(t, strings.HasSuffix(out, "5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n"), out)
+
+	w = NewWriter()
+	require.NoError(t, w.WriteStatusLine(OK))
+	w.WriteChunkedBody([]byte("hello"))
+	w.WriteChunkedBodyDone()
+	require.Error(t, w.WriteTrailers(headers.Headers{"X-Checksum": "abc"}))
+}
+
+func TestReadFromWithoutConnBuffers(t *testing.T) {
+	w := NewWriter()
+	require.NoError(t, w.WriteStatusLine(OK))
+
+	_, err := w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	assert.False(t, w.Committed())
+	assert.True(t, strings.HasSuffix(string(w.Body), "\r\n\r\nhello"))
+}
+
+func TestSetCookieWritesSeparateLines(t *testing.T) {
+	w := NewWriter()
+
+	require.NoError(t, w.SetCookie(&headers.Cookie{Name: "a", Value: "1", HttpOnly: true}))
+	require.NoError(t, w.SetCookie(&headers.Cookie{Name: "b", Value: "2", Path: "/"}))
+	require.Error(t, w.SetCookie(&headers.Cookie{Name: "bad name", Value: "3"}))
+
+	require.NoError(t, w.WriteStatusLine(OK))
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+
+	out := string(w.Body)
+	assert.Contains(t, out, "\r\nSet-Cookie: a=1; HttpOnly\r\n")
+	assert.Contains(t, out, "\r\nSet-Cookie: b=2; Path=/\r\n")
+	assert.Error(t, w.SetCookie(&headers.Cookie{Name: "c", Value: "4"}))
+}
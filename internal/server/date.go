@@ -0,0 +1,33 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+)
+
+type cachedDate struct {
+	second int64
+	value  string
+}
+
+var currentDate atomic.Pointer[cachedDate]
+
+// httpDate formats the Date header value at most once per second
+func httpDate(now time.Time) string {
+	second := now.Unix()
+
+	if date := currentDate.Load(); date != nil && date.second == second {
+		return date.value
+	}
+
+	date := &cachedDate{
+		second: second,
+		value:  headers.FormatTime(now),
+	}
+
+	currentDate.Store(date)
+
+	return date.value
+}
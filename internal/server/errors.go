@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+// RequestError maps errors returned while reading request content, such as
+// Request.ParseForm, to the matching client error response
+func RequestError(err error) *HandlerError {
+	if err == nil {
+		return nil
+	}
+
+	status := response.BadRequest
+
+	switch {
+	case errors.Is(err, request.ErrUnsupportedMediaType):
+		status = response.UnsupportedMediaType
+	case errors.Is(err, request.ErrFormTooLarge):
+		status = response.ContentTooLarge
+	}
+
+	return &HandlerError{
+		Message: err.Error(),
+		Status:  status,
+		Cause:   err,
+	}
+}
+
+// ErrorRenderer writes the response for a HandlerError, req is nil when the
+// request could not be parsed
+type ErrorRenderer func(w *response.Writer, req *request.Request, handlerErr *HandlerError) error
+
+var errorContentTypes = []string{"text/html", "application/problem+json", "application/json", "text/plain"}
+
+var errorPage = template.Must(template.New("error").Parse(`<html>
+<head><title>{{.Status}} {{.Title}}</title></head>
+<body><h1>{{.Title}}</h1><p>{{.Message}}</p></body>
+</html>
+`))
+
+type errorPageData struct {
+	Status  int
+	Title   string
+	Message string
+}
+
+// HTMLErrorRenderer renders errors with tmpl, which receives the Status, Title and
+// Message fields. A nil tmpl uses the built-in error page.
+func HTMLErrorRenderer(tmpl *template.Template) ErrorRenderer {
+	if tmpl == nil {
+		tmpl = errorPage
+	}
+
+	return func(w *response.Writer, req *request.Request, handlerErr *HandlerError) error {
+		status := handlerErr.StatusCode()
+
+		var body bytes.Buffer
+		err := tmpl.Execute(&body, errorPageData{
+			Status:  int(status),
+			Title:   response.StatusText(status),
+			Message: handlerErr.Message,
+		})
+		if err != nil {
+			return err
+		}
+
+		return writeBody(w, status, "text/html; charset=utf-8", body.Bytes())
+	}
+}
+
+func PlainTextErrorRenderer(w *response.Writer, req *request.Request, handlerErr *HandlerError) error {
+	status := handlerErr.StatusCode()
+	body := fmt.Sprintf("%d %s\n", status, response.StatusText(status))
+
+	if handlerErr.Message != "" {
+		body += handlerErr.Message + "\n"
+	}
+
+	return writeBody(w, status, "text/plain; charset=utf-8", []byte(body))
+}
+
+func ProblemJSONErrorRenderer(w *response.Writer, req *request.Request, handlerErr *HandlerError) error {
+	return WriteProblem(w, handlerErr)
+}
+
+// NegotiatedErrorRenderer picks HTML, problem+json or plain text from the Accept header,
+// HTML is used when the client accepts none of them
+func NegotiatedErrorRenderer(w *response.Writer, req *request.Request, handlerErr *HandlerError) error {
+	contentType := "text/html"
+	if req != nil {
+		if offer, ok := headers.BestMediaType(req.Headers.Get("Accept"), errorContentTypes); ok {
+			contentType = offer
+		}
+	}
+
+	switch contentType {
+	case "application/problem+json", "application/json":
+		return ProblemJSONErrorRenderer(w, req, handlerErr)
+	case "text/plain":
+		return PlainTextErrorRenderer(w, req, handlerErr)
+	default:
+		return HTMLErrorRenderer(nil)(w, req, handlerErr)
+	}
+}
+
+// renderError adds the HandlerError headers and either writes a custom content type
+// verbatim or hands over to the renderer
+func renderError(w *response.Writer, req *request.Request, handlerErr *HandlerError, renderer ErrorRenderer) error {
+	for k, v := range handlerErr.Headers {
+		w.SetHeader(k, v)
+	}
+
+	if handlerErr.ContentType != "" {
+		return writeBody(w, handlerErr.StatusCode(), handlerErr.ContentType, []byte(handlerErr.Message))
+	}
+
+	return renderer(w, req, handlerErr)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(method, target string, h map[string]string) *request.Request {
+	reqHeaders := headers.NewHeaders()
+	for k, v := range h {
+		reqHeaders.Set(k, v)
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "HTTP/1.1"},
+		Headers:     reqHeaders,
+	}
+}
+
+func newTestRoot(t *testing.T) string {
+	root := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello world"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "noext"), []byte("<html><body>hi</body></html>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>index</h1>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "files"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "files", "<a>.txt"), []byte("a"), 0o644))
+
+	return root
+}
+
+func TestFileServerServesFile(t *testing.T) {
+	handler := FileServer(newTestRoot(t))
+	w := response.NewWriter()
+
+	handlerErr := handler(w, newTestRequest("GET", "/hello.txt", nil))
+
+	require.Nil(t, handlerErr)
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "last-modified: ")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+}
+
+func TestFileServerSniffsContentType(t *testing.T) {
+	handler := FileServer(newTestRoot(t))
+	w := response.NewWriter()
+
+	handlerErr := handler(w, newTestRequest("GET", "/noext", nil))
+
+	require.Nil(t, handlerErr)
+	assert.Contains(t, string(w.Body), "content-type: text/html; charset=utf-8\r\n")
+}
+
+func TestFileServerRange(t *testing.T) {
+	handler := FileServer(newTestRoot(t))
+	w := response.NewWriter()
+
+	handlerErr := handler(w, newTestRequest("GET", "/hello.txt", map[string]string{"Range": "bytes=0-4"}))
+
+	require.Nil(t, handlerErr)
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+}
+
+func TestFileServerPathTraversal(t *testing.T) {
+	handler := FileServer(newTestRoot(t))
+
+	for _, target := range []string{"/../etc/passwd", "/%2e%2e/etc/passwd", "/site/../../etc/passwd"} {
+		handlerErr := handler(response.NewWriter(), newTestRequest("GET", target, nil))
+
+		require.NotNil(t, handlerErr, target)
+		assert.Equal(t, response.BadRequest, handlerErr.Status, target)
+	}
+}
+
+func TestFileServerSymlinkEscape(t *testing.T) {
+	root := newTestRoot(t)
+	require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(root, "escape")))
+
+	handlerErr := FileServer(root)(response.NewWriter(), newTestRequest("GET", "/escape/", nil))
+
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, response.NotFound, handlerErr.Status)
+}
+
+func TestFileServerNotFound(t *testing.T) {
+	handlerErr := FileServer(newTestRoot(t))(response.NewWriter(), newTestRequest("GET", "/missing.txt", nil))
+
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, response.NotFound, handlerErr.Status)
+}
+
+func TestFileServerIndexAndRedirect(t *testing.T) {
+	handler := FileServer(newTestRoot(t), WithPrefix("/static/"))
+
+	w := response.NewWriter()
+	require.Nil(t, handler(w, newTestRequest("GET", "/static/site", nil)))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, string(w.Body), "location: /static/site/\r\n")
+
+	w = response.NewWriter()
+	require.Nil(t, handler(w, newTestRequest("GET", "/static/site/", nil)))
+	assert.True(t, strings.HasSuffix(string(w.Body), "<h1>index</h1>"))
+}
+
+func TestFileServerDirectoryListing(t *testing.T) {
+	root := newTestRoot(t)
+
+	handlerErr := FileServer(root)(response.NewWriter(), newTestRequest("GET", "/files/", nil))
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, response.Forbidden, handlerErr.Status)
+
+	w := response.NewWriter()
+	require.Nil(t, FileServer(root, WithDirectoryListing())(w, newTestRequest("GET", "/files/", nil)))
+	assert.Contains(t, string(w.Body), "<a href=\"../\">../</a>")
+	assert.Contains(t, string(w.Body), "&lt;a&gt;.txt</a>")
+}
+
+func TestFileServerNotModified(t *testing.T) {
+	handler := FileServer(newTestRoot(t))
+
+	w := response.NewWriter()
+	require.Nil(t, handler(w, newTestRequest("GET", "/hello.txt", nil)))
+
+	etag := ""
+	for line := range strings.SplitSeq(string(w.Body), "\r\n") {
+		if value, found := strings.CutPrefix(line, "etag: "); found {
+			etag = value
+		}
+	}
+
+	require.NotEmpty(t, etag)
+	assert.True(t, strings.HasPrefix(etag, "W/"), etag)
+
+	w = response.NewWriter()
+	require.Nil(t, handler(w, newTestRequest("GET", "/hello.txt", map[string]string{"If-None-Match": etag})))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, string(w.Body), "hello world")
+
+	// a weak validator cannot vouch for a range, the whole file is sent instead
+	w = response.NewWriter()
+	require.Nil(t, handler(w, newTestRequest("GET", "/hello.txt", map[string]string{"Range": "bytes=0-4", "If-Range": etag})))
+	assert.True(t, strings.HasPrefix(string(w.Body), "HTTP/1.1 200 OK\r\n"), string(w.Body))
+	assert.Contains(t, string(w.Body), "hello world")
+}
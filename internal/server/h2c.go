@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/http2"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+// WithH2C serves HTTP/2 without TLS next to HTTP/1.1, to clients that start with the
+// HTTP/2 preface and to requests that ask for it with "Upgrade: h2c". Every stream is
+// handed to the Handler as a request of its own.
+func WithH2C(options ...http2.Option) Option {
+	return func(s *Server) {
+		s.h2c = true
+		s.h2cOptions = options
+	}
+}
+
+// prefixConn replays bytes that were read to tell the protocols apart
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// ReadFrom writes to the underlying conn, which keeps sendfile and splice available to
+// Writer.ReadFrom, the replayed bytes only concern reads
+func (c *prefixConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+
+	return io.Copy(c.Conn, r)
+}
+
+// sniffPreface reads from conn until the HTTP/2 preface is either complete or ruled out.
+// The returned conn reads those bytes again.
+func sniffPreface(conn net.Conn) (net.Conn, bool) {
+	buf := make([]byte, len(http2.Preface))
+	n := 0
+
+	for n < len(buf) {
+		m, err := conn.Read(buf[n:])
+		n += m
+
+		if !strings.HasPrefix(http2.Preface, string(buf[:n])) || err != nil {
+			break
+		}
+	}
+
+	replay := &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(buf[:n]), conn)}
+
+	return replay, string(buf[:n]) == http2.Preface
+}
+
+// http2Options are those given to WithH2C, with bodies streamed like the server's own
+func (s *Server) http2Options() []http2.Option {
+	options := slices.Clone(s.h2cOptions)
+	if s.streamBodies {
+		options = append(options, http2.WithStreamingBodies())
+	}
+
+	return options
+}
+
+func (s *Server) serveHTTP2(conn net.Conn) {
+	if err := http2.ServeConn(conn, s.serveStream, s.http2Options()...); err != nil {
+		fmt.Println("error: HTTP/2:", err)
+	}
+}
+
+// upgradeSettings returns the settings sent with a request that asks to switch to h2c,
+// RFC 7540 section 3.2. Requests that do not qualify are answered over HTTP/1.1.
+func upgradeSettings(req *request.Request, streamBodies bool) ([]http2.Setting, bool) {
+	if !headers.HasToken(req.Headers.Get("Connection"), "upgrade") || !headers.HasToken(req.Headers.Get("Upgrade"), "h2c") {
+		return nil, false
+	}
+
+	// a streamed body is still on the connection, where the frames are about to start
+	if streamBodies && (req.Headers.Get("Content-Length") != "" || req.Headers.Exists("Transfer-Encoding")) {
+		return nil, false
+	}
+
+	settings, err := http2.ParseUpgradeSettings(req.Headers.Get("HTTP2-Settings"))
+	if err != nil {
+		return nil, false
+	}
+
+	return settings, true
+}
+
+func (s *Server) upgradeHTTP2(conn net.Conn, req *request.Request, settings []http2.Setting) {
+	if buffered := req.Buffered(); len(buffered) > 0 {
+		conn = &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(buffered), conn)}
+	}
+
+	if err := http2.ServeUpgrade(conn, req, settings, s.serveStream, s.http2Options()...); err != nil {
+		fmt.Println("error: HTTP/2:", err)
+	}
+}
+
+// serveStream answers one HTTP/2 stream with the Handler, the response is written as
+// HTTP/1.1 and translated into frames by the http2 package
+func (s *Server) serveStream(w io.Writer, req *request.Request, closed <-chan struct{}) {
+	writer := response.NewConnWriter(w)
+	writer.SetDefaultHeaders(s.defaultHeaders())
+	writer.SetCloseNotify(func() <-chan struct{} {
+		return closed
+	})
+
+	handlerErr := s.Handler(writer, req)
+	releaseRequest(req)
+
+	s.finish(w, writer, req, handlerErr)
+}
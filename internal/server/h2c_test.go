@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/http2"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// describe answers with the request line, or the body of requests that have one
+func describe(w *response.Writer, req *request.Request) *HandlerError {
+	body := []byte(fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion))
+	if len(req.Body) > 0 {
+		body = req.Body
+	}
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+	w.Write(body)
+
+	return nil
+}
+
+// countingListener counts the connections it accepts
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+
+	return conn, err
+}
+
+func startH2CServer(t *testing.T, handler Handler, options ...Option) (*countingListener, *http.Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	counting := &countingListener{Listener: l}
+
+	server, err := ServeListener(counting, handler, append(options, WithH2C())...)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	transport := &http.Transport{Protocols: &protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return counting, &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}
+
+func TestH2CPriorKnowledgeMultiplexesRequests(t *testing.T) {
+	l, client := startH2CServer(t, describe)
+	url := "http://" + l.Addr().String()
+
+	// the first request establishes the connection the others share
+	resp, err := client.Get(url + "/first")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resp, err := client.Get(fmt.Sprintf("%s/r/%d", url, i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("GET /r/%d HTTP/2.0", i), string(body))
+			assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+			assert.Equal(t, DefaultServerName, resp.Header.Get("Server"))
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(1), l.accepted.Load())
+}
+
+func TestH2CLargeBodies(t *testing.T) {
+	l, client := startH2CServer(t, describe)
+
+	// larger than the default windows in both directions
+	body := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	resp, err := client.Post("http://"+l.Addr().String()+"/upload", "application/octet-stream", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	received, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, body, received)
+}
+
+func TestH2CHandlerErrors(t *testing.T) {
+	l, client := startH2CServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{Status: response.NotFound, Message: "no such thing"}
+	})
+
+	resp, err := client.Get("http://" + l.Addr().String() + "/missing")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, string(body), "no such thing")
+}
+
+func TestH2CStreamingAndTrailers(t *testing.T) {
+	l, client := startH2CServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.SetHeader("Content-Type", "text/plain")
+		w.SetHeader("Trailer", "X-Count")
+
+		for i := range 3 {
+			w.WriteChunkedBody([]byte(fmt.Sprintf("part %d\n", i)))
+		}
+
+		w.WriteTrailers(headers.Headers{"x-count": "3"})
+
+		return nil
+	})
+
+	resp, err := client.Get("http://" + l.Addr().String())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "part 0\npart 1\npart 2\n", string(body))
+	assert.Equal(t, "3", resp.Trailer.Get("X-Count"))
+}
+
+func TestH2CStreamsRequestBodies(t *testing.T) {
+	l, client := startH2CServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.SetHeader("Content-Type", "text/plain")
+
+		// the first message is answered while the client is still sending
+		first := make([]byte, 4)
+		if _, err := io.ReadFull(req.BodyReader(), first); err != nil {
+			return &HandlerError{Status: response.BadRequest, Cause: err}
+		}
+
+		w.WriteChunkedBody([]byte("echo " + string(first)))
+		if err := w.Flush(); err != nil {
+			return &HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+
+		rest, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			return &HandlerError{Status: response.BadRequest, Cause: err}
+		}
+
+		w.WriteChunkedBody([]byte("echo " + string(rest)))
+		w.WriteChunkedBodyDone()
+
+		return nil
+	}, WithStreamingBodies())
+
+	pr, pw := io.Pipe()
+	go io.WriteString(pw, "ping")
+
+	req, err := http.NewRequest("POST", "http://"+l.Addr().String()+"/stream", pr)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	first := make([]byte, len("echo ping"))
+	_, err = io.ReadFull(resp.Body, first)
+	require.NoError(t, err)
+	assert.Equal(t, "echo ping", string(first))
+
+	io.WriteString(pw, "pong")
+	pw.Close()
+
+	rest, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "echo pong", string(rest))
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	server, err := ListenAndServe("127.0.0.1:0", describe, WithH2C())
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = io.WriteString(conn, "GET /upgraded HTTP/1.1\r\nHost: localhost\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAoAAAAAIAAAAA\r\n\r\n"+
+		http2.Preface)
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameSettings, 0, 0, nil))
+
+	br := bufio.NewReader(conn)
+
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+
+	for line := ""; line != "\r\n"; {
+		line, err = br.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	decoder := http2.NewDecoder(4096, 0)
+	var fields []http2.HeaderField
+	var body []byte
+
+	for {
+		f, err := http2.ReadFrame(br, 1<<14)
+		require.NoError(t, err)
+
+		if f.StreamID != 1 {
+			continue
+		}
+
+		switch f.Type {
+		case http2.FrameHeaders:
+			fields, err = decoder.Decode(f.Payload)
+			require.NoError(t, err)
+		case http2.FrameData:
+			body = append(body, f.Payload...)
+		}
+
+		if f.Flags.Has(http2.FlagEndStream) {
+			break
+		}
+	}
+
+	assert.Equal(t, http2.HeaderField{Name: ":status", Value: "200"}, fields[0])
+	assert.Equal(t, "GET /upgraded HTTP/2.0", string(body))
+}
+
+func TestH2CIsOffByDefault(t *testing.T) {
+	server, err := ListenAndServe("127.0.0.1:0", describe)
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAoAAAAAIAAAAA\r\n\r\n")
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(data), "GET / HTTP/1.1"))
+}
+
+// readFromConn records whether writes went through ReadFrom
+type readFromConn struct {
+	net.Conn
+	readFrom bool
+}
+
+func (c *readFromConn) ReadFrom(r io.Reader) (int64, error) {
+	c.readFrom = true
+	return io.Copy(c.Conn, r)
+}
+
+func TestPrefixConnKeepsReadFrom(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	inner := &readFromConn{Conn: server}
+	go client.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	conn, isHTTP2 := sniffPreface(inner)
+	require.False(t, isHTTP2)
+
+	rf, ok := conn.(io.ReaderFrom)
+	require.True(t, ok)
+
+	received := make(chan []byte, 1)
+	go func() {
+		data := make([]byte, len("body"))
+		io.ReadFull(client, data)
+		received <- data
+	}()
+
+	_, err := rf.ReadFrom(strings.NewReader("body"))
+	require.NoError(t, err)
+	assert.True(t, inner.readFrom)
+	assert.Equal(t, "body", string(<-received))
+
+	// the sniffed bytes are still read first
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", line)
+}
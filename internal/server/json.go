@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	MaxJSONBodySize = 1 << 20
+)
+
+// Problem is an RFC 9457 problem details object
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DecodeJSON decodes a single JSON value from the request body into v. Bodies over
+// MaxJSONBodySize, unknown fields and trailing data are rejected, failures come back
+// as a 400, 413 or 415 HandlerError ready to be returned from a handler.
+func DecodeJSON(req *request.Request, v any) *HandlerError {
+	contentType := req.Headers.Get("Content-Type")
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return RequestError(fmt.Errorf("%w: %q, expected application/json", request.ErrUnsupportedMediaType, contentType))
+	}
+
+	body, err := req.ReadBody(MaxJSONBodySize)
+	if errors.Is(err, request.ErrBodyTooLarge) {
+		return &HandlerError{
+			Message: err.Error(),
+			Status:  response.ContentTooLarge,
+		}
+	}
+
+	if err != nil {
+		return RequestError(err)
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &HandlerError{
+			Message: "error: request body must not be empty",
+			Status:  response.BadRequest,
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return &HandlerError{
+			Message: fmt.Sprintf("error: invalid JSON body: %v", err),
+			Status:  response.BadRequest,
+		}
+	}
+
+	// More stops at a stray '}' or ']', only a clean end of input means one value was sent
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return &HandlerError{
+			Message: "error: request body must contain a single JSON value",
+			Status:  response.BadRequest,
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON writes v as the whole response with the given status
+func WriteJSON(w *response.Writer, status response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeBody(w, status, "application/json", body)
+}
+
+// WriteProblem writes handlerErr as an application/problem+json response
+func WriteProblem(w *response.Writer, handlerErr *HandlerError) error {
+	status := handlerErr.StatusCode()
+
+	body, err := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  response.StatusText(status),
+		Status: int(status),
+		Detail: handlerErr.Message,
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeBody(w, status, "application/problem+json", body)
+}
+
+func writeBody(w *response.Writer, status response.StatusCode, contentType string, body []byte) error {
+	if err := w.WriteStatusLine(status); err != nil {
+		return err
+	}
+
+	for k, v := range response.GetDefaultHeaders(len(body), contentType) {
+		w.SetHeader(k, v)
+	}
+
+	_, err := w.Write(body)
+
+	return err
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPayload struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func newJSONRequest(contentType, body string) *request.Request {
+	req := newTestRequest("POST", "/items", map[string]string{"Content-Type": contentType})
+	req.Body = []byte(body)
+
+	return req
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var payload testPayload
+
+	handlerErr := DecodeJSON(newJSONRequest("application/json; charset=utf-8", `{"name":"vim","count":2}`), &payload)
+
+	require.Nil(t, handlerErr)
+	assert.Equal(t, testPayload{Name: "vim", Count: 2}, payload)
+
+	// trailing whitespace is not a second value
+	handlerErr = DecodeJSON(newJSONRequest("application/json", "{\"name\":\"vim\"}\r\n"), &payload)
+	require.Nil(t, handlerErr)
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		status      response.StatusCode
+	}{
+		{"text/plain", `{"name":"vim"}`, response.UnsupportedMediaType},
+		{"application/json", ``, response.BadRequest},
+		{"application/json", `{"name":`, response.BadRequest},
+		{"application/json", `{"name":"vim","extra":true}`, response.BadRequest},
+		{"application/json", `{"name":"vim"} {"name":"neovim"}`, response.BadRequest},
+		{"application/json", `{"name":"vim"}}`, response.BadRequest},
+		{"application/json", `{"name":"vim"}]`, response.BadRequest},
+		{"application/json", `{"name":"vim"} 1`, response.BadRequest},
+		{"application/merge-patch+json", `"` + strings.Repeat("a", MaxJSONBodySize) + `"`, response.ContentTooLarge},
+	}
+
+	for _, tt := range tests {
+		var payload testPayload
+
+		handlerErr := DecodeJSON(newJSONRequest(tt.contentType, tt.body), &payload)
+
+		require.NotNil(t, handlerErr, tt.body)
+		assert.Equal(t, tt.status, handlerErr.Status, tt.body)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	w := response.NewWriter()
+
+	require.NoError(t, WriteJSON(w, response.OK, testPayload{Name: "vim", Count: 2}))
+
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.Contains(t, out, "content-length: 24\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+`{"name":"vim","count":2}`))
+}
+
+func TestWriteProblem(t *testing.T) {
+	w := response.NewWriter()
+
+	require.NoError(t, WriteProblem(w, &HandlerError{Message: "missing name", Status: response.BadRequest}))
+
+	out := string(w.Body)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "content-type: application/problem+json\r\n")
+
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+
+	var problem Problem
+	require.NoError(t, json.Unmarshal([]byte(body), &problem))
+	assert.Equal(t, Problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "missing name"}, problem)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+var (
+	ErrServerClosed = errors.New("error: server closed")
+	ErrSocketInUse  = errors.New("error: unix socket is in use")
+	ErrNotSocket    = errors.New("error: file exists and is not a unix socket")
+)
+
+// ListenAndServe listens on the TCP address addr, e.g. "127.0.0.1:0". The port that was
+// chosen is reported in Port.
+func ListenAndServe(addr string, handler Handler, options ...Option) (*Server, error) {
+	server, err := New(handler, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := server.Listen(addr); err != nil {
+		fmt.Println("Error:", err)
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// ServeUnix listens on a Unix domain socket at path, see Server.ListenUnix
+func ServeUnix(path string, mode os.FileMode, handler Handler, options ...Option) (*Server, error) {
+	server, err := New(handler, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := server.ListenUnix(path, mode); err != nil {
+		fmt.Println("Error:", err)
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// ServeListener serves on a listener created by the caller, e.g. one inherited from
+// systemd. It is closed with the Server.
+func ServeListener(listener net.Listener, handler Handler, options ...Option) (*Server, error) {
+	server, err := New(handler, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := server.Serve(listener); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// Serve accepts connections on listener in the background until Close. It can be called
+// for any number of listeners, the first one becomes Listener. With WithTLS the
+// listener is wrapped to terminate TLS.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Closed.Load() {
+		return ErrServerClosed
+	}
+
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	s.listeners = append(s.listeners, listener)
+
+	if s.Listener == nil {
+		s.Listener = listener
+
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			s.Port = addr.Port
+		}
+	}
+
+	go s.listen(listener)
+
+	return nil
+}
+
+// Listen adds a listener on the TCP address addr
+func (s *Server) Listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Serve(listener); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// ListenUnix adds a listener on a Unix domain socket at path and sets the permissions of
+// the socket file to mode, connecting requires write permission. A socket left behind by
+// a process that is gone is replaced, one that still accepts connections is not. On Unix
+// the socket is created without permissions, so it is never connectable with a wider
+// mode. The file is removed on Close.
+func (s *Server) ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNotSocket, path)
+		}
+
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrSocketInUse, path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if err := s.Serve(listener); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Addrs returns the addresses of all listeners in the order they were added
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+
+	return addrs
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hello(w *response.Writer, req *request.Request) *HandlerError {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(5, "text/plain"))
+	w.Write([]byte("hello"))
+
+	return nil
+}
+
+func dialRoundTrip(t *testing.T, addr net.Addr) string {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestListenAndServeReportsChosenPort(t *testing.T) {
+	server, err := ListenAndServe("127.0.0.1:0", hello)
+	require.NoError(t, err)
+	defer server.Close()
+
+	assert.NotZero(t, server.Port)
+	assert.Equal(t, server.Listener.Addr(), server.Addrs()[0])
+	assert.True(t, strings.HasSuffix(dialRoundTrip(t, server.Listener.Addr()), "hello"))
+}
+
+func TestServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+
+	server, err := ServeUnix(path, 0o600, hello)
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	assert.True(t, strings.HasSuffix(dialRoundTrip(t, server.Listener.Addr()), "hello"))
+
+	_, err = ServeUnix(path, 0o600, hello)
+	assert.ErrorIs(t, err, ErrSocketInUse)
+
+	require.NoError(t, server.Close())
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServeUnixReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+
+	// a socket file without a process behind it, like after a crash
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := ServeUnix(path, 0o660, hello)
+	require.NoError(t, err)
+	defer server.Close()
+
+	assert.True(t, strings.HasSuffix(dialRoundTrip(t, server.Listener.Addr()), "hello"))
+}
+
+func TestServeUnixRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o644))
+
+	_, err := ServeUnix(path, 0o600, hello)
+	assert.ErrorIs(t, err, ErrNotSocket)
+}
+
+func TestServeOnSeveralListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := ServeListener(listener, hello)
+	require.NoError(t, err)
+
+	_, err = server.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = server.ListenUnix(filepath.Join(t.TempDir(), "s.sock"), 0o600)
+	require.NoError(t, err)
+
+	addrs := server.Addrs()
+	require.Len(t, addrs, 3)
+	assert.Equal(t, listener, server.Listener)
+
+	for _, addr := range addrs {
+		assert.True(t, strings.HasSuffix(dialRoundTrip(t, addr), "hello"), addr.String())
+	}
+
+	require.NoError(t, server.Close())
+
+	for _, addr := range addrs {
+		_, err := net.Dial(addr.Network(), addr.String())
+		assert.Error(t, err, addr.String())
+	}
+
+	assert.ErrorIs(t, server.Serve(listener), ErrServerClosed)
+}
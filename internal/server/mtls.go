@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+// LoadCertPool reads PEM encoded CA certificates, e.g. to verify client certificates
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("error: no certificates found in %s", name)
+		}
+	}
+
+	return pool, nil
+}
+
+// WithRequiredClientCerts rejects handshakes without a client certificate signed by pool
+func WithRequiredClientCerts(pool *x509.CertPool) TLSOption {
+	return func(config *tls.Config) {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	}
+}
+
+// WithOptionalClientCerts asks for a client certificate, one that is sent has to be
+// signed by pool but clients without one are let through
+func WithOptionalClientCerts(pool *x509.CertPool) TLSOption {
+	return func(config *tls.Config) {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+	}
+}
+
+// RequireClientCert only passes requests on to next whose verified client certificate
+// is accepted by allow, others get a 403
+func RequireClientCert(allow func(cert *x509.Certificate) bool, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		cert, err := req.PeerCertificate()
+		if err != nil {
+			return &HandlerError{Message: "Client certificate required", Status: response.Forbidden, Cause: err}
+		}
+
+		if !allow(cert) {
+			return &HandlerError{
+				Message:  "Client certificate not allowed",
+				Status:   response.Forbidden,
+				Internal: "denied " + cert.Subject.String(),
+			}
+		}
+
+		return next(w, req)
+	}
+}
+
+// AllowNames accepts certificates with one of names as DNS, email or URI SAN, e.g. a
+// SPIFFE ID, or as Common Name when the certificate has no SANs
+func AllowNames(names ...string) func(cert *x509.Certificate) bool {
+	return func(cert *x509.Certificate) bool {
+		sans := slices.Concat(cert.DNSNames, cert.EmailAddresses)
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+
+		if len(sans) == 0 {
+			return slices.Contains(names, cert.Subject.CommonName)
+		}
+
+		for _, san := range sans {
+			if slices.Contains(names, san) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a client certificate for commonName with the given SANs
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames []string, uris ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, raw := range uris {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		template.URIs = append(template.URIs, u)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func startMTLSServer(t *testing.T, handler Handler, options ...TLSOption) *Server {
+	certs, err := LoadCertificates(writeCert(t, t.TempDir(), "server", 1, "server.test"))
+	require.NoError(t, err)
+
+	server, err := ListenAndServe("127.0.0.1:0", handler, WithTLS(certs, options...))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return server
+}
+
+// mtlsGet sends a request with clientCert, which may be nil. With TLS 1.3 a rejected
+// certificate only surfaces once the client reads.
+func mtlsGet(t *testing.T, server *Server, clientCert *tls.Certificate) (string, error) {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if clientCert != nil {
+		config.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: server.test\r\n\r\n")); err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(conn)
+
+	return string(data), err
+}
+
+func whoami(w *response.Writer, req *request.Request) *HandlerError {
+	body := "anonymous"
+	if cert, err := req.PeerCertificate(); err == nil {
+		body = cert.Subject.CommonName
+	}
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+	w.Write([]byte(body))
+
+	return nil
+}
+
+func TestRequiredClientCerts(t *testing.T) {
+	ca := newTestCA(t)
+	server := startMTLSServer(t, whoami, WithRequiredClientCerts(ca.pool))
+
+	cert := ca.issue(t, "billing", []string{"billing.internal"})
+	out, err := mtlsGet(t, server, &cert)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nbilling"), out)
+
+	out, err = mtlsGet(t, server, nil)
+	assert.True(t, err != nil || out == "", out)
+
+	other := newTestCA(t).issue(t, "intruder", nil)
+	out, err = mtlsGet(t, server, &other)
+	assert.True(t, err != nil || out == "", out)
+}
+
+func TestOptionalClientCerts(t *testing.T) {
+	ca := newTestCA(t)
+	server := startMTLSServer(t, whoami, WithOptionalClientCerts(ca.pool))
+
+	out, err := mtlsGet(t, server, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nanonymous"), out)
+
+	cert := ca.issue(t, "billing", nil)
+	out, err = mtlsGet(t, server, &cert)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nbilling"), out)
+}
+
+func TestRequireClientCertAllowNames(t *testing.T) {
+	ca := newTestCA(t)
+	handler := RequireClientCert(AllowNames("billing.internal", "spiffe://corp/orders", "legacy"), whoami)
+	server := startMTLSServer(t, handler, WithOptionalClientCerts(ca.pool))
+
+	issue := func(commonName string, dnsNames []string, uris ...string) *tls.Certificate {
+		cert := ca.issue(t, commonName, dnsNames, uris...)
+		return &cert
+	}
+
+	cases := []struct {
+		name   string
+		cert   *tls.Certificate
+		status string
+	}{
+		{"dns san", issue("billing", []string{"billing.internal"}), "200"},
+		{"uri san", issue("orders", nil, "spiffe://corp/orders"), "200"},
+		{"common name without sans", issue("legacy", nil), "200"},
+		{"common name ignored with sans", issue("legacy", []string{"other.internal"}), "403"},
+		{"unknown", issue("reports", []string{"reports.internal"}), "403"},
+		{"no certificate", nil, "403"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := mtlsGet(t, server, tc.cert)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+" "), out)
+		})
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	name := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+
+	pool, err := LoadCertPool(name)
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(ca.pool))
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("not pem"), 0o600))
+
+	_, err = LoadCertPool(garbage)
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+// Negotiate returns the offered media type the client prefers according to its Accept
+// header, or a 406 Not Acceptable HandlerError when it accepts none of them
+func Negotiate(req *request.Request, offers ...string) (string, *HandlerError) {
+	return negotiate(req, "Accept", offers, headers.BestMediaType)
+}
+
+func NegotiateLanguage(req *request.Request, offers ...string) (string, *HandlerError) {
+	return negotiate(req, "Accept-Language", offers, headers.BestLanguage)
+}
+
+func NegotiateCharset(req *request.Request, offers ...string) (string, *HandlerError) {
+	return negotiate(req, "Accept-Charset", offers, headers.BestCharset)
+}
+
+func negotiate(req *request.Request, header string, offers []string, best func(string, []string) (string, bool)) (string, *HandlerError) {
+	if offer, ok := best(req.Headers.Get(header), offers); ok {
+		return offer, nil
+	}
+
+	return "", &HandlerError{
+		Message: fmt.Sprintf("none of the available representations match %s, available: %s", header, strings.Join(offers, ", ")),
+		Status:  response.NotAcceptable,
+	}
+}
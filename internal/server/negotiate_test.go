@@ -0,0 +1,26 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	req := newTestRequest("GET", "/items", map[string]string{"Accept": "application/json;q=0.5, text/html"})
+
+	offer, handlerErr := Negotiate(req, "application/json", "text/html")
+	require.Nil(t, handlerErr)
+	assert.Equal(t, "text/html", offer)
+
+	_, handlerErr = Negotiate(req, "image/png")
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, response.NotAcceptable, handlerErr.Status)
+
+	req = newTestRequest("GET", "/items", map[string]string{"Accept-Language": "el, en;q=0.5"})
+	offer, handlerErr = NegotiateLanguage(req, "en-GB", "el-GR")
+	require.Nil(t, handlerErr)
+	assert.Equal(t, "el-GR", offer)
+}
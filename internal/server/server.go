@@ -1,68 +1,165 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/http2"
 	"github.com/kx0101/httpfromtcp/internal/request"
 	"github.com/kx0101/httpfromtcp/internal/response"
 )
 
+const (
+	DefaultServerName = "httpfromtcp"
+)
+
 type Server struct {
+	// Port and Listener are those of the first listener, see Addrs for all of them
 	Port     int
 	Listener net.Listener
 	Closed   atomic.Bool
 	Handler  Handler
+	Name     string
+
+	streamBodies  bool
+	h2c           bool
+	h2cOptions    []http2.Option
+	errorRenderer ErrorRenderer
+	tlsConfig     *tls.Config
+
+	mu        sync.Mutex
+	listeners []net.Listener
+}
+
+type Option func(*Server)
+
+// WithServerName sets the Server header sent with every response, an empty name omits it
+func WithServerName(name string) Option {
+	return func(s *Server) {
+		s.Name = name
+	}
+}
+
+// WithErrorRenderer replaces NegotiatedErrorRenderer for handler and parser errors
+func WithErrorRenderer(renderer ErrorRenderer) Option {
+	return func(s *Server) {
+		s.errorRenderer = renderer
+	}
+}
+
+// WithStreamingBodies hands requests to the handler as soon as their headers are read,
+// the body is then consumed through Request.BodyReader instead of Request.Body. It
+// applies to HTTP/2 streams under WithH2C as well.
+func WithStreamingBodies() Option {
+	return func(s *Server) {
+		s.streamBodies = true
+	}
 }
 
 type Handler func(w *response.Writer, req *request.Request) *HandlerError
 type HandlerError struct {
-	Message string
-	Status  response.StatusCode
+	// Message is shown to the client, Internal and Cause are only logged
+	Message  string
+	Status   response.StatusCode
+	Internal string
+	Cause    error
+
+	// Headers are added to the error response, e.g. Allow or WWW-Authenticate
+	Headers headers.Headers
+	// ContentType bypasses the error renderer, Message is then written verbatim as the body
+	ContentType string
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	if handler == nil {
-		return nil, fmt.Errorf("handler cannot be nil")
+func (e *HandlerError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode(), e.Message)
+
+	if e.Internal != "" {
+		msg += ": " + e.Internal
 	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
 
-	if err != nil {
-		fmt.Println("Error:", err)
-		return nil, err
+	return msg
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Cause
+}
+
+// StatusCode returns Status, falling back to 500 when it is not a valid status code
+func (e *HandlerError) StatusCode() response.StatusCode {
+	if e.Status < 100 || e.Status > 999 {
+		return response.InternalServerError
+	}
+
+	return e.Status
+}
+
+// Serve listens on port on all interfaces, see ListenAndServe for other addresses
+func Serve(port int, handler Handler, options ...Option) (*Server, error) {
+	return ListenAndServe(fmt.Sprintf(":%d", port), handler, options...)
+}
+
+// New returns a Server that is not listening, connections are then handed to it with
+// ServeConn
+func New(handler Handler, options ...Option) (*Server, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
 	}
 
 	server := &Server{
-		Port:     port,
-		Listener: listener,
-		Handler:  handler,
+		Handler: handler,
+		Name:    DefaultServerName,
+
+		errorRenderer: NegotiatedErrorRenderer,
 	}
 
-	go server.listen()
+	for _, option := range options {
+		option(server)
+	}
 
 	return server, nil
 }
 
+// Close stops all listeners, connections being served are not interrupted
 func (s *Server) Close() error {
 	if !s.Closed.CompareAndSwap(false, true) {
 		return fmt.Errorf("server already closed")
 	}
 
-	return s.Listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
-func (s *Server) listen() {
+func (s *Server) listen(listener net.Listener) {
 	for {
 		if s.Closed.Load() {
 			return
 		}
 
-		conn, err := s.Listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			if s.Closed.Load() {
+			if s.Closed.Load() || errors.Is(err, net.ErrClosed) {
 				return
 			}
 
@@ -70,30 +167,130 @@ func (s *Server) listen() {
 			continue
 		}
 
-		go s.handle(conn)
+		go s.ServeConn(conn)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+// ServeConn reads one request from conn, answers it and closes conn unless the handler
+// hijacked it. With WithH2C it serves HTTP/2 instead when the client asks for it.
+func (s *Server) ServeConn(conn net.Conn) {
+	hijacked := false
+
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			fmt.Println("error: TLS handshake:", err)
+			return
+		}
+	}
+
+	if _, ok := conn.(*tls.Conn); !ok && s.h2c {
+		var isHTTP2 bool
+		if conn, isHTTP2 = sniffPreface(conn); isHTTP2 {
+			hijacked = true
+			s.serveHTTP2(conn)
 
-	req, err := request.RequestFromReader(conn)
+			return
+		}
+	}
+
+	req, err := s.readRequest(conn)
 	if err != nil {
-		fmt.Println("error:", err)
+		status := response.BadRequest
+		if errors.Is(err, request.ErrUnsupportedTransferEncoding) {
+			status = response.NotImplemented
+		}
 
-		WriteHandlerError(conn, &HandlerError{
+		s.writeError(conn, nil, &HandlerError{
 			Message: err.Error(),
-			Status:  response.BadRequest,
+			Status:  status,
+			Cause:   err,
 		})
 
 		return
 	}
 
-	writer := response.NewWriter()
+	req.RemoteAddr = conn.RemoteAddr().String()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
+
+	if settings, ok := upgradeSettings(req, s.streamBodies); ok && s.h2c && req.TLS == nil {
+		hijacked = true
+		s.upgradeHTTP2(conn, req, settings)
+
+		return
+	}
+
+	writer := response.NewConnWriter(conn)
+	writer.SetDefaultHeaders(s.defaultHeaders())
+	var watcher *closeWatcher
+
+	writer.SetCloseNotify(func() <-chan struct{} {
+		watcher = watchClose(conn, req.BodyDone())
+		return watcher.closed
+	})
+	writer.SetHijacker(func() (net.Conn, *bufio.ReadWriter, error) {
+		buffered := req.Buffered()
+		if watcher != nil {
+			buffered = append(buffered, watcher.stop()...)
+		}
+
+		hijacked = true
+
+		reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+
+		return conn, bufio.NewReadWriter(reader, bufio.NewWriter(conn)), nil
+	})
+
 	handlerErr := s.Handler(writer, req)
+	releaseRequest(req)
+
+	if hijacked {
+		if handlerErr != nil {
+			fmt.Println("error after connection was hijacked:", handlerErr)
+		}
+
+		return
+	}
+
+	if watcher != nil {
+		defer watcher.stop()
+	}
+
+	s.finish(conn, writer, req, handlerErr)
+}
+
+// releaseRequest removes the temporary files of a multipart form once the handler has
+// returned, the files are not reachable from anywhere else
+func releaseRequest(req *request.Request) {
+	if req.MultipartForm == nil {
+		return
+	}
+
+	if err := req.MultipartForm.RemoveAll(); err != nil {
+		fmt.Println("error removing multipart files:", err)
+	}
+}
+
+// finish completes the response the handler left in writer, or replaces it with an error
+// response when nothing has been sent yet
+func (s *Server) finish(w io.Writer, writer *response.Writer, req *request.Request, handlerErr *HandlerError) {
 	if handlerErr != nil {
-		fmt.Println("error during in handler:", handlerErr.Message)
-		WriteHandlerError(conn, handlerErr)
+		if writer.Committed() {
+			fmt.Println("error after response was sent:", handlerErr)
+			return
+		}
+
+		s.writeError(w, req, handlerErr)
+
 		return
 	}
 
@@ -101,28 +298,132 @@ func (s *Server) handle(conn net.Conn) {
 		writer.WriteHeaders(response.GetDefaultHeaders(len(writer.Body), "text/html"))
 	}
 
-	_, err = conn.Write(writer.Body)
-	if err != nil {
+	if err := writer.Flush(); err != nil {
 		fmt.Println("error:", err)
 	}
 }
 
-func WriteHandlerError(w io.Writer, handlerErr *HandlerError) {
-	status := handlerErr.Status
-	if status < 100 || status > 999 {
-		status = response.InternalServerError
+// maxWatchedBytes bounds what the close watcher keeps of data sent after the request, a
+// client that keeps sending is still there and the rest is left on the connection
+const maxWatchedBytes = 4096
+
+// closeWatcher reads from conn in the background once the request body has been read,
+// and closes closed when the client hangs up. Anything the client sends meanwhile is
+// kept for a hijacker, only EOF or a read error count as the end of the conversation.
+type closeWatcher struct {
+	conn     net.Conn
+	closed   chan struct{}
+	stopped  chan struct{}
+	finished chan struct{}
+	stopping atomic.Bool
+	buf      []byte
+}
+
+func watchClose(conn net.Conn, bodyDone <-chan struct{}) *closeWatcher {
+	w := &closeWatcher{
+		conn:     conn,
+		closed:   make(chan struct{}),
+		stopped:  make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 
-	body := []byte(handlerErr.Message)
-	headers := response.GetDefaultHeaders(len(body), "text/html")
+	go func() {
+		defer close(w.finished)
+
+		// the body is still on the connection and belongs to the handler
+		select {
+		case <-bodyDone:
+		case <-w.stopped:
+			return
+		}
+
+		chunk := make([]byte, 512)
+		for len(w.buf) < maxWatchedBytes {
+			n, err := conn.Read(chunk[:min(len(chunk), maxWatchedBytes-len(w.buf))])
+			w.buf = append(w.buf, chunk[:n]...)
 
-	writer := response.NewWriter()
+			if err != nil {
+				if !w.stopping.Load() {
+					close(w.closed)
+				}
 
-	writer.WriteStatusLine(status)
-	writer.WriteHeaders(headers)
-	writer.Write(body)
+				return
+			}
+		}
+	}()
 
-	if conn, ok := w.(net.Conn); ok {
-		conn.Write(writer.Body)
+	return w
+}
+
+// stop interrupts the pending read and returns the bytes the watcher consumed, so a
+// hijacked connection does not lose the start of its data. Only the first call returns
+// them.
+func (w *closeWatcher) stop() []byte {
+	if w.stopping.Swap(true) {
+		return nil
+	}
+
+	close(w.stopped)
+	w.conn.SetReadDeadline(time.Unix(1, 0))
+
+	<-w.finished
+
+	w.conn.SetReadDeadline(time.Time{})
+
+	return w.buf
+}
+
+func (s *Server) readRequest(conn net.Conn) (*request.Request, error) {
+	if s.streamBodies {
+		return request.RequestHeadFromReader(conn)
+	}
+
+	return request.RequestFromReader(conn)
+}
+
+// defaultHeaders are added to every response unless the handler wrote them itself
+func (s *Server) defaultHeaders() headers.Headers {
+	h := headers.Headers{}
+	h.Set("Date", httpDate(time.Now()))
+
+	if s.Name != "" {
+		h.Set("Server", s.Name)
+	}
+
+	return h
+}
+
+// WriteHandlerError renders handlerErr outside of a Server, with the default headers
+// and renderer a Server built with options would use
+func WriteHandlerError(w io.Writer, handlerErr *HandlerError, options ...Option) {
+	s := &Server{Name: DefaultServerName, errorRenderer: NegotiatedErrorRenderer}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	writeHandlerError(w, nil, handlerErr, s.errorRenderer, s.defaultHeaders())
+}
+
+func (s *Server) writeError(w io.Writer, req *request.Request, handlerErr *HandlerError) {
+	fmt.Println("error:", handlerErr)
+
+	writeHandlerError(w, req, handlerErr, s.errorRenderer, s.defaultHeaders())
+}
+
+func writeHandlerError(w io.Writer, req *request.Request, handlerErr *HandlerError, renderer ErrorRenderer, defaults headers.Headers) {
+	writer := response.NewConnWriter(w)
+	writer.SetDefaultHeaders(defaults)
+
+	if err := renderError(writer, req, handlerErr, renderer); err != nil {
+		fmt.Println("error rendering error response:", err)
+
+		writer = response.NewConnWriter(w)
+		writer.SetDefaultHeaders(defaults)
+		PlainTextErrorRenderer(writer, req, handlerErr)
+	}
+
+	if err := writer.Flush(); err != nil {
+		fmt.Println("error:", err)
 	}
 }
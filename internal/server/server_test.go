@@ -0,0 +1,506 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, handler Handler, options ...Option) *Server {
+	server, err := Serve(0, handler, options...)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return server
+}
+
+func roundTrip(t *testing.T, server *Server, raw string) string {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestServerAddsDateAndServerHeaders(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.Write([]byte("hi"))
+
+		return nil
+	}, WithServerName("test-server"))
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "server: test-server\r\n")
+
+	date := headerValue(out, "date")
+	_, err := headers.ParseTime(date)
+	require.NoError(t, err, date)
+}
+
+func TestServerKeepsHandlerServerHeader(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.OK)
+		w.SetHeader("Server", "custom")
+		w.SetHeader("Date", "Tue, 15 Nov 1994 08:12:31 GMT")
+		w.Write([]byte("hi"))
+
+		return nil
+	})
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	assert.Equal(t, 1, strings.Count(strings.ToLower(out), "\r\nserver:"))
+	assert.Contains(t, out, "server: custom\r\n")
+	assert.Contains(t, out, "date: Tue, 15 Nov 1994 08:12:31 GMT\r\n")
+}
+
+func TestServerAddsHeadersToHandlerErrors(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{
+			Message: "nope",
+			Status:  response.BadRequest,
+		}
+	})
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "server: "+DefaultServerName+"\r\n")
+	assert.NotEmpty(t, headerValue(out, "date"))
+
+	out = roundTrip(t, server, "BR(EW / HTTP/1.1\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.NotEmpty(t, headerValue(out, "date"))
+}
+
+func TestWriteHandlerErrorAddsDateAndServerHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	WriteHandlerError(&buf, &HandlerError{Message: "nope", Status: response.NotFound})
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.Equal(t, DefaultServerName, headerValue(out, "server"))
+
+	date := headerValue(out, "date")
+	_, err := headers.ParseTime(date)
+	require.NoError(t, err, date)
+
+	buf.Reset()
+	WriteHandlerError(&buf, &HandlerError{Message: "nope", Status: response.NotFound}, WithServerName("test-server"))
+	assert.Equal(t, "test-server", headerValue(buf.String(), "server"))
+}
+
+func TestHTTPDateIsCachedPerSecond(t *testing.T) {
+	now := time.Date(2025, 4, 6, 12, 0, 0, 0, time.UTC)
+
+	first := httpDate(now)
+	assert.Equal(t, "Sun, 06 Apr 2025 12:00:00 GMT", first)
+	assert.Equal(t, first, httpDate(now.Add(500*time.Millisecond)))
+	assert.Equal(t, "Sun, 06 Apr 2025 12:00:01 GMT", httpDate(now.Add(time.Second)))
+}
+
+func headerValue(raw, key string) string {
+	head, _, _ := strings.Cut(raw, "\r\n\r\n")
+	for line := range strings.SplitSeq(head, "\r\n") {
+		name, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(name, key) {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+func TestRequestErrorStatus(t *testing.T) {
+	assert.Nil(t, RequestError(nil))
+	assert.Equal(t, response.UnsupportedMediaType, RequestError(request.ErrUnsupportedMediaType).Status)
+	assert.Equal(t, response.ContentTooLarge, RequestError(request.ErrFormTooLarge).Status)
+	assert.Equal(t, response.BadRequest, RequestError(request.ErrMalformedForm).Status)
+}
+
+func TestServerStreamsUploads(t *testing.T) {
+	content := strings.Repeat("0123456789", 200000)
+	body := "--b\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"big.bin\"\r\n\r\n" +
+		content + "\r\n" +
+		"--b--\r\n"
+
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		reader, err := req.MultipartReader()
+		if err != nil {
+			return RequestError(err)
+		}
+
+		part, err := reader.NextPart()
+		if err != nil {
+			return RequestError(err)
+		}
+
+		n, err := io.Copy(io.Discard, part)
+		if err != nil {
+			return RequestError(err)
+		}
+
+		w.WriteStatusLine(response.OK)
+		w.Write([]byte(part.FileName() + " " + strconv.FormatInt(n, 10)))
+
+		return nil
+	}, WithStreamingBodies())
+
+	out := roundTrip(t, server, "POST /upload HTTP/1.1\r\n"+
+		"Content-Type: multipart/form-data; boundary=b\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n"+
+		"\r\n"+body)
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "big.bin 2000000"))
+}
+
+func TestServerStreamsChunkedUploads(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		body, err := req.ReadBody(1024)
+		if err != nil {
+			return RequestError(err)
+		}
+
+		w.WriteStatusLine(response.OK)
+		w.Write([]byte(fmt.Sprintf("%s checksum=%s", body, req.Trailers.Get("X-Checksum"))))
+
+		return nil
+	}, WithStreamingBodies())
+
+	out := roundTrip(t, server, "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "hello world checksum=abc"), out)
+
+	out = roundTrip(t, server, "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"), out)
+}
+
+func TestHandlerErrorIsEscaped(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{
+			Message:  "<script>alert(1)</script>",
+			Status:   response.BadRequest,
+			Internal: "secret database detail",
+		}
+	})
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nAccept: text/html\r\n\r\n")
+
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, out, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.NotContains(t, out, "<script>")
+	assert.NotContains(t, out, "secret database detail")
+}
+
+func TestHandlerErrorNegotiatesRenderer(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{
+			Message: "no such item",
+			Status:  response.NotFound,
+			Headers: headers.Headers{"x-request-id": "42"},
+		}
+	})
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.Contains(t, out, "content-type: application/problem+json\r\n")
+	assert.Contains(t, out, "x-request-id: 42\r\n")
+	assert.Contains(t, out, `"detail":"no such item"`)
+
+	out = roundTrip(t, server, "GET / HTTP/1.1\r\nAccept: text/plain\r\n\r\n")
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "404 Not Found\nno such item\n"))
+}
+
+func TestHandlerErrorContentTypeOverride(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{
+			Message:     "<h1>custom page</h1>",
+			Status:      response.Forbidden,
+			ContentType: "text/html",
+		}
+	})
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+	assert.Contains(t, out, "content-type: text/html\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n<h1>custom page</h1>"))
+}
+
+func TestCustomErrorRendererHandlesParserErrors(t *testing.T) {
+	renderer := func(w *response.Writer, req *request.Request, handlerErr *HandlerError) error {
+		assert.Nil(t, req)
+		assert.ErrorIs(t, handlerErr, request.ErrInvalidMethod)
+
+		return writeBody(w, handlerErr.StatusCode(), "text/plain", []byte("custom"))
+	}
+
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return nil
+	}, WithErrorRenderer(renderer))
+
+	out := roundTrip(t, server, "BR(EW / HTTP/1.1\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncustom"))
+}
+
+func TestServerLeavesHijackedConnectionOpen(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.SwitchingProtocols)
+		w.SetHeader("Upgrade", "echo")
+		w.WriteHeaders(w.Headers)
+
+		conn, _, err := w.Hijack()
+		if err != nil {
+			return &HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+
+		_, _, err = w.Hijack()
+		assert.ErrorIs(t, err, response.ErrHijacked)
+
+		hijacked <- conn
+
+		return nil
+	})
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	serverConn := <-hijacked
+	defer serverConn.Close()
+
+	_, err = serverConn.Write([]byte("still here"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	buf := make([]byte, 4096)
+	received := ""
+	for !strings.HasSuffix(received, "still here") {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+
+		received += string(buf[:n])
+	}
+
+	assert.True(t, strings.HasPrefix(received, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, received, "upgrade: echo\r\n")
+	assert.True(t, strings.HasSuffix(received, "\r\n\r\nstill here"))
+}
+
+func TestHijackHandsOverBufferedBytes(t *testing.T) {
+	tests := []struct {
+		name        string
+		closeNotify bool
+	}{
+		{name: "bytes read with the request"},
+		{name: "bytes read by CloseNotify", closeNotify: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			watching := make(chan struct{})
+			sent := make(chan struct{})
+
+			server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+				if tt.closeNotify {
+					notify := w.CloseNotify()
+					close(watching)
+					<-sent
+
+					// data from a client that is still there does not count as closing
+					assert.Never(t, func() bool {
+						select {
+						case <-notify:
+							return true
+						default:
+							return false
+						}
+					}, 100*time.Millisecond, 10*time.Millisecond)
+				}
+
+				_, rw, err := w.Hijack()
+				if err != nil {
+					return &HandlerError{Status: response.InternalServerError, Cause: err}
+				}
+
+				data := make([]byte, len("ping"))
+				_, err = io.ReadFull(rw, data)
+				assert.NoError(t, err)
+
+				received <- string(data)
+
+				return nil
+			})
+
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+			if !tt.closeNotify {
+				raw += "ping"
+			}
+
+			_, err = conn.Write([]byte(raw))
+			require.NoError(t, err)
+
+			if tt.closeNotify {
+				<-watching
+
+				_, err = conn.Write([]byte("ping"))
+				require.NoError(t, err)
+				close(sent)
+			}
+
+			select {
+			case data := <-received:
+				assert.Equal(t, "ping", data)
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler did not receive the buffered bytes")
+			}
+		})
+	}
+}
+
+func TestCloseNotifyWithStreamingBody(t *testing.T) {
+	bodies := make(chan string, 1)
+	pipelined := make(chan struct{})
+	checked := make(chan struct{})
+	fired := make(chan bool, 1)
+
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		// asked for before the body is read, which must not take any of it
+		notify := w.CloseNotify()
+
+		body, err := io.ReadAll(req.BodyReader())
+		assert.NoError(t, err)
+		bodies <- string(body)
+
+		<-pipelined
+		assert.Never(t, func() bool {
+			select {
+			case <-notify:
+				return true
+			default:
+				return false
+			}
+		}, 100*time.Millisecond, 10*time.Millisecond)
+		close(checked)
+
+		select {
+		case <-notify:
+			fired <- true
+		case <-time.After(5 * time.Second):
+			fired <- false
+		}
+
+		return nil
+	}, WithStreamingBodies())
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello ")
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "world")
+	require.NoError(t, err)
+
+	select {
+	case body := <-bodies:
+		assert.Equal(t, "hello world", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not read the body")
+	}
+
+	_, err = io.WriteString(conn, "GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	close(pipelined)
+
+	<-checked
+	require.NoError(t, conn.Close())
+
+	assert.True(t, <-fired, "CloseNotify did not fire when the client hung up")
+}
+
+func TestServerRemovesMultipartFiles(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		// a limit of 0 spills every file part to disk
+		if err := req.ParseMultipartForm(0); err != nil {
+			return &HandlerError{Status: response.BadRequest, Cause: err}
+		}
+
+		_, header, err := req.FormFile("upload")
+		if err != nil {
+			return &HandlerError{Status: response.BadRequest, Cause: err}
+		}
+
+		entries, _ := os.ReadDir(tmp)
+		body := fmt.Sprintf("on disk=%t files=%d", header.OnDisk(), len(entries))
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+		w.Write([]byte(body))
+
+		return nil
+	}, WithStreamingBodies())
+
+	body := "--b\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"a.txt\"\r\n\r\n" +
+		"file content\r\n" +
+		"--b--\r\n"
+
+	out := roundTrip(t, server, "POST /upload HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Type: multipart/form-data; boundary=b\r\n"+
+		fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body))+body)
+
+	assert.True(t, strings.HasSuffix(out, "on disk=true files=1"), out)
+
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultTLSMinVersion = tls.VersionTLS12
+)
+
+var ErrNoCertificates = errors.New("error: no certificates given")
+
+// CertKeyPair names a PEM certificate chain and its private key on disk
+type CertKeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Certificates picks the certificate for a handshake by SNI among certificates loaded from
+// disk. Reloading swaps them at once, connections that are already established keep the
+// certificate they were made with.
+type Certificates struct {
+	pairs []CertKeyPair
+
+	// mu serializes reloads, handshakes only read current
+	mu       sync.Mutex
+	current  atomic.Pointer[certSet]
+	modTimes []time.Time
+}
+
+type certSet struct {
+	certs  []*tls.Certificate
+	byName map[string]*tls.Certificate
+}
+
+// LoadCertificates loads pairs, the first one is used when the client sends no or an
+// unknown server name
+func LoadCertificates(pairs ...CertKeyPair) (*Certificates, error) {
+	if len(pairs) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	c := &Certificates{pairs: pairs}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads all pairs again, when one fails the certificates in use are kept
+func (c *Certificates) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTimes := make([]time.Time, 0, 2*len(c.pairs))
+	for _, pair := range c.pairs {
+		for _, name := range []string{pair.CertFile, pair.KeyFile} {
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+
+			modTimes = append(modTimes, info.ModTime())
+		}
+	}
+
+	set := &certSet{byName: map[string]*tls.Certificate{}}
+
+	for _, pair := range c.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", pair.CertFile, err)
+		}
+
+		set.certs = append(set.certs, &cert)
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := set.byName[name]; !ok {
+				set.byName[name] = &cert
+			}
+		}
+	}
+
+	c.current.Store(set)
+	c.modTimes = modTimes
+
+	return nil
+}
+
+// GetCertificate is the tls.Config callback, it matches the server name exactly, then
+// against wildcard names one label deep
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := c.current.Load()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if cert, ok := set.byName[name]; ok {
+		return cert, nil
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := set.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	return set.certs[0], nil
+}
+
+// WatchFiles checks the files every interval and reloads them once one changed. Errors
+// are logged and retried on the next change. Calling stop ends the watching.
+func (c *Certificates) WatchFiles(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !c.changed() {
+					continue
+				}
+
+				if err := c.Reload(); err != nil {
+					fmt.Println("error reloading certificates:", err)
+				}
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() {
+		close(done)
+	})
+}
+
+// ReloadOnSIGHUP reloads the certificates whenever the process receives SIGHUP
+func (c *Certificates) ReloadOnSIGHUP() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				if err := c.Reload(); err != nil {
+					fmt.Println("error reloading certificates:", err)
+				}
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() {
+		signal.Stop(signals)
+		close(done)
+	})
+}
+
+func (c *Certificates) changed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := 0
+	for _, pair := range c.pairs {
+		for _, name := range []string{pair.CertFile, pair.KeyFile} {
+			info, err := os.Stat(name)
+			if err != nil || !info.ModTime().Equal(c.modTimes[i]) {
+				return true
+			}
+
+			i++
+		}
+	}
+
+	return false
+}
+
+type TLSOption func(*tls.Config)
+
+// WithMinTLSVersion replaces DefaultTLSMinVersion, e.g. with tls.VersionTLS13
+func WithMinTLSVersion(version uint16) TLSOption {
+	return func(config *tls.Config) {
+		config.MinVersion = version
+	}
+}
+
+// WithCipherSuites limits the cipher suites of TLS 1.2 and below, those of TLS 1.3 are
+// not configurable
+func WithCipherSuites(suites ...uint16) TLSOption {
+	return func(config *tls.Config) {
+		config.CipherSuites = suites
+	}
+}
+
+// WithTLS serves HTTPS on every listener with certs, advertising http/1.1 over ALPN
+func WithTLS(certs *Certificates, options ...TLSOption) Option {
+	return func(s *Server) {
+		s.tlsConfig = newTLSConfig(certs, options...)
+	}
+}
+
+func newTLSConfig(certs *Certificates, options ...TLSOption) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     DefaultTLSMinVersion,
+		NextProtos:     []string{"http/1.1"},
+		GetCertificate: certs.GetCertificate,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	return config
+}
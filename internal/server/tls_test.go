@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for names to dir and returns its pair
+func writeCert(t *testing.T, dir, file string, serial int64, names ...string) CertKeyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pair := CertKeyPair{
+		CertFile: filepath.Join(dir, file+".crt"),
+		KeyFile:  filepath.Join(dir, file+".key"),
+	}
+
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return pair
+}
+
+func startTLSServer(t *testing.T, certs *Certificates, options ...TLSOption) *Server {
+	server, err := ListenAndServe("127.0.0.1:0", func(w *response.Writer, req *request.Request) *HandlerError {
+		body := "plain"
+		if req.TLS != nil {
+			body = req.TLS.ServerName + " " + req.TLS.NegotiatedProtocol
+		}
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+		w.Write([]byte(body))
+
+		return nil
+	}, WithTLS(certs, options...))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return server
+}
+
+func dialTLS(t *testing.T, server *Server, config *tls.Config) *tls.Conn {
+	config.InsecureSkipVerify = true
+
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return conn
+}
+
+func tlsGet(t *testing.T, conn *tls.Conn) string {
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func peerSerial(conn *tls.Conn) int64 {
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestServeTLSSelectsCertificateBySNI(t *testing.T) {
+	dir := t.TempDir()
+
+	certs, err := LoadCertificates(
+		writeCert(t, dir, "a", 1, "a.test"),
+		writeCert(t, dir, "b", 2, "*.b.test", "b.test"),
+	)
+	require.NoError(t, err)
+
+	server := startTLSServer(t, certs)
+
+	cases := map[string]int64{
+		"a.test":       1,
+		"A.TEST":       1,
+		"www.b.test":   2,
+		"b.test":       2,
+		"x.y.b.test":   1,
+		"unknown.test": 1,
+	}
+
+	for name, serial := range cases {
+		conn := dialTLS(t, server, &tls.Config{ServerName: name, NextProtos: []string{"http/1.1"}})
+		assert.Equal(t, serial, peerSerial(conn), name)
+	}
+
+	conn := dialTLS(t, server, &tls.Config{ServerName: "a.test", NextProtos: []string{"http/1.1"}})
+	out := tlsGet(t, conn)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\na.test http/1.1"), out)
+}
+
+func TestServeTLSMinVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	certs, err := LoadCertificates(writeCert(t, dir, "a", 1, "a.test"))
+	require.NoError(t, err)
+
+	server := startTLSServer(t, certs, WithMinTLSVersion(tls.VersionTLS13))
+
+	_, err = tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+
+	conn := dialTLS(t, server, &tls.Config{})
+	assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+}
+
+func TestTLSConfigOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	certs, err := LoadCertificates(writeCert(t, dir, "a", 1, "a.test"))
+	require.NoError(t, err)
+
+	config := newTLSConfig(certs, WithCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256))
+
+	assert.Equal(t, uint16(DefaultTLSMinVersion), config.MinVersion)
+	assert.Equal(t, []string{"http/1.1"}, config.NextProtos)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, config.CipherSuites)
+
+	_, err = LoadCertificates()
+	assert.ErrorIs(t, err, ErrNoCertificates)
+}
+
+func TestCertificatesReloadKeepsConnections(t *testing.T) {
+	dir := t.TempDir()
+
+	certs, err := LoadCertificates(writeCert(t, dir, "a", 1, "a.test"))
+	require.NoError(t, err)
+
+	server := startTLSServer(t, certs)
+
+	before := dialTLS(t, server, &tls.Config{ServerName: "a.test"})
+	require.Equal(t, int64(1), peerSerial(before))
+
+	writeCert(t, dir, "a", 2, "a.test")
+	require.NoError(t, certs.Reload())
+
+	after := dialTLS(t, server, &tls.Config{ServerName: "a.test"})
+	assert.Equal(t, int64(2), peerSerial(after))
+
+	// the connection made before the reload is still served
+	assert.True(t, strings.HasPrefix(tlsGet(t, before), "HTTP/1.1 200 OK\r\n"))
+
+	// a broken file keeps the certificates in use
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.crt"), []byte("garbage"), 0o600))
+	assert.Error(t, certs.Reload())
+
+	cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+}
+
+func TestCertificatesWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeCert(t, dir, "a", 1, "a.test")
+
+	certs, err := LoadCertificates(pair)
+	require.NoError(t, err)
+
+	stop := certs.WatchFiles(10 * time.Millisecond)
+	defer stop()
+
+	writeCert(t, dir, "a", 2, "a.test")
+
+	// make sure the modification time differs on filesystems with a coarse clock
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(pair.CertFile, later, later))
+
+	assert.Eventually(t, func() bool {
+		cert, _ := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+		return cert.Leaf.SerialNumber.Int64() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCertificatesReloadOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+
+	certs, err := LoadCertificates(writeCert(t, dir, "a", 1, "a.test"))
+	require.NoError(t, err)
+
+	stop := certs.ReloadOnSIGHUP()
+	defer stop()
+
+	writeCert(t, dir, "a", 2, "a.test")
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(syscall.SIGHUP))
+
+	assert.Eventually(t, func() bool {
+		cert, _ := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+		return cert.Leaf.SerialNumber.Int64() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServeTLSRequestIsPlainWithoutTLS(t *testing.T) {
+	server := startTestServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		assert.Nil(t, req.TLS)
+		w.WriteStatusLine(response.OK)
+
+		return nil
+	})
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+}
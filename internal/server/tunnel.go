@@ -0,0 +1,293 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultTunnelDialTimeout = 10 * time.Second
+	DefaultTunnelIdleTimeout = 5 * time.Minute
+
+	tunnelBufferSize = 32 * 1024
+)
+
+// TunnelStats describes a finished CONNECT tunnel, Sent counts bytes from the client to
+// the target and Received bytes from the target to the client
+type TunnelStats struct {
+	Target   string
+	Client   net.Addr
+	Sent     int64
+	Received int64
+	Duration time.Duration
+}
+
+type connectProxy struct {
+	ports       []int
+	hosts       []string
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+	onClose     func(TunnelStats)
+}
+
+type ConnectProxyOption func(*connectProxy)
+
+// WithAllowedPorts replaces the destination ports tunnels may be opened to, 443 by default
+func WithAllowedPorts(ports ...int) ConnectProxyOption {
+	return func(p *connectProxy) {
+		p.ports = ports
+	}
+}
+
+// WithAllowedHosts sets the hosts tunnels may be opened to, a leading "*." matches any
+// subdomain. Without it every host is refused, an open proxy is never the default.
+func WithAllowedHosts(hosts ...string) ConnectProxyOption {
+	return func(p *connectProxy) {
+		p.hosts = hosts
+	}
+}
+
+func WithDialTimeout(timeout time.Duration) ConnectProxyOption {
+	return func(p *connectProxy) {
+		p.dialTimeout = timeout
+	}
+}
+
+// WithIdleTimeout closes tunnels that carried no data in either direction for timeout,
+// zero disables it
+func WithIdleTimeout(timeout time.Duration) ConnectProxyOption {
+	return func(p *connectProxy) {
+		p.idleTimeout = timeout
+	}
+}
+
+// WithTunnelStats is called with the byte counters of every tunnel once it is closed
+func WithTunnelStats(onClose func(TunnelStats)) ConnectProxyOption {
+	return func(p *connectProxy) {
+		p.onClose = onClose
+	}
+}
+
+// ConnectProxy returns a forward proxy handler for CONNECT requests. It dials the target,
+// answers 200 Connection Established and copies bytes both ways until either side is done.
+func ConnectProxy(options ...ConnectProxyOption) Handler {
+	p := &connectProxy{
+		ports:       []int{443},
+		dialTimeout: DefaultTunnelDialTimeout,
+		idleTimeout: DefaultTunnelIdleTimeout,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	return p.serve
+}
+
+func (p *connectProxy) serve(w *response.Writer, req *request.Request) *HandlerError {
+	if req.RequestLine.Method != "CONNECT" {
+		h := headers.Headers{}
+		h.Set("Allow", "CONNECT")
+
+		return &HandlerError{
+			Message: "Only CONNECT is supported",
+			Status:  response.MethodNotAllowed,
+			Headers: h,
+		}
+	}
+
+	target := req.RequestLine.RequestTarget
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return &HandlerError{Message: "Invalid tunnel target", Status: response.BadRequest, Cause: err}
+	}
+
+	if !p.allowed(host, port) {
+		return &HandlerError{
+			Message:  "Tunnel destination not allowed",
+			Status:   response.Forbidden,
+			Internal: target,
+		}
+	}
+
+	dialer := net.Dialer{Timeout: p.dialTimeout}
+
+	upstream, err := dialer.Dial("tcp", target)
+	if err != nil {
+		status := response.BadGateway
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			status = response.GatewayTimeout
+		}
+
+		return &HandlerError{Message: "Could not reach " + target, Status: status, Cause: err}
+	}
+
+	defer upstream.Close()
+
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return &HandlerError{Status: response.InternalServerError, Cause: err}
+	}
+
+	defer conn.Close()
+
+	// a 2xx answer to CONNECT has no body and must not carry Content-Length or
+	// Transfer-Encoding (RFC 9110 section 9.3.6)
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return nil
+	}
+
+	t := &tunnel{
+		client:      conn,
+		upstream:    upstream,
+		idleTimeout: p.idleTimeout,
+	}
+	t.touch()
+
+	start := time.Now()
+	t.splice(rw)
+
+	if p.onClose != nil {
+		p.onClose(TunnelStats{
+			Target:   target,
+			Client:   conn.RemoteAddr(),
+			Sent:     t.sent.Load(),
+			Received: t.received.Load(),
+			Duration: time.Since(start),
+		})
+	}
+
+	return nil
+}
+
+func (p *connectProxy) allowed(host, port string) bool {
+	n, err := strconv.Atoi(port)
+	if err != nil || !slices.Contains(p.ports, n) {
+		return false
+	}
+
+	host = strings.ToLower(host)
+
+	for _, allowed := range p.hosts {
+		allowed = strings.ToLower(allowed)
+
+		if suffix, found := strings.CutPrefix(allowed, "*"); found && strings.HasSuffix(host, suffix) {
+			return true
+		}
+
+		if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tunnel struct {
+	client      net.Conn
+	upstream    net.Conn
+	idleTimeout time.Duration
+	lastActive  atomic.Int64
+	sent        atomic.Int64
+	received    atomic.Int64
+}
+
+// splice copies the client to upstream and back, clientReader holds what the server
+// already buffered from the client. A side that finishes half-closes the other
+// connection so its peer sees EOF, an error or idle timeout tears down both.
+func (t *tunnel) splice(clientReader io.Reader) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		t.pipe(t.upstream, clientReader, t.client, &t.sent)
+	}()
+
+	t.pipe(t.client, t.upstream, t.upstream, &t.received)
+	wg.Wait()
+}
+
+func (t *tunnel) pipe(dst net.Conn, src io.Reader, srcConn net.Conn, counter *atomic.Int64) {
+	buf := make([]byte, tunnelBufferSize)
+
+	for {
+		if t.idleTimeout > 0 {
+			srcConn.SetReadDeadline(time.Now().Add(t.idleTimeout))
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				t.close()
+				return
+			}
+
+			counter.Add(int64(n))
+		}
+
+		if err == nil {
+			continue
+		}
+
+		// the other direction may still be busy, only an idle tunnel times out
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && t.sinceActive() < t.idleTimeout {
+			continue
+		}
+
+		if err == io.EOF {
+			closeWrite(dst)
+			return
+		}
+
+		if !errors.Is(err, net.ErrClosed) {
+			fmt.Println("tunnel error:", err)
+		}
+
+		t.close()
+
+		return
+	}
+}
+
+func (t *tunnel) close() {
+	t.client.Close()
+	t.upstream.Close()
+}
+
+func (t *tunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+func (t *tunnel) sinceActive() time.Duration {
+	return time.Since(time.Unix(0, t.lastActive.Load()))
+}
+
+// closeWrite half-closes conn when it supports it and closes it otherwise
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+		return
+	}
+
+	conn.Close()
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoTarget runs a TCP server that echoes everything back until the client half-closes
+func startEchoTarget(t *testing.T) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), listener.Addr().(*net.TCPAddr).Port
+}
+
+func dialTunnel(t *testing.T, server *Server, target string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+
+	return conn, br, status
+}
+
+func TestConnectProxyTunnelsToTarget(t *testing.T) {
+	target, port := startEchoTarget(t)
+	stats := make(chan TunnelStats, 1)
+
+	server := startTestServer(t, ConnectProxy(
+		WithAllowedPorts(port),
+		WithAllowedHosts("127.0.0.1"),
+		WithTunnelStats(func(s TunnelStats) {
+			stats <- s
+		}),
+	))
+
+	conn, br, status := dialTunnel(t, server, target)
+	require.Equal(t, "HTTP/1.1 200 Connection Established\r\n", status)
+
+	blank, err := br.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "\r\n", blank)
+
+	_, err = conn.Write([]byte("hello through the tunnel"))
+	require.NoError(t, err)
+
+	echo := make([]byte, len("hello through the tunnel"))
+	_, err = io.ReadFull(br, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "hello through the tunnel", string(echo))
+
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	select {
+	case s := <-stats:
+		assert.Equal(t, target, s.Target)
+		assert.Equal(t, int64(24), s.Sent)
+		assert.Equal(t, int64(24), s.Received)
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel stats were not reported")
+	}
+}
+
+func TestConnectProxyRejectsTargets(t *testing.T) {
+	target, port := startEchoTarget(t)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	closedTarget := closed.Addr().String()
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	server := startTestServer(t, ConnectProxy(
+		WithAllowedPorts(port, closedPort),
+		WithAllowedHosts("127.0.0.1", "*.example.com"),
+	))
+
+	tests := []struct {
+		name   string
+		target string
+		status string
+	}{
+		{name: "port not allowed", target: "127.0.0.1:" + strconv.Itoa(port+1), status: "HTTP/1.1 403 Forbidden\r\n"},
+		{name: "host not allowed", target: "localhost:" + strconv.Itoa(port), status: "HTTP/1.1 403 Forbidden\r\n"},
+		{name: "target refuses", target: closedTarget, status: "HTTP/1.1 502 Bad Gateway\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, status := dialTunnel(t, server, tt.target)
+			assert.Equal(t, tt.status, status)
+		})
+	}
+
+	// no hosts are allowed unless they are listed
+	open := startTestServer(t, ConnectProxy(WithAllowedPorts(port)))
+	_, _, status := dialTunnel(t, open, target)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", status)
+
+	out := roundTrip(t, server, "GET / HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"), out)
+	assert.Contains(t, out, "allow: CONNECT\r\n")
+}
+
+func TestConnectProxyClosesIdleTunnels(t *testing.T) {
+	target, port := startEchoTarget(t)
+
+	server := startTestServer(t, ConnectProxy(
+		WithAllowedPorts(port),
+		WithAllowedHosts("127.0.0.1"),
+		WithIdleTimeout(50*time.Millisecond),
+	))
+
+	_, br, status := dialTunnel(t, server, target)
+	require.Equal(t, "HTTP/1.1 200 Connection Established\r\n", status)
+
+	start := time.Now()
+
+	_, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes socket creation, the umask is process wide
+var umaskMu sync.Mutex
+
+// listenUnix creates the socket file with no permissions at all, so nobody can connect
+// before ListenUnix has set the requested mode
+func listenUnix(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	old := syscall.Umask(0o777)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
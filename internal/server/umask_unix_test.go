@@ -0,0 +1,47 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnixCreatesSocketWithoutPermissions(t *testing.T) {
+	// a permissive umask would leave the socket world writable until the chmod
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(t.TempDir(), "s.sock")
+
+	listener, err := listenUnix(path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0), info.Mode().Perm())
+
+	// the umask of the process is restored
+	assert.Equal(t, 0, syscall.Umask(0))
+}
+
+func TestServeUnixAppliesModeWithPermissiveUmask(t *testing.T) {
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(t.TempDir(), "s.sock")
+
+	server, err := ServeUnix(path, 0o660, hello)
+	require.NoError(t, err)
+	defer server.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+}
@@ -0,0 +1,76 @@
+// Package servertest runs handlers in memory for tests, either directly against a
+// Recorder or through the full server on a net.Pipe connection
+package servertest
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+// Recorder captures what a handler writes to its response.Writer
+type Recorder struct {
+	Writer *response.Writer
+
+	buf bytes.Buffer
+}
+
+// Result is a recorded response, parsed back the way a client sees it
+type Result struct {
+	StatusCode response.StatusCode
+	Headers    headers.Headers
+	SetCookie  []string
+	Body       []byte
+	Trailers   headers.Headers
+}
+
+func NewRecorder() *Recorder {
+	r := &Recorder{}
+	r.Writer = response.NewConnWriter(&r.buf)
+
+	return r
+}
+
+// Bytes finishes the response like the server does after the handler returned and
+// returns it as it would be sent
+func (r *Recorder) Bytes() ([]byte, error) {
+	if r.Writer.State < 2 {
+		r.Writer.WriteHeaders(response.GetDefaultHeaders(len(r.Writer.Body), "text/html"))
+	}
+
+	if err := r.Writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return r.buf.Bytes(), nil
+}
+
+// Result parses the recorded response, failing on the same framing errors a client would
+func (r *Recorder) Result() (*Result, error) {
+	raw, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		SetCookie:  resp.SetCookie,
+		Body:       body,
+		Trailers:   resp.Trailers,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package servertest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echo(w *response.Writer, req *request.Request) *server.HandlerError {
+	body := req.RequestLine.Method + " " + req.Headers.Get("Host") + req.RequestLine.RequestTarget + " " + string(req.Body)
+
+	w.WriteStatusLine(response.OK)
+	w.AddHeader("Set-Cookie", "a=1")
+	w.AddHeader("Set-Cookie", "b=2")
+	w.WriteHeaders(response.GetDefaultHeaders(len(body), "text/plain"))
+	w.Write([]byte(body))
+
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	req := NewRequest("POST", "/echo", strings.NewReader("payload"))
+
+	require.Nil(t, echo(rec.Writer, req))
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+
+	assert.Equal(t, response.OK, res.StatusCode)
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Equal(t, []string{"a=1", "b=2"}, res.SetCookie)
+	assert.Equal(t, "POST example.com/echo payload", string(res.Body))
+}
+
+func TestRecorderChunkedTrailers(t *testing.T) {
+	rec := NewRecorder()
+
+	h := response.GetDefaultHeaders(0, "text/plain")
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+
+	rec.Writer.WriteStatusLine(response.OK)
+	rec.Writer.WriteHeaders(h)
+	rec.Writer.WriteChunkedBody([]byte("one "))
+	rec.Writer.WriteChunkedBody([]byte("two"))
+	rec.Writer.WriteTrailers(headers.Headers{"x-checksum": "abc"})
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+
+	assert.Equal(t, "one two", string(res.Body))
+	assert.Equal(t, "abc", res.Trailers.Get("X-Checksum"))
+}
+
+func TestNewRequest(t *testing.T) {
+	req := NewRequest("GET", "/path?q=1", nil)
+
+	assert.Equal(t, "GET", req.RequestLine.Method)
+	assert.Equal(t, "/path?q=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, DefaultHost, req.Headers.Get("Host"))
+	assert.Equal(t, DefaultRemoteAddr, req.RemoteAddr)
+	assert.False(t, req.Headers.Exists("Content-Length"))
+	assert.Nil(t, req.Body)
+
+	// the same request as the server would parse it off the wire
+	parsed, err := request.RequestFromReader(strings.NewReader("GET /path?q=1 HTTP/1.1\r\nHost: " + DefaultHost + "\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, parsed.RequestLine.HttpVersion, req.RequestLine.HttpVersion)
+	assert.Equal(t, parsed.RequestLine, req.RequestLine)
+	assert.Equal(t, parsed.Headers, req.Headers)
+
+	req = NewRequest("PUT", "/", strings.NewReader("data"))
+	assert.Equal(t, "4", req.Headers.Get("Content-Length"))
+	assert.Equal(t, []byte("data"), req.Body)
+}
@@ -0,0 +1,49 @@
+package servertest
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+)
+
+const (
+	// DefaultHost is the Host of requests built with NewRequest
+	DefaultHost = "example.com"
+	// DefaultRemoteAddr is the RemoteAddr of requests built with NewRequest, from the
+	// documentation range of RFC 5737
+	DefaultRemoteAddr = "192.0.2.1:1234"
+)
+
+// NewRequest returns a request as the server would hand it to a handler. body may be
+// nil, otherwise it is read completely and its length sent as Content-Length. It panics
+// when body fails, which is a bug in the test.
+func NewRequest(method, target string, body io.Reader) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: target,
+			HttpVersion:   "HTTP/1.1",
+		},
+		Headers:    headers.NewHeaders(),
+		RemoteAddr: DefaultRemoteAddr,
+		Status:     request.RequestStateDone,
+	}
+
+	req.Headers.Set("Host", DefaultHost)
+
+	if body == nil {
+		return req
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		panic("servertest: reading request body: " + err.Error())
+	}
+
+	req.Headers.Set("Content-Length", strconv.Itoa(len(data)))
+	req.Body = data
+
+	return req
+}
@@ -0,0 +1,73 @@
+package servertest
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/server"
+)
+
+// Server runs the full server connection handling on in-memory connections, no port is
+// opened
+type Server struct {
+	// URL is the base of requests sent with Client, the host is not resolved
+	URL string
+
+	srv *server.Server
+	wg  sync.WaitGroup
+}
+
+// NewServer panics when the server cannot be created, e.g. for a nil handler
+func NewServer(handler server.Handler, options ...server.Option) *Server {
+	srv, err := server.New(handler, options...)
+	if err != nil {
+		panic("servertest: " + err.Error())
+	}
+
+	return &Server{URL: "http://" + DefaultHost, srv: srv}
+}
+
+// Dial returns the client side of a net.Pipe whose other end is being served
+func (s *Server) Dial() net.Conn {
+	clientConn, serverConn := net.Pipe()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.srv.ServeConn(serverConn)
+	}()
+
+	return clientConn
+}
+
+// Client returns a client whose connections are made with Dial, whatever the URL host
+func (s *Server) Client() *client.Client {
+	return client.New(
+		client.WithDialer(func(network, addr string) (net.Conn, error) {
+			return s.Dial(), nil
+		}),
+		// the server closes every connection after its response
+		client.WithMaxIdlePerHost(0),
+	)
+}
+
+// RoundTrip sends raw on a new connection and returns everything the server wrote
+// before closing it
+func (s *Server) RoundTrip(raw string) (string, error) {
+	conn := s.Dial()
+	defer conn.Close()
+
+	// net.Pipe writes block until read, the server may answer before reading all of raw
+	go io.WriteString(conn, raw)
+
+	data, err := io.ReadAll(conn)
+
+	return string(data), err
+}
+
+// Close waits for the handlers of all connections handed out so far to return
+func (s *Server) Close() {
+	s.wg.Wait()
+}
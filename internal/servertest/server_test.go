@@ -0,0 +1,81 @@
+package servertest
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kx0101/httpfromtcp/internal/client"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerClient(t *testing.T) {
+	srv := NewServer(echo, server.WithServerName("servertest"))
+	defer srv.Close()
+
+	c := srv.Client()
+
+	for range 2 {
+		req, err := client.NewRequest("POST", srv.URL+"/echo", strings.NewReader("payload"))
+		require.NoError(t, err)
+
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, response.OK, resp.StatusCode)
+		assert.Equal(t, "servertest", resp.Headers.Get("Server"))
+		assert.Equal(t, "POST example.com/echo payload", string(body))
+	}
+}
+
+func TestServerRoundTripRendersErrors(t *testing.T) {
+	srv := NewServer(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{Status: response.NotFound, Message: "Nothing here", ContentType: "text/plain"}
+	})
+	defer srv.Close()
+
+	out, err := srv.RoundTrip("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nNothing here"), out)
+
+	out, err = srv.RoundTrip("NOT A REQUEST\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+}
+
+func TestServerHijack(t *testing.T) {
+	srv := NewServer(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, brw, err := w.Hijack()
+		if err != nil {
+			return &server.HandlerError{Status: response.InternalServerError, Cause: err}
+		}
+		defer conn.Close()
+
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo: " + line)
+		brw.Flush()
+
+		return nil
+	})
+	defer srv.Close()
+
+	out, err := srv.RoundTrip("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nping\n")
+	require.NoError(t, err)
+	assert.Equal(t, "echo: ping\n", out)
+}
+
+func TestNewServerPanicsOnNilHandler(t *testing.T) {
+	assert.Panics(t, func() {
+		NewServer(nil)
+	})
+}
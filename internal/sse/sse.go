@@ -0,0 +1,214 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/response"
+)
+
+const (
+	DefaultHeartbeat = 15 * time.Second
+)
+
+var (
+	ErrStreamClosed = errors.New("error: event stream closed")
+	ErrInvalidField = errors.New("error: event field must not contain newlines")
+)
+
+// Event is a single server-sent event, see the HTML Living Standard section 9.2
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Stream writes events to a text/event-stream response. Every event is flushed to the
+// connection right away and a comment is sent every heartbeat interval to keep proxies
+// from timing out the connection.
+type Stream struct {
+	w         *response.Writer
+	mu        sync.Mutex
+	heartbeat time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type Option func(*Stream)
+
+// WithHeartbeat changes the heartbeat interval, zero disables heartbeats
+func WithHeartbeat(interval time.Duration) Option {
+	return func(s *Stream) {
+		s.heartbeat = interval
+	}
+}
+
+// NewStream writes the 200 response head and starts the heartbeat, the handler must
+// call Close once it is done sending events
+func NewStream(w *response.Writer, options ...Option) (*Stream, error) {
+	s := &Stream{
+		w:         w,
+		heartbeat: DefaultHeartbeat,
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return nil, err
+	}
+
+	w.SetHeader("Content-Type", "text/event-stream")
+	w.SetHeader("Cache-Control", "no-cache")
+	w.SetHeader("Connection", "close")
+	w.SetHeader("Transfer-Encoding", "chunked")
+	w.SetHeader("X-Accel-Buffering", "no")
+
+	if err := w.WriteHeaders(w.Headers); err != nil {
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	go s.watch(w.CloseNotify())
+
+	return s, nil
+}
+
+// Done is closed when the client disconnects or a write fails
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n\x00") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidField
+	}
+
+	var b strings.Builder
+
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+
+	for line := range strings.SplitSeq(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment sends a comment line, which clients ignore
+func (s *Stream) Comment(text string) error {
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+
+	return s.write(": " + text + "\n\n")
+}
+
+// Close stops the heartbeat and ends the chunked body
+func (s *Stream) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed() {
+		return nil
+	}
+
+	s.w.WriteChunkedBodyDone()
+	err := s.w.Flush()
+	s.markDone()
+
+	return err
+}
+
+func (s *Stream) write(payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed() {
+		return ErrStreamClosed
+	}
+
+	if _, err := s.w.WriteChunkedBody([]byte(payload)); err != nil {
+		s.markDone()
+		return err
+	}
+
+	if err := s.w.Flush(); err != nil {
+		s.markDone()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Stream) watch(clientGone <-chan struct{}) {
+	var ticks <-chan time.Time
+
+	if s.heartbeat > 0 {
+		ticker := time.NewTicker(s.heartbeat)
+		defer ticker.Stop()
+
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.done:
+			return
+		case <-clientGone:
+			s.mu.Lock()
+			s.markDone()
+			s.mu.Unlock()
+
+			return
+		case <-ticks:
+			s.Comment("heartbeat")
+		}
+	}
+}
+
+func (s *Stream) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Stream) markDone() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,89 @@
+package sse
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendFormatsEvents(t *testing.T) {
+	w := response.NewWriter()
+
+	stream, err := NewStream(w, WithHeartbeat(0))
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(Event{ID: "1", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second}))
+	require.NoError(t, stream.Comment("ping"))
+	require.ErrorIs(t, stream.Send(Event{ID: "bad\nid"}), ErrInvalidField)
+	require.NoError(t, stream.Close())
+
+	out := string(w.Body)
+	assert.Contains(t, out, "content-type: text/event-stream\r\n")
+	assert.Contains(t, out, "cache-control: no-cache\r\n")
+	assert.Contains(t, out, "id: 1\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n")
+	assert.Contains(t, out, ": ping\n\n")
+	assert.True(t, strings.HasSuffix(out, "0\r\n\r\n"))
+
+	assert.ErrorIs(t, stream.Send(Event{Data: "late"}), ErrStreamClosed)
+}
+
+func TestStreamFlushesAndNoticesDisconnect(t *testing.T) {
+	stopped := make(chan struct{})
+
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		stream, err := NewStream(w, WithHeartbeat(10*time.Millisecond))
+		if err != nil {
+			return &server.HandlerError{Message: err.Error(), Status: response.InternalServerError}
+		}
+
+		defer stream.Close()
+
+		stream.Send(Event{Data: "hello"})
+
+		select {
+		case <-stream.Done():
+			close(stopped)
+		case <-time.After(5 * time.Second):
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nAccept: text/event-stream\r\n\r\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	reader := bufio.NewReader(conn)
+	received := ""
+	for !strings.Contains(received, ": heartbeat\n") {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		received += line
+	}
+
+	assert.True(t, strings.HasPrefix(received, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, received, "data: hello\n")
+
+	conn.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not notice the client disconnect")
+	}
+}
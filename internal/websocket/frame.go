@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+const (
+	finBit  = 0x80
+	rsvBits = 0x70
+	maskBit = 0x80
+
+	maxControlPayload = 125
+)
+
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// frame is a single WebSocket frame (RFC 6455 section 5.2), payload is always unmasked
+type frame struct {
+	fin     bool
+	opcode  opcode
+	masked  bool
+	maskKey [4]byte
+	payload []byte
+}
+
+// readFrame reads one frame from r, payloads longer than maxPayload are rejected before
+// anything is allocated for them
+func readFrame(r io.Reader, maxPayload int64) (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	if head[0]&rsvBits != 0 {
+		return nil, protocolError("reserved bits set without a negotiated extension")
+	}
+
+	f := &frame{
+		fin:    head[0]&finBit != 0,
+		opcode: opcode(head[0] & 0x0F),
+		masked: head[1]&maskBit != 0,
+	}
+
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return nil, protocolError(fmt.Sprintf("reserved opcode %#x", byte(f.opcode)))
+	}
+
+	length := int64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+
+		if ext[0]&0x80 != 0 {
+			return nil, protocolError("payload length has the most significant bit set")
+		}
+
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if f.opcode.isControl() {
+		if !f.fin {
+			return nil, protocolError("fragmented control frame")
+		}
+
+		if length > maxControlPayload {
+			return nil, protocolError("control frame payload longer than 125 bytes")
+		}
+	}
+
+	if !f.opcode.isControl() && length > maxPayload {
+		return nil, &CloseError{Code: CloseMessageTooBig, Reason: "message too large"}
+	}
+
+	if f.masked {
+		if _, err := io.ReadFull(r, f.maskKey[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	if f.masked {
+		maskBytes(f.maskKey, f.payload)
+	}
+
+	return f, nil
+}
+
+// writeFrame encodes f, masking a copy of the payload when f.masked is set
+func writeFrame(w io.Writer, f *frame) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	first := byte(f.opcode)
+	if f.fin {
+		first |= finBit
+	}
+
+	buf = append(buf, first)
+
+	var second byte
+	if f.masked {
+		second = maskBit
+	}
+
+	length := len(f.payload)
+
+	switch {
+	case length <= 125:
+		buf = append(buf, second|byte(length))
+	case length <= 0xFFFF:
+		buf = append(buf, second|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, second|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if !f.masked {
+		buf = append(buf, f.payload...)
+
+		_, err := w.Write(buf)
+		return err
+	}
+
+	buf = append(buf, f.maskKey[:]...)
+	start := len(buf)
+	buf = append(buf, f.payload...)
+	maskBytes(f.maskKey, buf[start:])
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// maskBytes applies the masking algorithm of RFC 6455 section 5.3 in place, it is its
+// own inverse
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
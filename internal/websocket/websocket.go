@@ -0,0 +1,446 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kx0101/httpfromtcp/internal/headers"
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+)
+
+const (
+	// acceptGUID is appended to Sec-WebSocket-Key before hashing, RFC 6455 section 1.3
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	DefaultMaxMessageSize = 1 << 20
+
+	closeTimeout = 5 * time.Second
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// Close status codes, RFC 6455 section 7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+var (
+	ErrClosed             = errors.New("error: websocket connection closed")
+	ErrInvalidMessageType = errors.New("error: invalid websocket message type")
+	ErrControlTooLong     = errors.New("error: control frame payload longer than 125 bytes")
+)
+
+// CloseError is returned by ReadMessage once a close frame was received or sent
+// because the peer violated the protocol
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("error: websocket closed with %d: %s", e.Code, e.Reason)
+}
+
+func protocolError(reason string) *CloseError {
+	return &CloseError{Code: CloseProtocolError, Reason: reason}
+}
+
+// Conn is the server side of a WebSocket connection. One goroutine may read and another
+// may write at the same time, control frames can be sent from any goroutine.
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	maxMessageSize int64
+	subprotocols   []string
+	subprotocol    string
+
+	readMu    sync.Mutex
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+type Option func(*Conn)
+
+// WithMaxMessageSize limits the size of a reassembled message, larger messages close the
+// connection with 1009
+func WithMaxMessageSize(size int64) Option {
+	return func(c *Conn) {
+		c.maxMessageSize = size
+	}
+}
+
+// WithSubprotocols lists the subprotocols the server speaks in order of preference, the
+// first one the client also offers is selected
+func WithSubprotocols(protocols ...string) Option {
+	return func(c *Conn) {
+		c.subprotocols = protocols
+	}
+}
+
+// AcceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrade validates the opening handshake (RFC 6455 section 4.2.1), answers with 101
+// Switching Protocols and takes the connection over from the server. The handler should
+// return the HandlerError as is when the handshake is rejected.
+func Upgrade(w *response.Writer, req *request.Request, options ...Option) (*Conn, *server.HandlerError) {
+	c := &Conn{
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if req.RequestLine.Method != "GET" {
+		h := headers.Headers{}
+		h.Set("Allow", "GET")
+
+		return nil, &server.HandlerError{
+			Message: "WebSocket handshake must use GET",
+			Status:  response.MethodNotAllowed,
+			Headers: h,
+		}
+	}
+
+	if !headers.HasToken(req.Headers.Get("Connection"), "upgrade") || !headers.HasToken(req.Headers.Get("Upgrade"), "websocket") {
+		h := headers.Headers{}
+		h.Set("Upgrade", "websocket")
+		h.Set("Connection", "Upgrade")
+
+		return nil, &server.HandlerError{
+			Message: "Expected a WebSocket upgrade request",
+			Status:  response.UpgradeRequired,
+			Headers: h,
+		}
+	}
+
+	if req.Headers.Get("Sec-WebSocket-Version") != "13" {
+		h := headers.Headers{}
+		h.Set("Sec-WebSocket-Version", "13")
+
+		return nil, &server.HandlerError{
+			Message: "Unsupported WebSocket version",
+			Status:  response.UpgradeRequired,
+			Headers: h,
+		}
+	}
+
+	key := req.Headers.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, &server.HandlerError{
+			Message: "Invalid Sec-WebSocket-Key",
+			Status:  response.BadRequest,
+		}
+	}
+
+	c.subprotocol = c.selectSubprotocol(req.Headers.Get("Sec-WebSocket-Protocol"))
+
+	w.WriteStatusLine(response.SwitchingProtocols)
+	w.SetHeader("Upgrade", "websocket")
+	w.SetHeader("Connection", "Upgrade")
+	w.SetHeader("Sec-WebSocket-Accept", AcceptKey(key))
+
+	if c.subprotocol != "" {
+		w.SetHeader("Sec-WebSocket-Protocol", c.subprotocol)
+	}
+
+	if err := w.WriteHeaders(w.Headers); err != nil {
+		return nil, &server.HandlerError{Status: response.InternalServerError, Cause: err}
+	}
+
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return nil, &server.HandlerError{Status: response.InternalServerError, Cause: err}
+	}
+
+	c.conn = conn
+	c.br = rw.Reader
+
+	return c, nil
+}
+
+func (c *Conn) selectSubprotocol(offered string) string {
+	for _, protocol := range c.subprotocols {
+		if headers.HasToken(offered, protocol) {
+			return protocol
+		}
+	}
+
+	return ""
+}
+
+// Subprotocol is the protocol selected during the handshake, if any
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message with its fragments reassembled.
+// Pings are answered and pongs are dropped along the way. When the peer closes the
+// connection, or breaks the protocol, the connection is closed and a *CloseError returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	var typ MessageType
+	var message []byte
+
+	for {
+		f, err := readFrame(c.br, c.maxMessageSize-int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		if !f.masked {
+			return 0, nil, c.fail(protocolError("client frame is not masked"))
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.write(&frame{fin: true, opcode: opPong, payload: f.payload}); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(protocolError("new message before the previous one was finished"))
+			}
+
+			typ = MessageType(f.opcode)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(protocolError("continuation frame without a message"))
+			}
+		}
+
+		message = append(message, f.payload...)
+
+		if f.fin {
+			if typ == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "text message is not valid UTF-8"})
+			}
+
+			return typ, message, nil
+		}
+	}
+}
+
+// WriteMessage sends data as a single unfragmented frame
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return ErrInvalidMessageType
+	}
+
+	return c.write(&frame{fin: true, opcode: opcode(typ), payload: data})
+}
+
+// NextWriter returns a writer that sends every Write as a fragment of one message, the
+// message ends when the writer is closed. Only one message can be written at a time.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, ErrInvalidMessageType
+	}
+
+	return &messageWriter{c: c, opcode: opcode(typ)}, nil
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrControlTooLong
+	}
+
+	return c.write(&frame{fin: true, opcode: opPing, payload: data})
+}
+
+// Close starts the closing handshake and closes the connection once the peer answered
+// or closeTimeout passed. A goroutine blocked in ReadMessage returns a *CloseError.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	if err == ErrClosed {
+		return c.conn.Close()
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+
+	if !c.readMu.TryLock() {
+		// the reader sees the close frame, or the deadline, and closes the connection
+		return err
+	}
+
+	defer c.readMu.Unlock()
+
+	for {
+		f, readErr := readFrame(c.br, c.maxMessageSize)
+		if readErr != nil || f.opcode == opClose {
+			break
+		}
+	}
+
+	c.conn.Close()
+
+	return err
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+
+	if len(payload) == 1 {
+		return c.fail(protocolError("close frame payload of one byte"))
+	}
+
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(protocolError(fmt.Sprintf("invalid close code %d", closeErr.Code)))
+		}
+
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "close reason is not valid UTF-8"})
+		}
+	}
+
+	c.sendClose(closeErr.Code, "")
+	c.conn.Close()
+
+	return closeErr
+}
+
+// fail closes the connection, telling the peer why when err is a *CloseError
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.sendClose(closeErr.Code, closeErr.Reason)
+	}
+
+	c.conn.Close()
+
+	return err
+}
+
+func (c *Conn) sendClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	c.closeSent = true
+
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, truncateReason(reason)...)
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+
+	return writeFrame(c.conn, &frame{fin: true, opcode: opClose, payload: payload})
+}
+
+func (c *Conn) write(f *frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	return writeFrame(c.conn, f)
+}
+
+type messageWriter struct {
+	c      *Conn
+	opcode opcode
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if err := w.c.write(&frame{opcode: w.opcode, payload: p}); err != nil {
+		return 0, err
+	}
+
+	w.opcode = opContinuation
+
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	return w.c.write(&frame{fin: true, opcode: w.opcode})
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+// truncateReason keeps the close payload within the 125 bytes of a control frame
+// without cutting a UTF-8 sequence in half
+func truncateReason(reason string) string {
+	const maxReason = maxControlPayload - 2
+
+	if len(reason) <= maxReason {
+		return reason
+	}
+
+	reason = reason[:maxReason]
+	for !utf8.ValidString(reason) {
+		reason = reason[:len(reason)-1]
+	}
+
+	return reason
+}
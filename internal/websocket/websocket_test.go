@@ -0,0 +1,313 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kx0101/httpfromtcp/internal/request"
+	"github.com/kx0101/httpfromtcp/internal/response"
+	"github.com/kx0101/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func startEchoServer(t *testing.T, options ...Option) *server.Server {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, herr := Upgrade(w, req, options...)
+		if herr != nil {
+			return herr
+		}
+
+		for {
+			typ, message, err := conn.ReadMessage()
+			if err != nil {
+				return nil
+			}
+
+			if err := conn.WriteMessage(typ, message); err != nil {
+				return nil
+			}
+		}
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return srv
+}
+
+// dialWebSocket performs the opening handshake and returns the connection positioned at
+// the first frame
+func dialWebSocket(t *testing.T, srv *server.Server, extra string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("GET /ws HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + testKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		extra + "\r\n"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	head := ""
+
+	for !strings.HasSuffix(head, "\r\n\r\n") {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+
+		head += line
+	}
+
+	return conn, br, head
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, fin bool, op opcode, payload []byte) {
+	err := writeFrame(conn, &frame{fin: fin, opcode: op, masked: true, maskKey: [4]byte{1, 2, 3, 4}, payload: payload})
+	require.NoError(t, err)
+}
+
+func readServerFrame(t *testing.T, br *bufio.Reader) *frame {
+	f, err := readFrame(br, DefaultMaxMessageSize)
+	require.NoError(t, err)
+	assert.False(t, f.masked)
+
+	return f
+}
+
+func TestAcceptKey(t *testing.T) {
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 65535, 65536} {
+		payload := bytes.Repeat([]byte("x"), size)
+
+		var buf bytes.Buffer
+		require.NoError(t, writeFrame(&buf, &frame{fin: true, opcode: opBinary, masked: true, maskKey: [4]byte{9, 8, 7, 6}, payload: payload}))
+
+		f, err := readFrame(&buf, DefaultMaxMessageSize)
+		require.NoError(t, err, size)
+		assert.True(t, f.fin)
+		assert.Equal(t, opBinary, f.opcode)
+		assert.Equal(t, payload, f.payload)
+		assert.Equal(t, bytes.Repeat([]byte("x"), size), payload, "writeFrame must not mask the caller's payload")
+	}
+
+	_, err := readFrame(bytes.NewReader([]byte{0x89, 0x7E, 0x00, 0x80}), DefaultMaxMessageSize)
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+
+	_, err = readFrame(bytes.NewReader([]byte{0xC1, 0x00}), DefaultMaxMessageSize)
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+}
+
+func TestUpgradeRejectsInvalidHandshakes(t *testing.T) {
+	srv := startEchoServer(t)
+
+	tests := []struct {
+		name   string
+		raw    string
+		status string
+		header string
+	}{
+		{
+			name:   "wrong method",
+			raw:    "POST /ws HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n",
+			status: "HTTP/1.1 405 Method Not Allowed\r\n",
+			header: "allow: GET\r\n",
+		},
+		{
+			name:   "not an upgrade",
+			raw:    "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status: "HTTP/1.1 426 Upgrade Required\r\n",
+			header: "upgrade: websocket\r\n",
+		},
+		{
+			name:   "unsupported version",
+			raw:    "GET /ws HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 8\r\nSec-WebSocket-Key: " + testKey + "\r\n\r\n",
+			status: "HTTP/1.1 426 Upgrade Required\r\n",
+			header: "sec-websocket-version: 13\r\n",
+		},
+		{
+			name:   "bad key",
+			raw:    "GET /ws HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: c2hvcnQ=\r\n\r\n",
+			status: "HTTP/1.1 400 Bad Request\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = conn.Write([]byte(tt.raw))
+			require.NoError(t, err)
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			out, err := io.ReadAll(conn)
+			require.NoError(t, err)
+
+			assert.True(t, strings.HasPrefix(string(out), tt.status), string(out))
+			assert.Contains(t, string(out), tt.header)
+		})
+	}
+}
+
+func TestEchoWithFragmentsPingAndClose(t *testing.T) {
+	srv := startEchoServer(t, WithSubprotocols("chat", "superchat"))
+
+	conn, br, head := dialWebSocket(t, srv, "Sec-WebSocket-Protocol: superchat, chat\r\n")
+
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"), head)
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "sec-websocket-protocol: chat\r\n")
+
+	writeClientFrame(t, conn, false, opText, []byte("hel"))
+	writeClientFrame(t, conn, true, opPing, []byte("are you there"))
+	writeClientFrame(t, conn, true, opContinuation, []byte("lo"))
+
+	pong := readServerFrame(t, br)
+	assert.Equal(t, opPong, pong.opcode)
+	assert.Equal(t, "are you there", string(pong.payload))
+
+	echo := readServerFrame(t, br)
+	assert.Equal(t, opText, echo.opcode)
+	assert.True(t, echo.fin)
+	assert.Equal(t, "hello", string(echo.payload))
+
+	writeClientFrame(t, conn, true, opClose, append(binary.BigEndian.AppendUint16(nil, CloseNormal), "bye"...))
+
+	closeFrame := readServerFrame(t, br)
+	assert.Equal(t, opClose, closeFrame.opcode)
+	assert.Equal(t, CloseNormal, int(binary.BigEndian.Uint16(closeFrame.payload)))
+
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProtocolViolationsCloseTheConnection(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		send    func(t *testing.T, conn net.Conn)
+		code    int
+	}{
+		{
+			name: "unmasked frame",
+			send: func(t *testing.T, conn net.Conn) {
+				require.NoError(t, writeFrame(conn, &frame{fin: true, opcode: opText, payload: []byte("hi")}))
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:    "message too big",
+			options: []Option{WithMaxMessageSize(8)},
+			send: func(t *testing.T, conn net.Conn) {
+				writeClientFrame(t, conn, false, opBinary, []byte("12345"))
+				writeClientFrame(t, conn, true, opContinuation, []byte("67890"))
+			},
+			code: CloseMessageTooBig,
+		},
+		{
+			name: "invalid utf-8",
+			send: func(t *testing.T, conn net.Conn) {
+				writeClientFrame(t, conn, true, opText, []byte{0xff, 0xfe})
+			},
+			code: CloseInvalidPayload,
+		},
+		{
+			name: "continuation without message",
+			send: func(t *testing.T, conn net.Conn) {
+				writeClientFrame(t, conn, true, opContinuation, []byte("x"))
+			},
+			code: CloseProtocolError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startEchoServer(t, tt.options...)
+			conn, br, _ := dialWebSocket(t, srv, "")
+
+			tt.send(t, conn)
+
+			f := readServerFrame(t, br)
+			require.Equal(t, opClose, f.opcode)
+			assert.Equal(t, tt.code, int(binary.BigEndian.Uint16(f.payload)))
+		})
+	}
+}
+
+func TestServerInitiatedClose(t *testing.T) {
+	closed := make(chan error, 1)
+
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, herr := Upgrade(w, req)
+		if herr != nil {
+			return herr
+		}
+
+		nw, err := conn.NextWriter(BinaryMessage)
+		require.NoError(t, err)
+
+		nw.Write([]byte("ab"))
+		nw.Write([]byte("cd"))
+		nw.Close()
+
+		closed <- conn.Close(CloseGoingAway, "shutting down")
+
+		return nil
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, br, _ := dialWebSocket(t, srv, "")
+
+	first := readServerFrame(t, br)
+	assert.Equal(t, opBinary, first.opcode)
+	assert.False(t, first.fin)
+
+	second := readServerFrame(t, br)
+	assert.Equal(t, opContinuation, second.opcode)
+	assert.Equal(t, "cd", string(second.payload))
+
+	last := readServerFrame(t, br)
+	assert.True(t, last.fin)
+	assert.Empty(t, last.payload)
+
+	closeFrame := readServerFrame(t, br)
+	require.Equal(t, opClose, closeFrame.opcode)
+	assert.Equal(t, CloseGoingAway, int(binary.BigEndian.Uint16(closeFrame.payload)))
+	assert.Equal(t, "shutting down", string(closeFrame.payload[2:]))
+
+	writeClientFrame(t, conn, true, opClose, closeFrame.payload[:2])
+
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return after the peer answered")
+	}
+}